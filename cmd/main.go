@@ -4,17 +4,31 @@ import (
 	"avito-shop/internal/api"
 	"avito-shop/internal/config"
 	"avito-shop/internal/db"
+	"avito-shop/internal/jwtkeys"
 	"avito-shop/internal/middleware"
 	"avito-shop/internal/service"
+	"avito-shop/internal/token"
 	"avito-shop/pkg"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			os.Exit(reconcile(os.Args[2:]))
+		case "set-role":
+			os.Exit(setRole(os.Args[2:]))
+		}
+	}
+
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
@@ -34,23 +48,81 @@ func main() {
 	}(zapLogger)
 	logger := pkg.NewZapLogger(zapLogger)
 
+	keys, err := loadKeys(cfg, logger)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
 	authDB := db.NewAuthDB(dbConn)
+	tokenDB := db.NewRefreshTokenDB(dbConn)
+	revocationDB := db.NewRevocationDB(dbConn)
+	loginAttemptDB := db.NewLoginAttemptDB(dbConn)
 	coinDB := db.NewCoinInventoryDB(dbConn)
+	catalogDB := db.NewCatalogDB(dbConn)
+	cartDB := db.NewCartDB(dbConn)
+	orderDB := db.NewOrderDB(dbConn)
+	giftDB := db.NewGiftDB(dbConn)
+	listingDB := db.NewListingDB(dbConn)
+	promoDB := db.NewPromoDB(dbConn)
 
-	authService := service.NewAuthService(authDB, logger, cfg.JWTSecret)
-	shopService := service.NewShopService(coinDB, logger)
+	hasher := service.NewArgon2idHasher(service.Argon2idParams{
+		Time:      cfg.PasswordHashTime,
+		MemoryKiB: cfg.PasswordHashMemoryKiB,
+		Threads:   cfg.PasswordHashThreads,
+	})
+	revocations := service.NewRevocationStore(revocationDB, tokenDB, logger, cfg.RevocationCacheTTL)
+	go service.PruneRevokedTokens(context.Background(), revocationDB, logger, cfg.RevokedTokensPruneInterval)
+	throttle := service.NewLoginThrottle(loginAttemptDB, logger, service.ThrottleConfig{
+		MaxUserFailures: cfg.LoginMaxFailures,
+		MaxIPFailures:   cfg.LoginMaxFailuresPerIP,
+		BackoffBase:     cfg.LoginBackoffBase,
+		Lockout:         cfg.LoginLockout,
+	})
+	authService := service.NewAuthService(authDB, tokenDB, revocations, throttle, hasher, logger, service.TokenConfig{
+		Signer:     keys,
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		AccessTTL:  cfg.AccessTokenTTL,
+		RefreshTTL: cfg.RefreshTokenTTL,
+	})
+	shopService := service.NewShopService(coinDB, catalogDB, orderDB, giftDB, promoDB, logger, service.ShopConfig{
+		RefundWindow: cfg.RefundWindow,
+	})
+	catalogService := service.NewCatalogService(catalogDB, logger)
+	cartService := service.NewCartService(coinDB, catalogDB, orderDB, cartDB, promoDB, logger)
+	marketService := service.NewMarketService(coinDB, catalogDB, listingDB, logger, service.MarketConfig{
+		FeePercent: cfg.MarketFeePercent,
+	})
+	promoService := service.NewPromoService(promoDB, logger)
 
 	e := echo.New()
-	e.Use(middleware.JWTAuthMiddleware(cfg.JWTSecret, zapLogger))
+	// login throttling is keyed by client IP, so forwarded headers must not be trusted
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.Use(middleware.JWTAuthMiddleware(middleware.JWTConfig{
+		Keyfunc: keys.Keyfunc,
+		Expectations: token.Expectations{
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+			Leeway:   cfg.JWTLeeway,
+		},
+		Skipper:     middleware.PublicRoutes(api.PublicRoutes...),
+		Revocations: revocations,
+	}, zapLogger))
+	e.Use(middleware.Authorize(api.RoutePolicies, zapLogger))
 
 	handlers := &api.Handlers{
-		AuthService: authService,
-		ShopService: shopService,
-		Logger:      logger,
-		JWTSecret:   cfg.JWTSecret,
+		AuthService:    authService,
+		ShopService:    shopService,
+		CatalogService: catalogService,
+		CartService:    cartService,
+		MarketService:  marketService,
+		PromoService:   promoService,
+		Logger:         logger,
+		Keys:           keys,
 	}
 
 	api.RegisterHandlers(e, handlers)
+	e.GET(api.JWKSPath, handlers.GetJWKS)
 
 	port := fmt.Sprintf(":%s", cfg.ServerPort)
 	logger.Info("Starting server", zap.String("port", cfg.ServerPort))
@@ -58,3 +130,19 @@ func main() {
 		logger.Error("Failed to run server", zap.Error(err))
 	}
 }
+
+func loadKeys(cfg *config.Config, logger pkg.Logger) (*jwtkeys.KeySet, error) {
+	if cfg.JWTSigningKeyFile == "" {
+		if !cfg.JWTEphemeralKey {
+			return nil, errors.New("JWT_SIGNING_KEY_FILE is not set; generate a key with make keys, or set JWT_EPHEMERAL_KEY=true for development")
+		}
+		logger.Warn("JWT_EPHEMERAL_KEY is set, signing with a key that is lost on restart")
+		return jwtkeys.Generate()
+	}
+	keys, err := jwtkeys.Load(cfg.JWTSigningKeyFile, cfg.JWTVerificationKeyFiles)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("JWT keys loaded", zap.String("kid", keys.SigningKeyID()))
+	return keys, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"avito-shop/internal/config"
+	"avito-shop/internal/db"
+	"avito-shop/internal/service"
+	"avito-shop/pkg"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Exit codes of the reconcile subcommand.
+const (
+	reconcileOK = iota
+	reconcileFailed
+	reconcileUsage
+	// reconcileDiscrepancies is returned while discrepancies are left
+	// unrepaired, so that scheduled runs can alert on them.
+	reconcileDiscrepancies
+)
+
+type reconcileReport struct {
+	CheckedAt time.Time `json:"checkedAt"`
+	// DryRun is true unless -repair was given.
+	DryRun            bool                  `json:"dryRun"`
+	Users             int                   `json:"users"`
+	UserIDs           []int                 `json:"userIds"`
+	Discrepancies     []reconcileDifference `json:"discrepancies"`
+	UnbalancedEntries []int                 `json:"unbalancedEntries"`
+}
+
+type reconcileDifference struct {
+	UserID   int    `json:"userId"`
+	Username string `json:"username"`
+	Coins    int    `json:"coins"`
+	// Expected is absent for users without a ledger account.
+	Expected *int `json:"expected,omitempty"`
+	Repaired bool `json:"repaired"`
+}
+
+// reconcile checks the coins of every user against the ledger and writes a
+// JSON report of the users that differ. Coins are only repaired with -repair,
+// so a run without it shows what a repair would change.
+func reconcile(args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "set the coins of users that differ to their ledger balance")
+	out := flags.String("out", "", "write the report to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return reconcileUsage
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return reconcileFailed
+	}
+	dbConn, err := db.Connect(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return reconcileFailed
+	}
+	defer dbConn.Close()
+
+	zapLogger, _ := zap.NewProduction()
+	defer func(logger *zap.Logger) {
+		_ = logger.Sync()
+	}(zapLogger)
+
+	svc := service.NewReconcileService(db.NewLedgerDB(dbConn), pkg.NewZapLogger(zapLogger))
+	report, err := svc.Reconcile(*repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to reconcile: %v\n", err)
+		return reconcileFailed
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create report: %v\n", err)
+			return reconcileFailed
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(toReconcileReport(report)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		return reconcileFailed
+	}
+
+	if len(report.Unresolved()) > 0 {
+		return reconcileDiscrepancies
+	}
+	return reconcileOK
+}
+
+func toReconcileReport(r service.ReconcileReport) reconcileReport {
+	report := reconcileReport{
+		CheckedAt:         r.CheckedAt,
+		DryRun:            !r.Repair,
+		Users:             r.Users,
+		UserIDs:           []int{},
+		Discrepancies:     []reconcileDifference{},
+		UnbalancedEntries: []int{},
+	}
+	for _, d := range r.Discrepancies {
+		diff := reconcileDifference{
+			UserID:   d.UserID,
+			Username: d.Username,
+			Coins:    d.Coins,
+			Repaired: d.Repaired,
+		}
+		if !d.NoAccount {
+			diff.Expected = &d.Expected
+		}
+		report.UserIDs = append(report.UserIDs, d.UserID)
+		report.Discrepancies = append(report.Discrepancies, diff)
+	}
+	report.UnbalancedEntries = append(report.UnbalancedEntries, r.UnbalancedEntries...)
+	return report
+}
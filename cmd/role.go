@@ -0,0 +1,90 @@
+package main
+
+import (
+	"avito-shop/internal/config"
+	"avito-shop/internal/db"
+	"avito-shop/internal/service"
+	"avito-shop/internal/token"
+	"avito-shop/pkg"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// Exit codes of the set-role subcommand.
+const (
+	setRoleOK = iota
+	setRoleFailed
+	setRoleUsage
+)
+
+// setRole changes the role of a user. It is the way to make the first admin,
+// who can then manage roles through the API; the user has to have logged in
+// once.
+func setRole(args []string) int {
+	flags := flag.NewFlagSet("set-role", flag.ContinueOnError)
+	username := flags.String("user", "", "username of the user")
+	role := flags.String("role", token.RoleAdmin, "role to give: employee, admin or auditor")
+	if err := flags.Parse(args); err != nil {
+		return setRoleUsage
+	}
+	if *username == "" || !token.IsValidRole(*role) {
+		flags.Usage()
+		return setRoleUsage
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return setRoleFailed
+	}
+	dbConn, err := db.Connect(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return setRoleFailed
+	}
+	defer dbConn.Close()
+
+	zapLogger, _ := zap.NewProduction()
+	defer func(logger *zap.Logger) {
+		_ = logger.Sync()
+	}(zapLogger)
+	logger := pkg.NewZapLogger(zapLogger)
+
+	authDB := db.NewAuthDB(dbConn)
+	user, err := authDB.GetUserAuthData(*username)
+	if errors.Is(err, sql.ErrNoRows) {
+		fmt.Fprintf(os.Stderr, "User %q not found, they have to log in once first\n", *username)
+		return setRoleFailed
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find user: %v\n", err)
+		return setRoleFailed
+	}
+
+	// the role change revokes the sessions of the user, as through the API
+	tokenDB := db.NewRefreshTokenDB(dbConn)
+	revocations := service.NewRevocationStore(db.NewRevocationDB(dbConn), tokenDB, logger, cfg.RevocationCacheTTL)
+	throttle := service.NewLoginThrottle(db.NewLoginAttemptDB(dbConn), logger, service.ThrottleConfig{
+		MaxUserFailures: cfg.LoginMaxFailures,
+		MaxIPFailures:   cfg.LoginMaxFailuresPerIP,
+		BackoffBase:     cfg.LoginBackoffBase,
+		Lockout:         cfg.LoginLockout,
+	})
+	hasher := service.NewArgon2idHasher(service.Argon2idParams{
+		Time:      cfg.PasswordHashTime,
+		MemoryKiB: cfg.PasswordHashMemoryKiB,
+		Threads:   cfg.PasswordHashThreads,
+	})
+	authService := service.NewAuthService(authDB, tokenDB, revocations, throttle, hasher, logger, service.TokenConfig{})
+	if err := authService.SetRole(user.ID, *role); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set role: %v\n", err)
+		return setRoleFailed
+	}
+	fmt.Printf("%s is now %s\n", *username, *role)
+	return setRoleOK
+}
@@ -4,8 +4,10 @@ import (
 	"avito-shop/internal/api"
 	"avito-shop/internal/config"
 	"avito-shop/internal/db"
+	"avito-shop/internal/jwtkeys"
 	"avito-shop/internal/middleware"
 	"avito-shop/internal/service"
+	"avito-shop/internal/token"
 	"avito-shop/pkg"
 	"database/sql"
 	"encoding/json"
@@ -13,10 +15,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
@@ -31,14 +33,49 @@ func setupTestDB(t *testing.T) *sql.DB {
 		t.Fatalf("failed to connect to db: %v", err)
 	}
 	db.Migrate(dbConn, "../migrations")
-	_, err = dbConn.Exec("TRUNCATE TABLE coin_transactions, inventories, users RESTART IDENTITY CASCADE")
-	if err != nil {
+	truncateTestDB(t, dbConn, "login_attempts, coin_transactions, inventories, users")
+	return dbConn
+}
+
+// truncateTestDB empties the tables and those referencing them. The system
+// ledger accounts go with the users and are opened again.
+func truncateTestDB(t *testing.T, dbConn *sql.DB, tables string) {
+	t.Helper()
+	if _, err := dbConn.Exec("TRUNCATE TABLE " + tables + " RESTART IDENTITY CASCADE"); err != nil {
 		t.Fatalf("failed to truncate tables: %v", err)
 	}
-	return dbConn
+	if _, err := dbConn.Exec("INSERT INTO ledger_accounts (kind) VALUES ('issuance'), ('revenue')"); err != nil {
+		t.Fatalf("failed to open system accounts: %v", err)
+	}
+}
+
+// checkLedger checks that the ledger is balanced and that the coins of every
+// user are the balance of their account.
+func checkLedger(t *testing.T, dbConn *sql.DB) {
+	t.Helper()
+	var sum int
+	if err := dbConn.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM journal_postings").Scan(&sum); err != nil {
+		t.Fatalf("failed to sum postings: %v", err)
+	}
+	if sum != 0 {
+		t.Errorf("expected the postings to sum up to 0, got %d", sum)
+	}
+	var drifted int
+	err := dbConn.QueryRow(`
+SELECT COUNT(*) FROM users u
+JOIN ledger_accounts a ON a.user_id = u.id
+WHERE u.coins <> (SELECT COALESCE(SUM(p.amount), 0) FROM journal_postings p WHERE p.account_id = a.id)
+`).Scan(&drifted)
+	if err != nil {
+		t.Fatalf("failed to compare balances: %v", err)
+	}
+	if drifted != 0 {
+		t.Errorf("expected coins to match the ledger, %d users differ", drifted)
+	}
 }
 
-func createTestServer(dbConn *sql.DB, cfg *config.Config, log pkg.Logger) *echo.Echo {
+func createTestServer(t *testing.T, dbConn *sql.DB, cfg *config.Config, log pkg.Logger) *echo.Echo {
+	t.Helper()
 	e := echo.New()
 	zapLogger, _ := zap.NewProduction()
 	defer func(logger *zap.Logger) {
@@ -46,43 +83,102 @@ func createTestServer(dbConn *sql.DB, cfg *config.Config, log pkg.Logger) *echo.
 	}(zapLogger)
 	logger := pkg.NewZapLogger(zapLogger)
 
+	keys, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+
 	authDB := db.NewAuthDB(dbConn)
+	tokenDB := db.NewRefreshTokenDB(dbConn)
+	revocationDB := db.NewRevocationDB(dbConn)
+	loginAttemptDB := db.NewLoginAttemptDB(dbConn)
 	coinDB := db.NewCoinInventoryDB(dbConn)
+	catalogDB := db.NewCatalogDB(dbConn)
+	cartDB := db.NewCartDB(dbConn)
+	orderDB := db.NewOrderDB(dbConn)
+	giftDB := db.NewGiftDB(dbConn)
+	listingDB := db.NewListingDB(dbConn)
+	promoDB := db.NewPromoDB(dbConn)
 
-	authService := service.NewAuthService(authDB, logger, cfg.JWTSecret)
-	shopService := service.NewShopService(coinDB, logger)
-	e.Use(middleware.JWTAuthMiddleware(cfg.JWTSecret, zapLogger))
+	hasher := service.NewArgon2idHasher(service.Argon2idParams{
+		Time:      cfg.PasswordHashTime,
+		MemoryKiB: cfg.PasswordHashMemoryKiB,
+		Threads:   cfg.PasswordHashThreads,
+	})
+	revocations := service.NewRevocationStore(revocationDB, tokenDB, logger, cfg.RevocationCacheTTL)
+	throttle := service.NewLoginThrottle(loginAttemptDB, logger, service.ThrottleConfig{
+		MaxUserFailures: cfg.LoginMaxFailures,
+		MaxIPFailures:   cfg.LoginMaxFailuresPerIP,
+		BackoffBase:     cfg.LoginBackoffBase,
+		Lockout:         cfg.LoginLockout,
+	})
+	authService := service.NewAuthService(authDB, tokenDB, revocations, throttle, hasher, logger, service.TokenConfig{
+		Signer:     keys,
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		AccessTTL:  cfg.AccessTokenTTL,
+		RefreshTTL: cfg.RefreshTokenTTL,
+	})
+	shopService := service.NewShopService(coinDB, catalogDB, orderDB, giftDB, promoDB, logger, service.ShopConfig{
+		RefundWindow: cfg.RefundWindow,
+	})
+	catalogService := service.NewCatalogService(catalogDB, logger)
+	cartService := service.NewCartService(coinDB, catalogDB, orderDB, cartDB, promoDB, logger)
+	marketService := service.NewMarketService(coinDB, catalogDB, listingDB, logger, service.MarketConfig{
+		FeePercent: cfg.MarketFeePercent,
+	})
+	promoService := service.NewPromoService(promoDB, logger)
+	e.Use(middleware.JWTAuthMiddleware(middleware.JWTConfig{
+		Keyfunc: keys.Keyfunc,
+		Expectations: token.Expectations{
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+			Leeway:   cfg.JWTLeeway,
+		},
+		Skipper:     middleware.PublicRoutes(api.PublicRoutes...),
+		Revocations: revocations,
+	}, zapLogger))
+	e.Use(middleware.Authorize(api.RoutePolicies, zapLogger))
 
 	handlers := &api.Handlers{
-		AuthService: authService,
-		ShopService: shopService,
-		Logger:      logger,
-		JWTSecret:   cfg.JWTSecret,
+		AuthService:    authService,
+		ShopService:    shopService,
+		CatalogService: catalogService,
+		CartService:    cartService,
+		MarketService:  marketService,
+		PromoService:   promoService,
+		Logger:         logger,
+		Keys:           keys,
 	}
 
 	api.RegisterHandlers(e, handlers)
+	e.GET(api.JWKSPath, handlers.GetJWKS)
 	return e
 }
 
-func registerTestUser(dbConn *sql.DB, username, password string, coins int) (int, error) {
-	var id int
-	err := dbConn.QueryRow(
-		"INSERT INTO users (username, password_hash, coins) VALUES ($1, $2, $3) RETURNING id",
-		username, password, coins,
-	).Scan(&id)
-	return id, err
-}
+func loginTestUser(t *testing.T, serverURL, username, password string) string {
+	t.Helper()
+	reqBody := fmt.Sprintf(`{"username":%q,"password":%q}`, username, password)
+	resp, err := http.Post(serverURL+"/api/auth", "application/json", strings.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("failed to perform auth request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected auth status 200, got %d", resp.StatusCode)
+	}
 
-func generateToken(jwtSecret string, userID int, username string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
-		"exp":      time.Now().Add(1 * time.Hour).Unix(),
-	})
-	return token.SignedString([]byte(jwtSecret))
+	var body api.AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode auth response: %v", err)
+	}
+	if body.Token == nil || *body.Token == "" {
+		t.Fatalf("expected token in auth response")
+	}
+	return *body.Token
 }
 
-func TestIntegration_BuyMerch(t *testing.T) {
+func TestIntegration_AuthRegistersNewUser(t *testing.T) {
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		t.Fatalf("failed to load config: %v", err)
@@ -91,20 +187,60 @@ func TestIntegration_BuyMerch(t *testing.T) {
 	dbConn := setupTestDB(t)
 	defer dbConn.Close()
 
-	userID, err := registerTestUser(dbConn, "buyer", "pass", 500)
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	token := loginTestUser(t, ts.URL, "newcomer", "pass")
+	if again := loginTestUser(t, ts.URL, "newcomer", "pass"); again == "" {
+		t.Fatalf("expected repeated login to succeed")
+	}
+
+	resp, err := http.Post(ts.URL+"/api/auth", "application/json",
+		strings.NewReader(`{"username":"newcomer","password":"wrong"}`))
 	if err != nil {
-		t.Fatalf("failed to register user: %v", err)
+		t.Fatalf("failed to perform auth request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for wrong password, got %d", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/info", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
 	}
+	defer resp.Body.Close()
+
+	var info api.InfoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if info.Coins == nil || *info.Coins != service.InitialCoins {
+		t.Errorf("expected %d coins for a new user, got %v", service.InitialCoins, info.Coins)
+	}
+}
 
-	token, err := generateToken(cfg.JWTSecret, userID, "buyer")
+func TestIntegration_BuyMerch(t *testing.T) {
+	cfg, err := config.LoadConfig()
 	if err != nil {
-		t.Fatalf("failed to generate token: %v", err)
+		t.Fatalf("failed to load config: %v", err)
 	}
 
-	e := createTestServer(dbConn, cfg, zap.NewNop())
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
 	ts := httptest.NewServer(e)
 	defer ts.Close()
 
+	token := loginTestUser(t, ts.URL, "buyer", "pass")
+
 	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/buy/t-shirt", ts.URL), nil)
 	if err != nil {
 		t.Fatalf("failed to create request: %v", err)
@@ -141,24 +277,13 @@ func TestIntegration_SendCoins(t *testing.T) {
 	dbConn := setupTestDB(t)
 	defer dbConn.Close()
 
-	senderID, err := registerTestUser(dbConn, "sender", "pass", 500)
-	if err != nil {
-		t.Fatalf("failed to register sender: %v", err)
-	}
-	_, err = registerTestUser(dbConn, "recipient", "pass", 100)
-	if err != nil {
-		t.Fatalf("failed to register recipient: %v", err)
-	}
-
-	token, err := generateToken(cfg.JWTSecret, senderID, "sender")
-	if err != nil {
-		t.Fatalf("failed to generate token: %v", err)
-	}
-
-	e := createTestServer(dbConn, cfg, zap.NewNop())
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
 	ts := httptest.NewServer(e)
 	defer ts.Close()
 
+	token := loginTestUser(t, ts.URL, "sender", "pass")
+	loginTestUser(t, ts.URL, "recipient", "pass")
+
 	reqBody := `{"toUser":"recipient","amount":50}`
 	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/sendCoin", ts.URL), strings.NewReader(reqBody))
 	if err != nil {
@@ -185,4 +310,450 @@ func TestIntegration_SendCoins(t *testing.T) {
 	if msg, ok := body["message"]; !ok || msg != "Coins sent successfully" {
 		t.Errorf("unexpected response message: %v", body)
 	}
+	checkLedger(t, dbConn)
+}
+
+// doJSON performs an authorized request and returns the status code; the
+// response is decoded into out unless it is nil.
+func doJSON(t *testing.T, method, url, token, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Errorf("failed to create request: %v", err)
+		return 0
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Errorf("failed to perform request: %v", err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// listTShirt buys a t-shirt for a new seller and lists it for 100 coins.
+func listTShirt(t *testing.T, serverURL string) (string, api.Listing) {
+	t.Helper()
+	seller := loginTestUser(t, serverURL, "seller", "pass")
+	if code := doJSON(t, http.MethodGet, serverURL+"/api/buy/t-shirt", seller, "", nil); code != http.StatusOK {
+		t.Fatalf("expected status 200 buying a t-shirt, got %d", code)
+	}
+	var listing api.Listing
+	code := doJSON(t, http.MethodPost, serverURL+"/api/market/listings", seller, `{"item":"t-shirt","price":100}`, &listing)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200 listing the t-shirt, got %d", code)
+	}
+	return seller, listing
+}
+
+// countTShirts counts the t-shirts owned by anyone.
+func countTShirts(t *testing.T, dbConn *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := dbConn.QueryRow("SELECT COALESCE(SUM(quantity), 0) FROM inventories WHERE item_type='t-shirt'").Scan(&n); err != nil {
+		t.Fatalf("failed to count t-shirts: %v", err)
+	}
+	return n
+}
+
+func TestIntegration_MarketListingSoldOnce(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	cfg.MarketFeePercent = 5
+
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	_, listing := listTShirt(t, ts.URL)
+	if n := countTShirts(t, dbConn); n != 0 {
+		t.Fatalf("expected the t-shirt to be held by the listing, %d are in inventories", n)
+	}
+
+	const buyers = 10
+	tokens := make([]string, buyers)
+	for i := range tokens {
+		tokens[i] = loginTestUser(t, ts.URL, fmt.Sprintf("buyer%d", i), "pass")
+	}
+
+	codes := make([]int, buyers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			codes[i] = doJSON(t, http.MethodPost, fmt.Sprintf("%s/api/market/listings/%d/buy", ts.URL, listing.Id), tokens[i], "", nil)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	sold := 0
+	for i, code := range codes {
+		switch code {
+		case http.StatusOK:
+			sold++
+		case http.StatusConflict:
+		default:
+			t.Errorf("buyer%d: expected status 200 or 409, got %d", i, code)
+		}
+	}
+	if sold != 1 {
+		t.Fatalf("expected the listing to be sold exactly once, sold %d times", sold)
+	}
+	if n := countTShirts(t, dbConn); n != 1 {
+		t.Errorf("expected exactly one t-shirt, found %d", n)
+	}
+
+	// the buyer paid 100, the seller got 95 and the marketplace kept 5
+	var sellerCoins, total int
+	if err := dbConn.QueryRow("SELECT coins FROM users WHERE username='seller'").Scan(&sellerCoins); err != nil {
+		t.Fatalf("failed to get seller coins: %v", err)
+	}
+	if err := dbConn.QueryRow("SELECT SUM(coins) FROM users").Scan(&total); err != nil {
+		t.Fatalf("failed to sum coins: %v", err)
+	}
+	if sellerCoins != service.InitialCoins-80+95 {
+		t.Errorf("expected the seller to have %d coins, got %d", service.InitialCoins-80+95, sellerCoins)
+	}
+	if want := (buyers+1)*service.InitialCoins - 80 - 5; total != want {
+		t.Errorf("expected %d coins in total, got %d", want, total)
+	}
+	checkLedger(t, dbConn)
+}
+
+func TestIntegration_MarketCancelRacesBuy(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	for round := 0; round < 5; round++ {
+		truncateTestDB(t, dbConn, "inventories, users")
+		seller, listing := listTShirt(t, ts.URL)
+		buyer := loginTestUser(t, ts.URL, "buyer", "pass")
+
+		var cancelCode, buyCode int
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			<-start
+			cancelCode = doJSON(t, http.MethodPost, fmt.Sprintf("%s/api/market/listings/%d/cancel", ts.URL, listing.Id), seller, "", nil)
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			buyCode = doJSON(t, http.MethodPost, fmt.Sprintf("%s/api/market/listings/%d/buy", ts.URL, listing.Id), buyer, "", nil)
+		}()
+		close(start)
+		wg.Wait()
+
+		// exactly one of them wins, and the t-shirt ends up with exactly one user
+		if (cancelCode == http.StatusOK) == (buyCode == http.StatusOK) {
+			t.Errorf("round %d: expected exactly one of cancel and buy to succeed, got %d and %d", round, cancelCode, buyCode)
+		}
+		if n := countTShirts(t, dbConn); n != 1 {
+			t.Errorf("round %d: expected exactly one t-shirt, found %d", round, n)
+		}
+	}
+}
+
+func TestIntegration_PromoCodeRedemptionLimit(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	loginTestUser(t, ts.URL, "hr", "pass")
+	if _, err := dbConn.Exec("UPDATE users SET role=$1 WHERE username='hr'", token.RoleAdmin); err != nil {
+		t.Fatalf("failed to make hr an admin: %v", err)
+	}
+	admin := loginTestUser(t, ts.URL, "hr", "pass")
+	code := doJSON(t, http.MethodPost, ts.URL+"/api/admin/promo-codes", admin, `{"code":"welcome","kind":"fixed","value":30,"maxRedemptions":3}`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200 creating the promo code, got %d", code)
+	}
+
+	const buyers = 10
+	tokens := make([]string, buyers)
+	for i := range tokens {
+		tokens[i] = loginTestUser(t, ts.URL, fmt.Sprintf("buyer%d", i), "pass")
+	}
+
+	codes := make([]int, buyers)
+	receipts := make([]api.Receipt, buyers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			body := `{"items":[{"item":"t-shirt","quantity":1}],"promoCode":"WELCOME"}`
+			codes[i] = doJSON(t, http.MethodPost, ts.URL+"/api/buy", tokens[i], body, &receipts[i])
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	redeemed := 0
+	for i, code := range codes {
+		switch code {
+		case http.StatusOK:
+			redeemed++
+			if receipts[i].Discount != 30 || receipts[i].Total != 50 {
+				t.Errorf("buyer%d: expected to pay 50 after a discount of 30, got %+v", i, receipts[i])
+			}
+		case http.StatusConflict:
+		default:
+			t.Errorf("buyer%d: expected status 200 or 409, got %d", i, code)
+		}
+	}
+	if redeemed != 3 {
+		t.Fatalf("expected the promo code to be redeemed exactly 3 times, redeemed %d times", redeemed)
+	}
+
+	var counter, recorded int
+	if err := dbConn.QueryRow("SELECT redemptions FROM promo_codes WHERE code='WELCOME'").Scan(&counter); err != nil {
+		t.Fatalf("failed to get redemptions: %v", err)
+	}
+	if err := dbConn.QueryRow("SELECT COUNT(*) FROM promo_redemptions").Scan(&recorded); err != nil {
+		t.Fatalf("failed to count redemptions: %v", err)
+	}
+	if counter != 3 || recorded != 3 {
+		t.Errorf("expected 3 redemptions, counted %d and recorded %d", counter, recorded)
+	}
+	if n := countTShirts(t, dbConn); n != 3 {
+		t.Errorf("expected 3 t-shirts bought, found %d", n)
+	}
+}
+
+func TestIntegration_ReconcileRepairsDriftedCoins(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	loginTestUser(t, ts.URL, "alice", "pass")
+	loginTestUser(t, ts.URL, "bob", "pass")
+	// coins changed behind the back of the ledger
+	if _, err := dbConn.Exec("UPDATE users SET coins = coins + 50 WHERE username='bob'"); err != nil {
+		t.Fatalf("failed to change coins: %v", err)
+	}
+
+	svc := service.NewReconcileService(db.NewLedgerDB(dbConn), zap.NewNop())
+	report, err := svc.Reconcile(false)
+	if err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+	if len(report.Discrepancies) != 1 || report.Discrepancies[0].Username != "bob" ||
+		report.Discrepancies[0].Coins != service.InitialCoins+50 || report.Discrepancies[0].Expected != service.InitialCoins {
+		t.Fatalf("expected bob to be reported, got %+v", report.Discrepancies)
+	}
+
+	if report, err = svc.Reconcile(true); err != nil {
+		t.Fatalf("failed to repair: %v", err)
+	}
+	if len(report.Unresolved()) != 0 {
+		t.Errorf("expected everything to be repaired, got %+v", report.Unresolved())
+	}
+	checkLedger(t, dbConn)
+}
+
+func TestIntegration_SchemaRefusesBrokenInvariants(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	token := loginTestUser(t, ts.URL, "alice", "pass")
+	// buying twice adds to the one row of the item
+	for i := 0; i < 2; i++ {
+		if code := doJSON(t, http.MethodGet, ts.URL+"/api/buy/cup", token, "", nil); code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", code)
+		}
+	}
+	var rows, quantity int
+	if err := dbConn.QueryRow("SELECT COUNT(*), SUM(quantity) FROM inventories WHERE item_type='cup'").Scan(&rows, &quantity); err != nil {
+		t.Fatalf("failed to count cups: %v", err)
+	}
+	if rows != 1 || quantity != 2 {
+		t.Errorf("expected 2 cups in one row, got %d in %d rows", quantity, rows)
+	}
+
+	for _, stmt := range []string{
+		"UPDATE users SET coins = -1 WHERE username='alice'",
+		"UPDATE inventories SET quantity = -1 WHERE item_type='cup'",
+		"INSERT INTO inventories (user_id, item_type, quantity) SELECT id, 'cup', 1 FROM users WHERE username='alice'",
+		"INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount) SELECT id, 'sent', 'bob', 0 FROM users WHERE username='alice'",
+		"INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount) SELECT id, 'stolen', 'bob', 10 FROM users WHERE username='alice'",
+		"UPDATE users SET role = 'root' WHERE username='alice'",
+	} {
+		if _, err := dbConn.Exec(stmt); err == nil {
+			t.Errorf("expected %q to be refused", stmt)
+		}
+	}
+}
+
+func TestIntegration_CoinHistory(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	alice := loginTestUser(t, ts.URL, "alice", "pass")
+	bob := loginTestUser(t, ts.URL, "bob", "pass")
+
+	if code := doJSON(t, http.MethodPost, ts.URL+"/api/sendCoin", alice, `{"toUser":"bob","amount":30,"note":"  for the\ncoffee "}`, nil); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if code := doJSON(t, http.MethodPost, ts.URL+"/api/sendCoin", bob, `{"toUser":"alice","amount":10}`, nil); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+
+	var history api.HistoryResponse
+	if code := doJSON(t, http.MethodGet, ts.URL+"/api/history", alice, "", &history); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(history.Transactions) != 2 {
+		t.Fatalf("expected 2 transactions, got %+v", history.Transactions)
+	}
+	// newest first
+	received, sent := history.Transactions[0], history.Transactions[1]
+	if received.Type != api.Received || received.Counterparty != "bob" || received.Amount != 10 || received.Note != nil {
+		t.Errorf("unexpected transaction %+v", received)
+	}
+	if sent.Type != api.Sent || sent.Note == nil || *sent.Note != "for the coffee" || sent.CreatedAt.IsZero() || sent.Id == received.Id {
+		t.Errorf("unexpected transaction %+v", sent)
+	}
+
+	// the coin history in /api/info is left as it was
+	var info api.InfoResponse
+	if code := doJSON(t, http.MethodGet, ts.URL+"/api/info", bob, "", &info); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if info.Coins == nil || *info.Coins != 1020 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	checkLedger(t, dbConn)
+}
+
+func TestIntegration_CoinHistoryPages(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	dbConn := setupTestDB(t)
+	defer dbConn.Close()
+
+	e := createTestServer(t, dbConn, cfg, zap.NewNop())
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	alice := loginTestUser(t, ts.URL, "alice", "pass")
+	loginTestUser(t, ts.URL, "bob", "pass")
+	loginTestUser(t, ts.URL, "carol", "pass")
+	for amount := 1; amount <= 5; amount++ {
+		for _, to := range []string{"bob", "carol"} {
+			body := fmt.Sprintf(`{"toUser":%q,"amount":%d}`, to, amount)
+			if code := doJSON(t, http.MethodPost, ts.URL+"/api/sendCoin", alice, body, nil); code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", code)
+			}
+		}
+	}
+
+	// pages of 2 of the coins sent to bob from 2 to 4 coins
+	query := "/api/history?direction=sent&counterparty=bob&minAmount=2&maxAmount=4&limit=2"
+	var amounts []int
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("expected the pages to end")
+		}
+		var history api.HistoryResponse
+		if code := doJSON(t, http.MethodGet, ts.URL+query+cursor, alice, "", &history); code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", code)
+		}
+		for _, tr := range history.Transactions {
+			if tr.Type != api.Sent || tr.Counterparty != "bob" {
+				t.Errorf("unexpected transaction %+v", tr)
+			}
+			amounts = append(amounts, tr.Amount)
+		}
+		if history.NextCursor == nil {
+			break
+		}
+		cursor = "&cursor=" + *history.NextCursor
+	}
+	if fmt.Sprint(amounts) != "[4 3 2]" {
+		t.Errorf("expected the amounts newest first, got %v", amounts)
+	}
+
+	var history api.HistoryResponse
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if code := doJSON(t, http.MethodGet, ts.URL+"/api/history?from="+future, alice, "", &history); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if len(history.Transactions) != 0 || history.NextCursor != nil {
+		t.Errorf("expected no transactions from the future, got %+v", history)
+	}
+	if code := doJSON(t, http.MethodGet, ts.URL+"/api/history?cursor=broken", alice, "", nil); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a broken cursor, got %d", code)
+	}
 }
@@ -1,20 +1,49 @@
 package api
 
 import (
+	"avito-shop/internal/jwtkeys"
+	"avito-shop/internal/middleware"
 	"avito-shop/internal/service"
+	"avito-shop/internal/token"
 	"avito-shop/pkg"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
+// JWKSPath serves the keys other services use to verify our tokens.
+const JWKSPath = "/.well-known/jwks.json"
+
+// PublicRoutes lists the routes that can be called without a token.
+var PublicRoutes = []string{
+	"/api/auth",
+	"/api/auth/refresh",
+	JWKSPath,
+}
+
+// RoutePolicies restricts the admin API: auditors may read, only admins may
+// change anything.
+var RoutePolicies = []middleware.RoutePolicy{
+	{Route: "/api/admin/*", Methods: []string{http.MethodGet}, Roles: []string{token.RoleAdmin, token.RoleAuditor}},
+	{Route: "/api/admin/*", Roles: []string{token.RoleAdmin}},
+}
+
 type Handlers struct {
-	AuthService service.AuthService
-	ShopService service.ShopService
-	Logger      pkg.Logger
-	JWTSecret   string
+	AuthService    service.AuthService
+	ShopService    service.ShopService
+	CatalogService service.CatalogService
+	CartService    service.CartService
+	MarketService  service.MarketService
+	PromoService   service.PromoService
+	Logger         pkg.Logger
+	Keys           *jwtkeys.KeySet
 }
 
 var _ ServerInterface = (*Handlers)(nil)
@@ -25,12 +54,397 @@ func (h *Handlers) PostApiAuth(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
 	}
 
-	token, err := h.AuthService.Authenticate(req.Username, req.Password)
+	if req.Username == "" || req.Password == "" {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Username and password are required")})
+	}
+
+	tokens, err := h.AuthService.Authenticate(req.Username, req.Password, ctx.RealIP())
+	if err != nil {
+		var throttled *service.ThrottledError
+		if errors.As(err, &throttled) {
+			ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			return ctx.JSON(http.StatusTooManyRequests, ErrorResponse{Errors: ptr("Too many failed login attempts")})
+		}
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			h.Logger.Warn("invalid credentials", zap.String("username", req.Username), zap.Error(err))
+			return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr("Invalid credentials")})
+		}
+		h.Logger.Error("failed to authenticate", zap.String("username", req.Username), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, AuthResponse{Token: &tokens.AccessToken, RefreshToken: &tokens.RefreshToken})
+}
+
+func (h *Handlers) PostApiAuthRefresh(ctx echo.Context) error {
+	var req RefreshRequest
+	if err := ctx.Bind(&req); err != nil || req.RefreshToken == "" {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	tokens, err := h.AuthService.Refresh(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+			return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr("Invalid refresh token")})
+		}
+		h.Logger.Error("failed to refresh tokens", zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, AuthResponse{Token: &tokens.AccessToken, RefreshToken: &tokens.RefreshToken})
+}
+
+func (h *Handlers) PostApiAuthLogout(ctx echo.Context) error {
+	claims, err := getClaimsFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	userID := claims.UserID
+
+	var req LogoutRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+	refreshToken := ""
+	if req.RefreshToken != nil {
+		refreshToken = *req.RefreshToken
+	}
+
+	err = h.AuthService.Logout(userID, claims.ID, claims.ExpiresAt.Time, refreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid refresh token")})
+		}
+		h.Logger.Error("failed to logout", zap.Int("userID", userID), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+func (h *Handlers) GetApiAdminItems(ctx echo.Context) error {
+	items, err := h.CatalogService.ListItems()
+	if err != nil {
+		h.Logger.Error("failed to list items", zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	resp := AdminItemsResponse{Items: make([]AdminItem, 0, len(items))}
+	for _, it := range items {
+		resp.Items = append(resp.Items, toAdminItem(it))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) PostApiAdminItems(ctx echo.Context) error {
+	adminID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req CreateItemRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	it, err := h.CatalogService.CreateItem(adminID, service.NewItem{
+		Slug:       req.Slug,
+		Name:       req.Name,
+		Price:      req.Price,
+		Stock:      req.Stock,
+		MaxPerUser: req.MaxPerUser,
+	})
 	if err != nil {
-		h.Logger.Warn("invalid credentials", zap.String("username", req.Username), zap.Error(err))
-		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr("Invalid credentials")})
+		return h.catalogError(ctx, "failed to create item", req.Slug, err)
 	}
-	return ctx.JSON(http.StatusOK, AuthResponse{Token: &token})
+	return ctx.JSON(http.StatusOK, toAdminItem(it))
+}
+
+func (h *Handlers) PatchApiAdminItemsSlug(ctx echo.Context, slug string) error {
+	adminID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req UpdateItemRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	it, err := h.CatalogService.UpdateItem(adminID, slug, service.ItemUpdate{
+		Name:            req.Name,
+		Price:           req.Price,
+		Stock:           req.Stock,
+		MaxPerUser:      req.MaxPerUser,
+		ClearStock:      req.ClearStock != nil && *req.ClearStock,
+		ClearMaxPerUser: req.ClearMaxPerUser != nil && *req.ClearMaxPerUser,
+	})
+	if err != nil {
+		return h.catalogError(ctx, "failed to update item", slug, err)
+	}
+	return ctx.JSON(http.StatusOK, toAdminItem(it))
+}
+
+func (h *Handlers) PostApiAdminItemsSlugDeactivate(ctx echo.Context, slug string) error {
+	adminID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	it, err := h.CatalogService.DeactivateItem(adminID, slug)
+	if err != nil {
+		return h.catalogError(ctx, "failed to deactivate item", slug, err)
+	}
+	return ctx.JSON(http.StatusOK, toAdminItem(it))
+}
+
+func (h *Handlers) GetApiAdminItemsSlugHistory(ctx echo.Context, slug string) error {
+	history, err := h.CatalogService.GetPriceHistory(slug)
+	if err != nil {
+		return h.catalogError(ctx, "failed to get price history", slug, err)
+	}
+	resp := PriceHistoryResponse{History: make([]PriceChange, 0, len(history))}
+	for _, c := range history {
+		resp.History = append(resp.History, PriceChange{
+			Action:    c.Action,
+			OldPrice:  c.OldPrice,
+			NewPrice:  c.NewPrice,
+			ChangedBy: c.ChangedBy,
+			ChangedAt: c.ChangedAt,
+		})
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) PostApiAdminItemsSlugRestore(ctx echo.Context, slug string) error {
+	adminID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	it, err := h.CatalogService.RestoreItem(adminID, slug)
+	if err != nil {
+		return h.catalogError(ctx, "failed to restore item", slug, err)
+	}
+	return ctx.JSON(http.StatusOK, toAdminItem(it))
+}
+
+func (h *Handlers) catalogError(ctx echo.Context, msg, slug string, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidItem):
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+	case errors.Is(err, service.ErrItemNotFound):
+		return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("Item not found")})
+	case errors.Is(err, service.ErrItemExists):
+		return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr("Item already exists")})
+	}
+	h.Logger.Error(msg, zap.String("item", slug), zap.Error(err))
+	return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+}
+
+func toAdminItem(it service.Item) AdminItem {
+	return AdminItem{
+		Slug:       it.Slug,
+		Name:       it.Name,
+		Price:      it.Price,
+		Stock:      it.Stock,
+		MaxPerUser: it.MaxPerUser,
+		Active:     it.Active,
+		CreatedAt:  it.CreatedAt,
+		UpdatedAt:  it.UpdatedAt,
+	}
+}
+
+func (h *Handlers) PostApiAdminOrdersOrderIdRefund(ctx echo.Context, orderId int) error {
+	adminID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	lines, err := bindRefundLines(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	refund, err := h.ShopService.AdminRefund(adminID, orderId, lines)
+	if err != nil {
+		return h.refundError(ctx, orderId, err)
+	}
+	return ctx.JSON(http.StatusOK, toRefund(refund))
+}
+
+func (h *Handlers) GetApiAdminPromoCodes(ctx echo.Context) error {
+	codes, err := h.PromoService.ListPromoCodes()
+	if err != nil {
+		h.Logger.Error("failed to list promo codes", zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	resp := PromoCodesResponse{PromoCodes: make([]PromoCode, 0, len(codes))}
+	for _, c := range codes {
+		resp.PromoCodes = append(resp.PromoCodes, toPromoCode(c))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) PostApiAdminPromoCodes(ctx echo.Context) error {
+	adminID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req CreatePromoCodeRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	code := service.PromoCode{
+		Code:           req.Code,
+		Kind:           string(req.Kind),
+		Value:          req.Value,
+		MaxRedemptions: req.MaxRedemptions,
+		MaxPerUser:     req.MaxPerUser,
+		ValidFrom:      req.ValidFrom,
+		ValidUntil:     req.ValidUntil,
+	}
+	if req.Items != nil {
+		code.Items = *req.Items
+	}
+	c, err := h.PromoService.CreatePromoCode(adminID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidPromo),
+			errors.Is(err, service.ErrItemNotFound):
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+		case errors.Is(err, service.ErrPromoExists):
+			return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr("Promo code already exists")})
+		}
+		h.Logger.Error("failed to create promo code", zap.Int("adminID", adminID), zap.String("code", req.Code), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, toPromoCode(c))
+}
+
+func toPromoCode(c service.PromoCode) PromoCode {
+	resp := PromoCode{
+		Code:           c.Code,
+		Kind:           PromoKind(c.Kind),
+		Value:          c.Value,
+		MaxRedemptions: c.MaxRedemptions,
+		MaxPerUser:     c.MaxPerUser,
+		ValidFrom:      c.ValidFrom,
+		ValidUntil:     c.ValidUntil,
+		Redemptions:    c.Redemptions,
+		CreatedAt:      c.CreatedAt,
+	}
+	if len(c.Items) > 0 {
+		items := c.Items
+		resp.Items = &items
+	}
+	return resp
+}
+
+// isPromoRefused reports whether the promo code of a purchase cannot be used
+// with it, which the user can fix by changing the request.
+func isPromoRefused(err error) bool {
+	return errors.Is(err, service.ErrPromoNotFound) ||
+		errors.Is(err, service.ErrPromoExpired) ||
+		errors.Is(err, service.ErrPromoNotApplicable)
+}
+
+func (h *Handlers) GetApiAdminUsersUserId(ctx echo.Context, userId int) error {
+	user, err := h.AuthService.GetUser(userId)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("User not found")})
+		}
+		h.Logger.Error("failed to get user", zap.Int("userID", userId), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, AdminUserResponse{
+		Id:       user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Coins:    user.Coins,
+	})
+}
+
+func (h *Handlers) PostApiAdminUsersUserIdRevokeSessions(ctx echo.Context, userId int) error {
+	err := h.AuthService.RevokeAllSessions(userId)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("User not found")})
+		}
+		h.Logger.Error("failed to revoke sessions", zap.Int("userID", userId), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"message": "Sessions revoked successfully"})
+}
+
+func (h *Handlers) PutApiAdminUsersUserIdRole(ctx echo.Context, userId int) error {
+	var req SetRoleRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	err := h.AuthService.SetRole(userId, req.Role)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRole) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid role")})
+		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("User not found")})
+		}
+		h.Logger.Error("failed to set role", zap.Int("userID", userId), zap.String("role", req.Role), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"message": "Role updated successfully"})
+}
+
+func (h *Handlers) PostApiAdminUsersUserIdUnlock(ctx echo.Context, userId int) error {
+	err := h.AuthService.UnlockUser(userId)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("User not found")})
+		}
+		h.Logger.Error("failed to unlock user", zap.Int("userID", userId), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"message": "User unlocked successfully"})
+}
+
+// GetJWKS is served outside of the OpenAPI router, see JWKSPath.
+func (h *Handlers) GetJWKS(ctx echo.Context) error {
+	ctx.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return ctx.JSON(http.StatusOK, h.Keys.JWKS())
+}
+
+func (h *Handlers) PostApiBuy(ctx echo.Context) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req BuyRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	lines := make([]service.PurchaseLine, 0, len(req.Items))
+	for _, l := range req.Items {
+		lines = append(lines, service.PurchaseLine{Item: l.Item, Quantity: l.Quantity})
+	}
+	var promoCode string
+	if req.PromoCode != nil {
+		promoCode = *req.PromoCode
+	}
+	receipt, err := h.ShopService.Buy(userID, lines, promoCode)
+	if err != nil {
+		// the errors name the line that failed
+		switch {
+		case errors.Is(err, service.ErrInvalidQuantity),
+			errors.Is(err, service.ErrItemNotFound),
+			errors.Is(err, service.ErrNotEnoughCoins),
+			isPromoRefused(err):
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+		case errors.Is(err, service.ErrOutOfStock),
+			errors.Is(err, service.ErrPurchaseLimitReached),
+			errors.Is(err, service.ErrPromoUsedUp):
+			return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr(err.Error())})
+		}
+		h.Logger.Error("failed to buy items", zap.Int("userID", userID), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+
+	return ctx.JSON(http.StatusOK, toReceipt(receipt))
 }
 
 func (h *Handlers) GetApiBuyItem(ctx echo.Context, item string) error {
@@ -47,6 +461,12 @@ func (h *Handlers) GetApiBuyItem(ctx echo.Context, item string) error {
 		if errors.Is(err, service.ErrItemNotFound) {
 			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Item not found")})
 		}
+		if errors.Is(err, service.ErrOutOfStock) {
+			return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr("Item is out of stock")})
+		}
+		if errors.Is(err, service.ErrPurchaseLimitReached) {
+			return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr("Purchase limit for this item reached")})
+		}
 		h.Logger.Error("failed to buy item", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
 		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
 	}
@@ -54,6 +474,305 @@ func (h *Handlers) GetApiBuyItem(ctx echo.Context, item string) error {
 	return ctx.JSON(http.StatusOK, map[string]string{"message": "Item purchased successfully"})
 }
 
+func (h *Handlers) GetApiCart(ctx echo.Context) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	cart, err := h.CartService.GetCart(userID)
+	if err != nil {
+		return h.cartError(ctx, userID, "failed to get cart", err)
+	}
+	return ctx.JSON(http.StatusOK, toCartResponse(cart))
+}
+
+func (h *Handlers) PostApiCartItems(ctx echo.Context) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req AddCartItemRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	cart, err := h.CartService.AddItem(userID, req.Item, req.Quantity)
+	if err != nil {
+		return h.cartError(ctx, userID, "failed to add item to cart", err)
+	}
+	return ctx.JSON(http.StatusOK, toCartResponse(cart))
+}
+
+func (h *Handlers) PutApiCartItemsItem(ctx echo.Context, item string) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req SetCartQuantityRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	cart, err := h.CartService.SetQuantity(userID, item, req.Quantity)
+	if err != nil {
+		return h.cartError(ctx, userID, "failed to set cart quantity", err)
+	}
+	return ctx.JSON(http.StatusOK, toCartResponse(cart))
+}
+
+func (h *Handlers) DeleteApiCartItemsItem(ctx echo.Context, item string) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	cart, err := h.CartService.RemoveItem(userID, item)
+	if err != nil {
+		return h.cartError(ctx, userID, "failed to remove item from cart", err)
+	}
+	return ctx.JSON(http.StatusOK, toCartResponse(cart))
+}
+
+func (h *Handlers) PostApiCartCheckout(ctx echo.Context) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	// the body is optional
+	var req CheckoutRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+	var promoCode string
+	if req.PromoCode != nil {
+		promoCode = *req.PromoCode
+	}
+	receipt, err := h.CartService.Checkout(userID, promoCode)
+	if err != nil {
+		var checkoutErr *service.CheckoutError
+		if errors.As(err, &checkoutErr) {
+			resp := CheckoutErrorResponse{
+				Errors:   "Cart cannot be checked out",
+				Problems: make([]CheckoutProblem, 0, len(checkoutErr.Problems)),
+			}
+			for _, p := range checkoutErr.Problems {
+				resp.Problems = append(resp.Problems, CheckoutProblem{
+					Item:      p.Item,
+					Reason:    p.Reason,
+					CartPrice: p.CartPrice,
+					Price:     p.Price,
+					Stock:     p.Stock,
+				})
+			}
+			return ctx.JSON(http.StatusConflict, resp)
+		}
+		if errors.Is(err, service.ErrPromoUsedUp) {
+			return ctx.JSON(http.StatusConflict, CheckoutErrorResponse{Errors: err.Error(), Problems: []CheckoutProblem{}})
+		}
+		return h.cartError(ctx, userID, "failed to check out cart", err)
+	}
+	return ctx.JSON(http.StatusOK, toReceipt(receipt))
+}
+
+func (h *Handlers) cartError(ctx echo.Context, userID int, msg string, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidQuantity),
+		errors.Is(err, service.ErrNotEnoughCoins),
+		isPromoRefused(err):
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+	case errors.Is(err, service.ErrItemNotFound):
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Item not found")})
+	case errors.Is(err, service.ErrCartEmpty):
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Cart is empty")})
+	case errors.Is(err, service.ErrNotInCart):
+		return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("Item is not in the cart")})
+	}
+	h.Logger.Error(msg, zap.Int("userID", userID), zap.Error(err))
+	return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+}
+
+func toCartResponse(cart service.Cart) CartResponse {
+	resp := CartResponse{
+		Lines: make([]CartLine, 0, len(cart.Lines)),
+		Total: cart.Total,
+	}
+	for _, l := range cart.Lines {
+		resp.Lines = append(resp.Lines, CartLine{
+			Item:      l.Item,
+			Name:      l.Name,
+			Quantity:  l.Quantity,
+			Price:     l.Price,
+			CartPrice: l.CartPrice,
+			Total:     l.Price * l.Quantity,
+			Available: l.Available,
+		})
+	}
+	return resp
+}
+
+func toReceipt(receipt service.Receipt) Receipt {
+	resp := Receipt{
+		OrderId:   receipt.OrderID,
+		Lines:     toReceiptLines(receipt.Lines),
+		Discount:  receipt.Discount,
+		Total:     receipt.Total,
+		Balance:   receipt.Balance,
+		CreatedAt: receipt.CreatedAt,
+	}
+	if receipt.PromoCode != "" {
+		resp.PromoCode = &receipt.PromoCode
+	}
+	return resp
+}
+
+func toReceiptLines(lines []service.ReceiptLine) []ReceiptLine {
+	resp := make([]ReceiptLine, 0, len(lines))
+	for _, l := range lines {
+		line := ReceiptLine{
+			Item:     l.Item,
+			Name:     l.Name,
+			Quantity: l.Quantity,
+			Price:    l.Price,
+			Total:    l.Total,
+		}
+		if l.Discount != 0 {
+			discount := l.Discount
+			line.Discount = &discount
+		}
+		resp = append(resp, line)
+	}
+	return resp
+}
+
+func (h *Handlers) PostApiGift(ctx echo.Context) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req GiftRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	giftReq := service.GiftRequest{To: req.ToUser, Item: req.Item, Quantity: 1}
+	if req.Quantity != nil {
+		giftReq.Quantity = *req.Quantity
+	}
+	if req.Message != nil {
+		giftReq.Message = *req.Message
+	}
+	if req.FromInventory != nil {
+		giftReq.FromInventory = *req.FromInventory
+	}
+	gift, err := h.ShopService.Gift(userID, giftReq)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Recipient not found")})
+		case errors.Is(err, service.ErrInvalidGift),
+			errors.Is(err, service.ErrInvalidQuantity),
+			errors.Is(err, service.ErrItemNotFound),
+			errors.Is(err, service.ErrNotEnoughCoins),
+			errors.Is(err, service.ErrNotEnoughItems):
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+		case errors.Is(err, service.ErrOutOfStock),
+			errors.Is(err, service.ErrPurchaseLimitReached):
+			return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr(err.Error())})
+		}
+		h.Logger.Error("failed to send gift", zap.Int("fromUserID", userID), zap.String("toUser", req.ToUser), zap.String("item", req.Item), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+
+	resp := GiftResponse{
+		Id:        gift.ID,
+		ToUser:    gift.ToUser,
+		Item:      gift.Item,
+		Quantity:  gift.Quantity,
+		OrderId:   gift.OrderID,
+		Total:     gift.Total,
+		CreatedAt: gift.CreatedAt,
+	}
+	if gift.Message != "" {
+		resp.Message = &gift.Message
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func toGiftEntries(gifts []service.Gift) *[]GiftEntry {
+	entries := make([]GiftEntry, 0, len(gifts))
+	for _, g := range gifts {
+		e := GiftEntry{
+			FromUser:  g.FromUser,
+			ToUser:    g.ToUser,
+			Item:      g.Item,
+			Quantity:  g.Quantity,
+			CreatedAt: g.CreatedAt,
+		}
+		if g.Message != "" {
+			e.Message = ptr(g.Message)
+		}
+		entries = append(entries, e)
+	}
+	return &entries
+}
+
+func (h *Handlers) GetApiHistory(ctx echo.Context, params GetApiHistoryParams) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	filter := service.HistoryFilter{
+		MinAmount: params.MinAmount,
+		MaxAmount: params.MaxAmount,
+		From:      params.From,
+		To:        params.To,
+	}
+	if params.Direction != nil {
+		filter.Direction = *params.Direction
+	}
+	if params.Counterparty != nil {
+		filter.Counterparty = *params.Counterparty
+	}
+	var limit int
+	if params.Limit != nil {
+		if *params.Limit < 1 {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("limit must be > 0")})
+		}
+		limit = *params.Limit
+	}
+	var cursor string
+	if params.Cursor != nil {
+		cursor = *params.Cursor
+	}
+
+	history, err := h.ShopService.GetCoinHistory(userID, filter, limit, cursor)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPage) || errors.Is(err, service.ErrInvalidFilter) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+		}
+		h.Logger.Error("failed to get coin history", zap.Int("userID", userID), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+
+	resp := HistoryResponse{Transactions: make([]CoinTransaction, 0, len(history.Transactions))}
+	for _, t := range history.Transactions {
+		e := CoinTransaction{
+			Id:           t.ID,
+			Type:         CoinTransactionType(t.Type),
+			Counterparty: t.Counterparty,
+			Amount:       t.Amount,
+			CreatedAt:    t.CreatedAt,
+		}
+		if t.Note != "" {
+			e.Note = ptr(t.Note)
+		}
+		resp.Transactions = append(resp.Transactions, e)
+	}
+	if history.NextCursor != "" {
+		resp.NextCursor = ptr(history.NextCursor)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
 func (h *Handlers) GetApiInfo(ctx echo.Context) error {
 	userID, err := getUserIDFromContext(ctx)
 	if err != nil {
@@ -69,6 +788,296 @@ func (h *Handlers) GetApiInfo(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, convertToInfoResponse(info))
 }
 
+func (h *Handlers) GetApiItems(ctx echo.Context, params GetApiItemsParams) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	if params.MaxPrice != nil && *params.MaxPrice < 0 {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("maxPrice must be >= 0")})
+	}
+	filter := service.ItemFilter{MaxPrice: params.MaxPrice}
+	if params.Sort != nil {
+		filter.Sort = service.ItemSort(*params.Sort)
+	}
+
+	items, err := h.ShopService.ListItems(userID, filter)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidSort) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid sort order")})
+		}
+		h.Logger.Error("failed to list items", zap.Int("userID", userID), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+
+	resp := ItemsResponse{Items: make([]CatalogItem, 0, len(items))}
+	for _, it := range items {
+		resp.Items = append(resp.Items, CatalogItem{
+			Slug:      it.Slug,
+			Name:      it.Name,
+			Price:     it.Price,
+			Stock:     it.Stock,
+			Available: it.Available,
+			CanAfford: it.CanAfford,
+		})
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		h.Logger.Error("failed to encode items", zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+
+	// the body depends on the balance of the caller, so only private caches may keep it
+	etag := etagOf(body)
+	ctx.Response().Header().Set("ETag", etag)
+	ctx.Response().Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(ctx.Request().Header.Get("If-None-Match"), etag) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+	return ctx.JSONBlob(http.StatusOK, body)
+}
+
+func (h *Handlers) GetApiMarketListings(ctx echo.Context, params GetApiMarketListingsParams) error {
+	var page service.Page
+	if params.Limit != nil {
+		if *params.Limit < 1 {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("limit must be > 0")})
+		}
+		page.Limit = *params.Limit
+	}
+	if params.Offset != nil {
+		page.Offset = *params.Offset
+	}
+	var item string
+	if params.Item != nil {
+		item = *params.Item
+	}
+
+	listings, err := h.MarketService.ListListings(item, page)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPage) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+		}
+		h.Logger.Error("failed to list listings", zap.String("item", item), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+
+	resp := ListingsResponse{Listings: make([]Listing, 0, len(listings))}
+	for _, l := range listings {
+		resp.Listings = append(resp.Listings, toListing(l))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) PostApiMarketListings(ctx echo.Context) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var req CreateListingRequest
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+	quantity := 1
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+
+	listing, err := h.MarketService.CreateListing(userID, req.Item, quantity, req.Price)
+	if err != nil {
+		return h.marketError(ctx, userID, "failed to create listing", err)
+	}
+	return ctx.JSON(http.StatusOK, toListing(listing))
+}
+
+func (h *Handlers) PostApiMarketListingsListingIdBuy(ctx echo.Context, listingId int) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+
+	listing, err := h.MarketService.BuyListing(userID, listingId)
+	if err != nil {
+		return h.marketError(ctx, userID, "failed to buy listing", err)
+	}
+	return ctx.JSON(http.StatusOK, toListing(listing))
+}
+
+func (h *Handlers) PostApiMarketListingsListingIdCancel(ctx echo.Context, listingId int) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+
+	listing, err := h.MarketService.CancelListing(userID, listingId)
+	if err != nil {
+		return h.marketError(ctx, userID, "failed to cancel listing", err)
+	}
+	return ctx.JSON(http.StatusOK, toListing(listing))
+}
+
+// marketError maps errors of the market service to responses.
+func (h *Handlers) marketError(ctx echo.Context, userID int, msg string, err error) error {
+	switch {
+	case errors.Is(err, service.ErrListingNotFound):
+		return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("Listing not found")})
+	case errors.Is(err, service.ErrListingClosed),
+		errors.Is(err, service.ErrPurchaseLimitReached):
+		return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr(err.Error())})
+	case errors.Is(err, service.ErrInvalidQuantity),
+		errors.Is(err, service.ErrInvalidPrice),
+		errors.Is(err, service.ErrNotEnoughItems),
+		errors.Is(err, service.ErrNotEnoughCoins),
+		errors.Is(err, service.ErrOwnListing):
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	h.Logger.Error(msg, zap.Int("userID", userID), zap.Error(err))
+	return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+}
+
+func toListing(l service.Listing) Listing {
+	return Listing{
+		Id:        l.ID,
+		Seller:    l.Seller,
+		Item:      l.Item,
+		Quantity:  l.Quantity,
+		Price:     l.Price,
+		Status:    ListingStatus(l.Status),
+		Fee:       l.Fee,
+		CreatedAt: l.CreatedAt,
+		ClosedAt:  l.ClosedAt,
+	}
+}
+
+func (h *Handlers) GetApiOrders(ctx echo.Context, params GetApiOrdersParams) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	var page service.Page
+	if params.Limit != nil {
+		if *params.Limit < 1 {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("limit must be > 0")})
+		}
+		page.Limit = *params.Limit
+	}
+	if params.Offset != nil {
+		page.Offset = *params.Offset
+	}
+
+	orders, err := h.ShopService.ListOrders(userID, page)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPage) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+		}
+		h.Logger.Error("failed to list orders", zap.Int("userID", userID), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+
+	resp := OrdersResponse{Orders: make([]Order, 0, len(orders))}
+	for _, o := range orders {
+		resp.Orders = append(resp.Orders, toOrder(o))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) GetApiOrdersOrderId(ctx echo.Context, orderId int) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+
+	order, err := h.ShopService.GetOrder(userID, orderId)
+	if err != nil {
+		// orders of other users are not found either, so their IDs leak nothing
+		if errors.Is(err, service.ErrOrderNotFound) {
+			return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("Order not found")})
+		}
+		h.Logger.Error("failed to get order", zap.Int("userID", userID), zap.Int("orderID", orderId), zap.Error(err))
+		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+	}
+	return ctx.JSON(http.StatusOK, toOrder(order))
+}
+
+func (h *Handlers) PostApiOrdersOrderIdRefund(ctx echo.Context, orderId int) error {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusUnauthorized, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	lines, err := bindRefundLines(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Invalid request body")})
+	}
+
+	refund, err := h.ShopService.Refund(userID, orderId, lines)
+	if err != nil {
+		return h.refundError(ctx, orderId, err)
+	}
+	return ctx.JSON(http.StatusOK, toRefund(refund))
+}
+
+// bindRefundLines returns nil lines, refunding the whole order, when the body
+// is empty or has no items.
+func bindRefundLines(ctx echo.Context) ([]service.PurchaseLine, error) {
+	var req RefundRequest
+	if err := ctx.Bind(&req); err != nil {
+		return nil, err
+	}
+	if req.Items == nil {
+		return nil, nil
+	}
+	lines := make([]service.PurchaseLine, 0, len(*req.Items))
+	for _, l := range *req.Items {
+		lines = append(lines, service.PurchaseLine{Item: l.Item, Quantity: l.Quantity})
+	}
+	return lines, nil
+}
+
+func (h *Handlers) refundError(ctx echo.Context, orderID int, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidQuantity):
+		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+	case errors.Is(err, service.ErrOrderNotFound):
+		return ctx.JSON(http.StatusNotFound, ErrorResponse{Errors: ptr("Order not found")})
+	case errors.Is(err, service.ErrNotRefundable),
+		errors.Is(err, service.ErrRefundWindowClosed):
+		return ctx.JSON(http.StatusConflict, ErrorResponse{Errors: ptr(err.Error())})
+	}
+	h.Logger.Error("failed to refund order", zap.Int("orderID", orderID), zap.Error(err))
+	return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
+}
+
+func toOrder(o service.Order) Order {
+	order := Order{
+		Id:        o.ID,
+		Lines:     toReceiptLines(o.Lines),
+		Discount:  o.Discount,
+		Total:     o.Total,
+		CreatedAt: o.CreatedAt,
+	}
+	if o.PromoCode != "" {
+		order.PromoCode = &o.PromoCode
+	}
+	if o.Refunds != nil {
+		refunds := make([]Refund, 0, len(o.Refunds))
+		for _, r := range o.Refunds {
+			refunds = append(refunds, toRefund(r))
+		}
+		order.Refunds = &refunds
+	}
+	return order
+}
+
+func toRefund(r service.Refund) Refund {
+	return Refund{
+		Id:        r.ID,
+		OrderId:   r.OrderID,
+		Lines:     toReceiptLines(r.Lines),
+		Total:     r.Total,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
 func (h *Handlers) PostApiSendCoin(ctx echo.Context) error {
 	userID, err := getUserIDFromContext(ctx)
 	if err != nil {
@@ -83,7 +1092,12 @@ func (h *Handlers) PostApiSendCoin(ctx echo.Context) error {
 		return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Amount must be > 0")})
 	}
 
-	err = h.ShopService.SendCoins(userID, req.ToUser, req.Amount)
+	var note string
+	if req.Note != nil {
+		note = *req.Note
+	}
+
+	err = h.ShopService.SendCoins(userID, req.ToUser, req.Amount, note)
 	if err != nil {
 		if errors.Is(err, service.ErrNotEnoughCoins) {
 			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Not enough coins")})
@@ -91,6 +1105,12 @@ func (h *Handlers) PostApiSendCoin(ctx echo.Context) error {
 		if errors.Is(err, service.ErrUserNotFound) {
 			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Recipient not found")})
 		}
+		if errors.Is(err, service.ErrInvalidAmount) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr("Amount must be > 0")})
+		}
+		if errors.Is(err, service.ErrInvalidNote) {
+			return ctx.JSON(http.StatusBadRequest, ErrorResponse{Errors: ptr(err.Error())})
+		}
 		h.Logger.Error("failed to send coins", zap.Int("fromUserID", userID), zap.String("toUser", req.ToUser), zap.Int("amount", req.Amount), zap.Error(err))
 		return ctx.JSON(http.StatusInternalServerError, ErrorResponse{Errors: ptr("Internal server error")})
 	}
@@ -99,19 +1119,36 @@ func (h *Handlers) PostApiSendCoin(ctx echo.Context) error {
 }
 
 func getUserIDFromContext(ctx echo.Context) (int, error) {
-	claims := ctx.Get("user")
-	if claims == nil {
-		return 0, errUnauthorized("Unauthorized")
+	claims, err := getClaimsFromContext(ctx)
+	if err != nil {
+		return 0, err
 	}
-	jwtClaims, ok := claims.(jwt.MapClaims)
+	return claims.UserID, nil
+}
+
+func getClaimsFromContext(ctx echo.Context) (*token.Claims, error) {
+	claims, ok := token.FromContext(ctx)
 	if !ok {
-		return 0, errUnauthorized("Invalid token claims")
+		return nil, errUnauthorized("Unauthorized")
 	}
-	uidFloat, ok := jwtClaims["user_id"].(float64)
-	if !ok {
-		return 0, errUnauthorized("Invalid token claims")
+	return claims, nil
+}
+
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// validators match too, as the comparison is only used for GET.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
 	}
-	return int(uidFloat), nil
+	return false
 }
 
 func convertToInfoResponse(info service.Info) InfoResponse {
@@ -178,6 +1215,10 @@ func convertToInfoResponse(info service.Info) InfoResponse {
 			Received: &received,
 			Sent:     &sent,
 		},
+		GiftHistory: &GiftHistory{
+			Received: toGiftEntries(info.GiftHistory.Received),
+			Sent:     toGiftEntries(info.GiftHistory.Sent),
+		},
 	}
 }
 
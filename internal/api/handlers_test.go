@@ -0,0 +1,1264 @@
+package api
+
+import (
+	"avito-shop/internal/jwtkeys"
+	"avito-shop/internal/middleware"
+	"avito-shop/internal/service"
+	"avito-shop/internal/token"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var testKeys = func() *jwtkeys.KeySet {
+	keys, err := jwtkeys.Generate()
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}()
+
+var testExpectations = token.Expectations{Issuer: "avito-shop", Audience: "avito-shop"}
+
+type mockAuthService struct {
+	service.AuthService
+	AuthenticateFunc func(username, password, clientIP string) (service.Tokens, error)
+	RefreshFunc      func(refreshToken string) (service.Tokens, error)
+	LogoutFunc       func(userID int, tokenID string, expiresAt time.Time, refreshToken string) error
+	GetUserFunc      func(userID int) (service.User, error)
+	SetRoleFunc      func(userID int, role string) error
+	RevokeAllFunc    func(userID int) error
+	UnlockFunc       func(userID int) error
+}
+
+func (m *mockAuthService) UnlockUser(userID int) error {
+	return m.UnlockFunc(userID)
+}
+
+func (m *mockAuthService) GetUser(userID int) (service.User, error) {
+	return m.GetUserFunc(userID)
+}
+
+func (m *mockAuthService) SetRole(userID int, role string) error {
+	return m.SetRoleFunc(userID, role)
+}
+
+func (m *mockAuthService) RevokeAllSessions(userID int) error {
+	return m.RevokeAllFunc(userID)
+}
+
+func (m *mockAuthService) Logout(userID int, tokenID string, expiresAt time.Time, refreshToken string) error {
+	return m.LogoutFunc(userID, tokenID, expiresAt, refreshToken)
+}
+
+func (m *mockAuthService) Refresh(refreshToken string) (service.Tokens, error) {
+	return m.RefreshFunc(refreshToken)
+}
+
+func (m *mockAuthService) Authenticate(username, password, clientIP string) (service.Tokens, error) {
+	return m.AuthenticateFunc(username, password, clientIP)
+}
+
+type mockShopService struct {
+	service.ShopService
+	GetUserInfoFunc    func(userID int) (service.Info, error)
+	ListItemsFunc      func(userID int, filter service.ItemFilter) ([]service.CatalogItem, error)
+	BuyItemFunc        func(userID int, item string) error
+	BuyFunc            func(userID int, lines []service.PurchaseLine, promoCode string) (service.Receipt, error)
+	RefundFunc         func(userID, orderID int, lines []service.PurchaseLine) (service.Refund, error)
+	GiftFunc           func(fromUserID int, req service.GiftRequest) (service.Gift, error)
+	SendCoinsFunc      func(fromUserID int, toUsername string, amount int, note string) error
+	GetCoinHistoryFunc func(userID int, filter service.HistoryFilter, limit int, cursor string) (service.HistoryPage, error)
+	// Orders are the orders of each user, newest first.
+	Orders map[int][]service.Order
+}
+
+func (m *mockShopService) SendCoins(fromUserID int, toUsername string, amount int, note string) error {
+	return m.SendCoinsFunc(fromUserID, toUsername, amount, note)
+}
+
+func (m *mockShopService) GetCoinHistory(userID int, filter service.HistoryFilter, limit int, cursor string) (service.HistoryPage, error) {
+	return m.GetCoinHistoryFunc(userID, filter, limit, cursor)
+}
+
+func (m *mockShopService) Gift(fromUserID int, req service.GiftRequest) (service.Gift, error) {
+	return m.GiftFunc(fromUserID, req)
+}
+
+func (m *mockShopService) Refund(userID, orderID int, lines []service.PurchaseLine) (service.Refund, error) {
+	return m.RefundFunc(userID, orderID, lines)
+}
+
+// AdminRefund refunds as the owner of the order would, but without a window.
+func (m *mockShopService) AdminRefund(adminID, orderID int, lines []service.PurchaseLine) (service.Refund, error) {
+	for _, orders := range m.Orders {
+		for _, o := range orders {
+			if o.ID == orderID {
+				return service.Refund{ID: 1, OrderID: orderID, Total: o.Total, Lines: o.Lines}, nil
+			}
+		}
+	}
+	return service.Refund{}, service.ErrOrderNotFound
+}
+
+func (m *mockShopService) ListOrders(userID int, page service.Page) ([]service.Order, error) {
+	if page.Limit > 100 || page.Offset < 0 {
+		return nil, service.ErrInvalidPage
+	}
+	orders := m.Orders[userID]
+	if page.Offset >= len(orders) {
+		return nil, nil
+	}
+	return orders[page.Offset:], nil
+}
+
+func (m *mockShopService) GetOrder(userID, orderID int) (service.Order, error) {
+	for _, o := range m.Orders[userID] {
+		if o.ID == orderID {
+			return o, nil
+		}
+	}
+	return service.Order{}, service.ErrOrderNotFound
+}
+
+func (m *mockShopService) Buy(userID int, lines []service.PurchaseLine, promoCode string) (service.Receipt, error) {
+	return m.BuyFunc(userID, lines, promoCode)
+}
+
+func (m *mockShopService) BuyItem(userID int, item string) error {
+	return m.BuyItemFunc(userID, item)
+}
+
+func (m *mockShopService) ListItems(userID int, filter service.ItemFilter) ([]service.CatalogItem, error) {
+	return m.ListItemsFunc(userID, filter)
+}
+
+func (m *mockShopService) GetUserInfo(userID int) (service.Info, error) {
+	return m.GetUserInfoFunc(userID)
+}
+
+// mockCatalogService knows the item "cup" only.
+type mockCatalogService struct {
+	service.CatalogService
+}
+
+func (m *mockCatalogService) ListItems() ([]service.Item, error) {
+	return []service.Item{{Slug: "cup", Name: "Cup", Price: 20, Active: true}}, nil
+}
+
+func (m *mockCatalogService) CreateItem(adminID int, item service.NewItem) (service.Item, error) {
+	if item.Price <= 0 {
+		return service.Item{}, service.ErrInvalidItem
+	}
+	if item.Slug == "cup" {
+		return service.Item{}, service.ErrItemExists
+	}
+	return service.Item{Slug: item.Slug, Name: item.Name, Price: item.Price, Active: true}, nil
+}
+
+func (m *mockCatalogService) UpdateItem(adminID int, slug string, update service.ItemUpdate) (service.Item, error) {
+	if update.Price != nil && *update.Price <= 0 {
+		return service.Item{}, service.ErrInvalidItem
+	}
+	return m.change(slug, true)
+}
+
+func (m *mockCatalogService) DeactivateItem(adminID int, slug string) (service.Item, error) {
+	return m.change(slug, false)
+}
+
+func (m *mockCatalogService) RestoreItem(adminID int, slug string) (service.Item, error) {
+	return m.change(slug, true)
+}
+
+func (m *mockCatalogService) change(slug string, active bool) (service.Item, error) {
+	if slug != "cup" {
+		return service.Item{}, service.ErrItemNotFound
+	}
+	return service.Item{Slug: slug, Name: "Cup", Price: 20, Active: active}, nil
+}
+
+func (m *mockCatalogService) GetPriceHistory(slug string) ([]service.PriceChange, error) {
+	if slug != "cup" {
+		return nil, service.ErrItemNotFound
+	}
+	return []service.PriceChange{{Action: service.PriceActionCreated, NewPrice: 20}}, nil
+}
+
+type mockCartService struct {
+	service.CartService
+	lines       map[string]int
+	CheckoutErr error
+}
+
+func (m *mockCartService) GetCart(userID int) (service.Cart, error) {
+	var cart service.Cart
+	for item, q := range m.lines {
+		cart.Lines = append(cart.Lines, service.CartLine{Item: item, Quantity: q, Price: 10, CartPrice: 10, Available: true})
+		cart.Total += 10 * q
+	}
+	return cart, nil
+}
+
+func (m *mockCartService) AddItem(userID int, item string, quantity int) (service.Cart, error) {
+	if quantity < 1 {
+		return service.Cart{}, service.ErrInvalidQuantity
+	}
+	m.lines[item] += quantity
+	return m.GetCart(userID)
+}
+
+func (m *mockCartService) RemoveItem(userID int, item string) (service.Cart, error) {
+	if _, ok := m.lines[item]; !ok {
+		return service.Cart{}, service.ErrNotInCart
+	}
+	delete(m.lines, item)
+	return m.GetCart(userID)
+}
+
+func (m *mockCartService) Checkout(userID int, promoCode string) (service.Receipt, error) {
+	if m.CheckoutErr != nil {
+		return service.Receipt{}, m.CheckoutErr
+	}
+	if promoCode != "" {
+		return service.Receipt{}, service.ErrPromoUsedUp
+	}
+	if len(m.lines) == 0 {
+		return service.Receipt{}, service.ErrCartEmpty
+	}
+	cart, _ := m.GetCart(userID)
+	m.lines = map[string]int{}
+	return service.Receipt{Total: cart.Total, Balance: 1000 - cart.Total}, nil
+}
+
+// mockMarketService keeps listings in memory; sellers are named by their ID.
+type mockMarketService struct {
+	service.MarketService
+	listings []service.Listing
+}
+
+func (m *mockMarketService) ListListings(item string, page service.Page) ([]service.Listing, error) {
+	var open []service.Listing
+	for _, l := range m.listings {
+		if l.Status == "open" && (item == "" || l.Item == item) {
+			open = append(open, l)
+		}
+	}
+	return open, nil
+}
+
+func (m *mockMarketService) CreateListing(userID int, item string, quantity, price int) (service.Listing, error) {
+	if price < 1 {
+		return service.Listing{}, service.ErrInvalidPrice
+	}
+	l := service.Listing{ID: len(m.listings) + 1, Seller: strconv.Itoa(userID), Item: item, Quantity: quantity, Price: price, Status: "open"}
+	m.listings = append(m.listings, l)
+	return l, nil
+}
+
+func (m *mockMarketService) close(userID, listingID int, status string) (service.Listing, error) {
+	if listingID < 1 || listingID > len(m.listings) {
+		return service.Listing{}, service.ErrListingNotFound
+	}
+	l := &m.listings[listingID-1]
+	switch {
+	case l.Status != "open":
+		return service.Listing{}, service.ErrListingClosed
+	case status == "sold" && l.Seller == strconv.Itoa(userID):
+		return service.Listing{}, service.ErrOwnListing
+	case status == "cancelled" && l.Seller != strconv.Itoa(userID):
+		return service.Listing{}, service.ErrListingNotFound
+	}
+	l.Status = status
+	return *l, nil
+}
+
+func (m *mockMarketService) BuyListing(userID, listingID int) (service.Listing, error) {
+	return m.close(userID, listingID, "sold")
+}
+
+func (m *mockMarketService) CancelListing(userID, listingID int) (service.Listing, error) {
+	return m.close(userID, listingID, "cancelled")
+}
+
+// mockPromoService keeps promo codes in memory.
+type mockPromoService struct {
+	codes []service.PromoCode
+}
+
+func (m *mockPromoService) CreatePromoCode(adminID int, code service.PromoCode) (service.PromoCode, error) {
+	if code.Kind != "percent" && code.Kind != "fixed" {
+		return service.PromoCode{}, service.ErrInvalidPromo
+	}
+	for _, c := range m.codes {
+		if c.Code == code.Code {
+			return service.PromoCode{}, service.ErrPromoExists
+		}
+	}
+	m.codes = append(m.codes, code)
+	return code, nil
+}
+
+func (m *mockPromoService) ListPromoCodes() ([]service.PromoCode, error) {
+	return m.codes, nil
+}
+
+func newTestRouter(h *Handlers) *echo.Echo {
+	e := echo.New()
+	e.Use(middleware.JWTAuthMiddleware(middleware.JWTConfig{
+		Keyfunc:      testKeys.Keyfunc,
+		Expectations: testExpectations,
+		Skipper:      middleware.PublicRoutes(PublicRoutes...),
+	}, zap.NewNop()))
+	e.Use(middleware.Authorize(RoutePolicies, zap.NewNop()))
+	RegisterHandlers(e, h)
+	e.GET(JWKSPath, h.GetJWKS)
+	return e
+}
+
+func newTestHandlers() *Handlers {
+	return &Handlers{
+		AuthService: &mockAuthService{
+			AuthenticateFunc: func(username, password, clientIP string) (service.Tokens, error) {
+				if password != "pass" {
+					return service.Tokens{}, service.ErrInvalidCredentials
+				}
+				return service.Tokens{AccessToken: "token-for-" + username, RefreshToken: "refresh"}, nil
+			},
+			RefreshFunc: func(refreshToken string) (service.Tokens, error) {
+				if refreshToken != "refresh" {
+					return service.Tokens{}, service.ErrRefreshTokenReused
+				}
+				return service.Tokens{AccessToken: "rotated", RefreshToken: "refresh2"}, nil
+			},
+			GetUserFunc: func(userID int) (service.User, error) {
+				if userID != 2 {
+					return service.User{}, service.ErrUserNotFound
+				}
+				return service.User{ID: 2, Username: "bob", Role: token.RoleEmployee, Coins: 1000}, nil
+			},
+			SetRoleFunc: func(userID int, role string) error {
+				if !token.IsValidRole(role) {
+					return service.ErrInvalidRole
+				}
+				if userID != 2 {
+					return service.ErrUserNotFound
+				}
+				return nil
+			},
+			RevokeAllFunc: func(userID int) error {
+				if userID != 2 {
+					return service.ErrUserNotFound
+				}
+				return nil
+			},
+			UnlockFunc: func(userID int) error {
+				if userID != 2 {
+					return service.ErrUserNotFound
+				}
+				return nil
+			},
+		},
+		ShopService: &mockShopService{
+			GetUserInfoFunc: func(userID int) (service.Info, error) {
+				return service.Info{Coins: 1000}, nil
+			},
+		},
+		CatalogService: &mockCatalogService{},
+		CartService:    &mockCartService{lines: map[string]int{}},
+		MarketService:  &mockMarketService{},
+		PromoService:   &mockPromoService{},
+		Logger:         zap.NewNop(),
+		Keys:           testKeys,
+	}
+}
+
+func testToken(t *testing.T, userID int, roles ...string) string {
+	t.Helper()
+	now := time.Now()
+	signed, err := testKeys.Sign(&token.Claims{
+		UserID:   userID,
+		Username: "tester",
+		Roles:    roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "test-token",
+			Issuer:    testExpectations.Issuer,
+			Audience:  jwt.ClaimStrings{testExpectations.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestRouter_AuthWithoutToken(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(`{"username":"alice","password":"pass"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "token-for-alice") {
+		t.Errorf("expected token in response, got %s", rec.Body.String())
+	}
+}
+
+func TestRouter_AuthWrongPassword(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(`{"username":"alice","password":"nope"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", rec.Code)
+	}
+}
+
+func TestRouter_AuthThrottled(t *testing.T) {
+	h := newTestHandlers()
+	var gotIP string
+	h.AuthService.(*mockAuthService).AuthenticateFunc = func(username, password, clientIP string) (service.Tokens, error) {
+		gotIP = clientIP
+		return service.Tokens{}, &service.ThrottledError{RetryAfter: 1500 * time.Millisecond}
+	}
+	e := newTestRouter(h)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth", strings.NewReader(`{"username":"alice","password":"pass"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = "192.0.2.7:51000"
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected Retry-After 2, got %q", got)
+	}
+	if gotIP != "192.0.2.7" {
+		t.Errorf("expected client IP to be passed, got %q", gotIP)
+	}
+}
+
+func TestRouter_RefreshWithoutToken(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+
+	for body, want := range map[string]int{
+		`{"refreshToken":"refresh"}`: http.StatusOK,
+		`{"refreshToken":"stolen"}`:  http.StatusUnauthorized,
+		`{}`:                         http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d", body, want, rec.Code)
+		}
+	}
+}
+
+func TestRouter_ProtectedRouteRequiresToken(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/info", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/info", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 with token, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_Logout(t *testing.T) {
+	h := newTestHandlers()
+	var loggedOut []string
+	h.AuthService.(*mockAuthService).LogoutFunc = func(userID int, tokenID string, expiresAt time.Time, refreshToken string) error {
+		if refreshToken == "foreign" {
+			return service.ErrInvalidRefreshToken
+		}
+		loggedOut = append(loggedOut, tokenID+"/"+refreshToken)
+		return nil
+	}
+	e := newTestRouter(h)
+
+	for body, want := range map[string]int{
+		``:                           http.StatusOK,
+		`{"refreshToken":"refresh"}`: http.StatusOK,
+		`{"refreshToken":"foreign"}`: http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%q: expected status %d, got %d: %s", body, want, rec.Code, rec.Body.String())
+		}
+	}
+	if len(loggedOut) != 2 || loggedOut[0] == loggedOut[1] {
+		t.Errorf("unexpected logouts: %v", loggedOut)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without token, got %d", rec.Code)
+	}
+}
+
+func TestRouter_JWKS(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"kid":"`+testKeys.SigningKeyID()+`"`) {
+		t.Errorf("expected signing key in JWKS, got %s", rec.Body.String())
+	}
+}
+
+func TestRouter_AdminRoles(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+
+	type endpoint struct {
+		method, path, body string
+	}
+	getUser := endpoint{http.MethodGet, "/api/admin/users/2", ""}
+	setRole := endpoint{http.MethodPut, "/api/admin/users/2/role", `{"role":"auditor"}`}
+	revoke := endpoint{http.MethodPost, "/api/admin/users/2/revoke-sessions", ""}
+	unlock := endpoint{http.MethodPost, "/api/admin/users/2/unlock", ""}
+	listItems := endpoint{http.MethodGet, "/api/admin/items", ""}
+	createItem := endpoint{http.MethodPost, "/api/admin/items", `{"slug":"mug","name":"Mug","price":30}`}
+	updateItem := endpoint{http.MethodPatch, "/api/admin/items/cup", `{"price":25}`}
+	deactivate := endpoint{http.MethodPost, "/api/admin/items/cup/deactivate", ""}
+	restore := endpoint{http.MethodPost, "/api/admin/items/cup/restore", ""}
+	history := endpoint{http.MethodGet, "/api/admin/items/cup/history", ""}
+
+	cases := []struct {
+		role     string
+		endpoint endpoint
+		want     int
+	}{
+		{token.RoleEmployee, getUser, http.StatusForbidden},
+		{token.RoleEmployee, setRole, http.StatusForbidden},
+		{token.RoleEmployee, revoke, http.StatusForbidden},
+		{token.RoleEmployee, unlock, http.StatusForbidden},
+		{token.RoleAuditor, getUser, http.StatusOK},
+		{token.RoleAuditor, setRole, http.StatusForbidden},
+		{token.RoleAuditor, revoke, http.StatusForbidden},
+		{token.RoleAuditor, unlock, http.StatusForbidden},
+		{token.RoleAdmin, getUser, http.StatusOK},
+		{token.RoleAdmin, setRole, http.StatusOK},
+		{token.RoleAdmin, revoke, http.StatusOK},
+		{token.RoleAdmin, unlock, http.StatusOK},
+		{token.RoleEmployee, listItems, http.StatusForbidden},
+		{token.RoleEmployee, createItem, http.StatusForbidden},
+		{token.RoleAuditor, listItems, http.StatusOK},
+		{token.RoleAuditor, history, http.StatusOK},
+		{token.RoleAuditor, createItem, http.StatusForbidden},
+		{token.RoleAuditor, updateItem, http.StatusForbidden},
+		{token.RoleAuditor, deactivate, http.StatusForbidden},
+		{token.RoleAuditor, restore, http.StatusForbidden},
+		{token.RoleAdmin, listItems, http.StatusOK},
+		{token.RoleAdmin, history, http.StatusOK},
+		{token.RoleAdmin, createItem, http.StatusOK},
+		{token.RoleAdmin, updateItem, http.StatusOK},
+		{token.RoleAdmin, deactivate, http.StatusOK},
+		{token.RoleAdmin, restore, http.StatusOK},
+		{"", getUser, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.endpoint.method, tc.endpoint.path, strings.NewReader(tc.endpoint.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if tc.role == "" {
+			req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		} else {
+			req.Header.Set("Authorization", "Bearer "+testToken(t, 1, tc.role))
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s as %q: expected status %d, got %d: %s",
+				tc.endpoint.method, tc.endpoint.path, tc.role, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/users/2", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without token, got %d", rec.Code)
+	}
+}
+
+func TestRouter_AdminErrors(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+	adminToken := testToken(t, 1, token.RoleAdmin)
+
+	cases := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodGet, "/api/admin/users/3", "", http.StatusNotFound},
+		{http.MethodGet, "/api/admin/users/abc", "", http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/users/2/role", `{"role":"superuser"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/admin/users/3/role", `{"role":"admin"}`, http.StatusNotFound},
+		{http.MethodPost, "/api/admin/users/3/revoke-sessions", "", http.StatusNotFound},
+		{http.MethodPost, "/api/admin/users/3/unlock", "", http.StatusNotFound},
+		{http.MethodPost, "/api/admin/items", `{"slug":"cup","name":"Cup","price":20}`, http.StatusConflict},
+		{http.MethodPost, "/api/admin/items", `{"slug":"mug","name":"Mug","price":0}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/items", `{"slug":`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/admin/items/cup", `{"price":-1}`, http.StatusBadRequest},
+		{http.MethodPatch, "/api/admin/items/mug", `{"price":25}`, http.StatusNotFound},
+		{http.MethodPost, "/api/admin/items/mug/deactivate", "", http.StatusNotFound},
+		{http.MethodPost, "/api/admin/items/mug/restore", "", http.StatusNotFound},
+		{http.MethodGet, "/api/admin/items/mug/history", "", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tc.method, tc.path, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestRouter_BuyItemErrors(t *testing.T) {
+	h := newTestHandlers()
+	h.ShopService.(*mockShopService).BuyItemFunc = func(userID int, item string) error {
+		switch item {
+		case "pink-hoody":
+			return service.ErrPurchaseLimitReached
+		case "umbrella":
+			return service.ErrOutOfStock
+		case "yacht":
+			return service.ErrItemNotFound
+		}
+		return nil
+	}
+	e := newTestRouter(h)
+
+	cases := map[string]int{
+		"cup":        http.StatusOK,
+		"yacht":      http.StatusBadRequest,
+		"umbrella":   http.StatusConflict,
+		"pink-hoody": http.StatusConflict,
+	}
+	for item, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/buy/"+item, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d: %s", item, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestRouter_Buy(t *testing.T) {
+	h := newTestHandlers()
+	h.ShopService.(*mockShopService).BuyFunc = func(userID int, lines []service.PurchaseLine, promoCode string) (service.Receipt, error) {
+		switch promoCode {
+		case "", "HALF":
+		case "GONE":
+			return service.Receipt{}, service.ErrPromoUsedUp
+		default:
+			return service.Receipt{}, service.ErrPromoNotFound
+		}
+		var receipt service.Receipt
+		for _, l := range lines {
+			switch {
+			case l.Quantity < 1:
+				return service.Receipt{}, service.ErrInvalidQuantity
+			case l.Item == "umbrella":
+				return service.Receipt{}, fmt.Errorf("%w: %s", service.ErrOutOfStock, l.Item)
+			}
+			line := service.ReceiptLine{Item: l.Item, Quantity: l.Quantity, Price: 10, Total: 10 * l.Quantity}
+			if promoCode != "" {
+				line.Discount = line.Total / 2
+				line.Total -= line.Discount
+			}
+			receipt.Lines = append(receipt.Lines, line)
+			receipt.Discount += line.Discount
+			receipt.Total += line.Total
+		}
+		receipt.PromoCode = promoCode
+		receipt.Balance = 1000 - receipt.Total
+		return receipt, nil
+	}
+	e := newTestRouter(h)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/buy", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(`{"items":[{"item":"socks","quantity":5},{"item":"pen","quantity":1}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var receipt Receipt
+	if err := json.Unmarshal(rec.Body.Bytes(), &receipt); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(receipt.Lines) != 2 || receipt.Lines[0].Total != 50 || receipt.Total != 60 || receipt.Balance != 940 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+
+	if rec := post(`{"items":[{"item":"socks","quantity":0}]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for zero quantity, got %d", rec.Code)
+	}
+	rec = post(`{"items":[{"item":"umbrella","quantity":1}]}`)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "umbrella") {
+		t.Errorf("expected status 409 naming the item, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post(`{"items":`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for malformed body, got %d", rec.Code)
+	}
+
+	rec = post(`{"items":[{"item":"socks","quantity":2}],"promoCode":"HALF"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	receipt = Receipt{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &receipt); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if receipt.Discount != 10 || receipt.Total != 10 || receipt.PromoCode == nil || *receipt.PromoCode != "HALF" ||
+		receipt.Lines[0].Discount == nil || *receipt.Lines[0].Discount != 10 {
+		t.Errorf("unexpected discounted receipt: %+v", receipt)
+	}
+	if rec := post(`{"items":[{"item":"socks","quantity":1}],"promoCode":"NOPE"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown promo code, got %d", rec.Code)
+	}
+	if rec := post(`{"items":[{"item":"socks","quantity":1}],"promoCode":"GONE"}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a used up promo code, got %d", rec.Code)
+	}
+}
+
+func TestRouter_Cart(t *testing.T) {
+	h := newTestHandlers()
+	e := newTestRouter(h)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/cart/items", `{"item":"socks","quantity":3}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var cart CartResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &cart); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(cart.Lines) != 1 || cart.Lines[0].Total != 30 || cart.Total != 30 {
+		t.Errorf("unexpected cart: %+v", cart)
+	}
+	if rec := do(http.MethodPost, "/api/cart/items", `{"item":"socks","quantity":0}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for zero quantity, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/cart/items/cup", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an item not in the cart, got %d", rec.Code)
+	}
+
+	if rec := do(http.MethodPost, "/api/cart/checkout", `{"promoCode":"GONE"}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a used up promo code, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/cart/checkout", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/cart/checkout", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an empty cart, got %d", rec.Code)
+	}
+
+	h.CartService.(*mockCartService).CheckoutErr = &service.CheckoutError{Problems: []service.LineProblem{
+		{Item: "hoody", Reason: service.ProblemPriceChanged, CartPrice: 300, Price: ptrInt(250)},
+	}}
+	rec = do(http.MethodPost, "/api/cart/checkout", "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var checkoutErr CheckoutErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &checkoutErr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(checkoutErr.Problems) != 1 || checkoutErr.Problems[0].Reason != service.ProblemPriceChanged ||
+		checkoutErr.Problems[0].Price == nil || *checkoutErr.Problems[0].Price != 250 {
+		t.Errorf("unexpected problems: %+v", checkoutErr.Problems)
+	}
+}
+
+func TestRouter_Orders(t *testing.T) {
+	h := newTestHandlers()
+	bought := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	h.ShopService.(*mockShopService).Orders = map[int][]service.Order{
+		1: {
+			{ID: 7, Lines: []service.ReceiptLine{{Item: "cup", Name: "Cup", Quantity: 2, Price: 20, Total: 40}}, Total: 40, CreatedAt: bought},
+			{ID: 3, Lines: []service.ReceiptLine{{Item: "pen", Name: "Pen", Quantity: 1, Price: 10, Total: 10}}, Total: 10, CreatedAt: bought},
+		},
+		2: {{ID: 5, Total: 80, CreatedAt: bought}},
+	}
+	e := newTestRouter(h)
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/orders?offset=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var orders OrdersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &orders); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(orders.Orders) != 1 || orders.Orders[0].Id != 3 {
+		t.Errorf("unexpected orders: %+v", orders)
+	}
+	rec = get("/api/orders?offset=5")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"orders":[]`) {
+		t.Errorf("expected an empty page, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, target := range []string{"/api/orders?limit=0", "/api/orders?limit=500", "/api/orders?offset=-1", "/api/orders?limit=x"} {
+		if rec := get(target); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, rec.Code)
+		}
+	}
+
+	rec = get("/api/orders/7")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var order Order
+	if err := json.Unmarshal(rec.Body.Bytes(), &order); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if order.Id != 7 || order.Total != 40 || !order.CreatedAt.Equal(bought) ||
+		len(order.Lines) != 1 || order.Lines[0].Name != "Cup" || order.Lines[0].Price != 20 {
+		t.Errorf("unexpected order: %+v", order)
+	}
+	if rec := get("/api/orders/5"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an order of another user, got %d", rec.Code)
+	}
+}
+
+func TestRouter_Refund(t *testing.T) {
+	h := newTestHandlers()
+	shop := h.ShopService.(*mockShopService)
+	shop.Orders = map[int][]service.Order{
+		1: {{ID: 7, Lines: []service.ReceiptLine{{Item: "cup", Name: "Cup", Quantity: 2, Price: 20, Total: 40}}, Total: 40}},
+		2: {{ID: 5, Total: 80}},
+	}
+	var gotLines []service.PurchaseLine
+	shop.RefundFunc = func(userID, orderID int, lines []service.PurchaseLine) (service.Refund, error) {
+		gotLines = lines
+		switch {
+		case orderID != 7 || userID != 1:
+			return service.Refund{}, service.ErrOrderNotFound
+		case len(lines) == 1 && lines[0].Quantity > 2:
+			return service.Refund{}, fmt.Errorf("%w: only 2 of cup are left to refund", service.ErrNotRefundable)
+		}
+		return service.Refund{ID: 1, OrderID: 7, Total: 20}, nil
+	}
+	e := newTestRouter(h)
+	post := func(target, body string, roles ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1, roles...))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/api/orders/7/refund", `{"items":[{"item":"cup","quantity":1}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var refund Refund
+	if err := json.Unmarshal(rec.Body.Bytes(), &refund); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if refund.OrderId != 7 || refund.Total != 20 || len(gotLines) != 1 {
+		t.Errorf("unexpected refund %+v of lines %+v", refund, gotLines)
+	}
+	if rec := post("/api/orders/7/refund", ""); rec.Code != http.StatusOK || gotLines != nil {
+		t.Errorf("expected the whole order to be refunded without a body, got %d and lines %+v", rec.Code, gotLines)
+	}
+	if rec := post("/api/orders/7/refund", `{"items":[{"item":"cup","quantity":3}]}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", rec.Code)
+	}
+	if rec := post("/api/orders/5/refund", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an order of another user, got %d", rec.Code)
+	}
+
+	if rec := post("/api/admin/orders/5/refund", "", token.RoleAuditor); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an auditor, got %d", rec.Code)
+	}
+	rec = post("/api/admin/orders/5/refund", "", token.RoleAdmin)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"total":80`) {
+		t.Errorf("expected status 200 refunding 80, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_Gift(t *testing.T) {
+	h := newTestHandlers()
+	var got service.GiftRequest
+	h.ShopService.(*mockShopService).GiftFunc = func(fromUserID int, req service.GiftRequest) (service.Gift, error) {
+		got = req
+		switch {
+		case req.To == "nobody":
+			return service.Gift{}, service.ErrUserNotFound
+		case req.FromInventory:
+			return service.Gift{}, fmt.Errorf("%w: %s", service.ErrNotEnoughItems, req.Item)
+		case req.Item == "umbrella":
+			return service.Gift{}, fmt.Errorf("%w: %s", service.ErrOutOfStock, req.Item)
+		}
+		return service.Gift{ID: 3, ToUser: req.To, Item: req.Item, Quantity: req.Quantity, Message: req.Message, OrderID: ptrInt(5), Total: 20}, nil
+	}
+	e := newTestRouter(h)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/gift", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(`{"toUser":"bob","item":"cup","message":"Enjoy!"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var gift GiftResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &gift); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// the quantity defaults to one
+	if got.Quantity != 1 || gift.Id != 3 || gift.OrderId == nil || *gift.OrderId != 5 || gift.Message == nil || *gift.Message != "Enjoy!" {
+		t.Errorf("unexpected gift %+v for request %+v", gift, got)
+	}
+
+	for body, want := range map[string]int{
+		`{"toUser":"nobody","item":"cup"}`:                   http.StatusBadRequest,
+		`{"toUser":"bob","item":"cup","fromInventory":true}`: http.StatusBadRequest,
+		`{"toUser":"bob","item":"umbrella","quantity":2}`:    http.StatusConflict,
+	} {
+		if rec := post(body); rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d", body, want, rec.Code)
+		}
+	}
+}
+
+func TestRouter_History(t *testing.T) {
+	h := newTestHandlers()
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var notes []string
+	shop := h.ShopService.(*mockShopService)
+	shop.SendCoinsFunc = func(fromUserID int, toUsername string, amount int, note string) error {
+		notes = append(notes, note)
+		if len(note) > 200 {
+			return fmt.Errorf("%w: note must be at most 200 characters long", service.ErrInvalidNote)
+		}
+		return nil
+	}
+	var gotFilter service.HistoryFilter
+	var gotLimit int
+	var gotCursor string
+	shop.GetCoinHistoryFunc = func(userID int, filter service.HistoryFilter, limit int, cursor string) (service.HistoryPage, error) {
+		gotFilter, gotLimit, gotCursor = filter, limit, cursor
+		switch {
+		case filter.Direction != "" && filter.Direction != "sent" && filter.Direction != "received":
+			return service.HistoryPage{}, fmt.Errorf("%w: direction must be sent or received", service.ErrInvalidFilter)
+		case cursor == "bogus":
+			return service.HistoryPage{}, fmt.Errorf("%w: invalid cursor", service.ErrInvalidPage)
+		}
+		return service.HistoryPage{
+			Transactions: []service.CoinTransaction{
+				{ID: 4, Type: "received", Counterparty: "bob", Amount: 10, CreatedAt: created},
+				{ID: 2, Type: "sent", Counterparty: "bob", Amount: 30, Note: "thanks", CreatedAt: created.Add(-time.Hour)},
+			},
+			NextCursor: "next",
+		}, nil
+	}
+	e := newTestRouter(h)
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/sendCoin", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(`{"toUser":"bob","amount":30,"note":"thanks"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// the note is optional
+	if rec := send(`{"toUser":"bob","amount":30}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(`{"toUser":"bob","amount":30,"note":"` + strings.Repeat("a", 201) + `"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a long note, got %d", rec.Code)
+	}
+	if len(notes) != 3 || notes[0] != "thanks" || notes[1] != "" {
+		t.Errorf("unexpected notes %q", notes)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/history")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var history HistoryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(history.Transactions) != 2 || history.NextCursor == nil || *history.NextCursor != "next" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	received, sent := history.Transactions[0], history.Transactions[1]
+	if received.Id != 4 || received.Type != Received || received.Note != nil || !received.CreatedAt.Equal(created) {
+		t.Errorf("unexpected transaction %+v", received)
+	}
+	if sent.Type != Sent || sent.Note == nil || *sent.Note != "thanks" {
+		t.Errorf("unexpected transaction %+v", sent)
+	}
+	if strings.Contains(rec.Body.String(), `"note":null`) {
+		t.Errorf("expected empty notes to be left out: %s", rec.Body.String())
+	}
+	if gotLimit != 0 || gotCursor != "" || gotFilter != (service.HistoryFilter{}) {
+		t.Errorf("expected no filter, got %+v, limit %d, cursor %q", gotFilter, gotLimit, gotCursor)
+	}
+
+	rec = get("/api/history?direction=sent&counterparty=bob&minAmount=5&maxAmount=50&from=2025-03-01T00:00:00Z&to=2025-03-02T00:00:00%2B03:00&limit=10&cursor=abc")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotFilter.Direction != "sent" || gotFilter.Counterparty != "bob" ||
+		gotFilter.MinAmount == nil || *gotFilter.MinAmount != 5 || gotFilter.MaxAmount == nil || *gotFilter.MaxAmount != 50 ||
+		gotFilter.From == nil || !gotFilter.From.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)) ||
+		gotFilter.To == nil || !gotFilter.To.Equal(time.Date(2025, 3, 1, 21, 0, 0, 0, time.UTC)) ||
+		gotLimit != 10 || gotCursor != "abc" {
+		t.Errorf("unexpected filter %+v, limit %d, cursor %q", gotFilter, gotLimit, gotCursor)
+	}
+
+	for _, target := range []string{
+		"/api/history?direction=stolen",
+		"/api/history?cursor=bogus",
+		"/api/history?limit=0",
+		"/api/history?minAmount=many",
+		"/api/history?from=yesterday",
+	} {
+		if rec := get(target); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestRouter_Market(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+	do := func(method, target, body string, userID int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken(t, userID))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/market/listings", `{"item":"cup","price":150}`, 1)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var listing Listing
+	if err := json.Unmarshal(rec.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// the quantity defaults to one
+	if listing.Id != 1 || listing.Quantity != 1 || listing.Status != Open {
+		t.Errorf("unexpected listing: %+v", listing)
+	}
+	if rec := do(http.MethodPost, "/api/market/listings", `{"item":"pen","price":0}`, 1); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a zero price, got %d", rec.Code)
+	}
+	do(http.MethodPost, "/api/market/listings", `{"item":"pen","quantity":3,"price":30}`, 1)
+
+	rec = do(http.MethodGet, "/api/market/listings?item=pen", "", 2)
+	var page ListingsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(page.Listings) != 1 || page.Listings[0].Item != "pen" {
+		t.Errorf("expected only the pen listing, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodPost, "/api/market/listings/1/buy", "", 1); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 buying your own listing, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/market/listings/1/buy", "", 2); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"sold"`) {
+		t.Errorf("expected the listing to be sold, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/market/listings/1/buy", "", 3); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 buying a sold listing, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/market/listings/2/cancel", "", 2); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 cancelling a listing of another user, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/market/listings/2/cancel", "", 1); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/market/listings/9/buy", "", 2); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rec.Code)
+	}
+}
+
+func TestRouter_PromoCodes(t *testing.T) {
+	e := newTestRouter(newTestHandlers())
+	do := func(method, body, role string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/promo-codes", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1, role))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"code":"SPRING","kind":"percent","value":10,"items":["cup"],"maxPerUser":1}`
+	if rec := do(http.MethodPost, body, token.RoleAuditor); rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for an auditor, got %d", rec.Code)
+	}
+	rec := do(http.MethodPost, body, token.RoleAdmin)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, body, token.RoleAdmin); rec.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for a duplicate code, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, `{"code":"AUTUMN","kind":"free","value":10}`, token.RoleAdmin); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown kind, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodGet, "", token.RoleAuditor)
+	var resp PromoCodesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(resp.PromoCodes) != 1 {
+		t.Fatalf("expected one promo code, got %d: %s", rec.Code, rec.Body.String())
+	}
+	c := resp.PromoCodes[0]
+	if c.Kind != Percent || c.Items == nil || (*c.Items)[0] != "cup" || c.MaxPerUser == nil || *c.MaxPerUser != 1 || c.MaxRedemptions != nil {
+		t.Errorf("unexpected promo code: %+v", c)
+	}
+}
+
+func ptrInt(v int) *int {
+	return &v
+}
+
+func TestRouter_Items(t *testing.T) {
+	h := newTestHandlers()
+	var gotFilter service.ItemFilter
+	h.ShopService.(*mockShopService).ListItemsFunc = func(userID int, filter service.ItemFilter) ([]service.CatalogItem, error) {
+		gotFilter = filter
+		if filter.Sort == "random" {
+			return nil, service.ErrInvalidSort
+		}
+		return []service.CatalogItem{{Slug: "cup", Name: "Cup", Price: 20, Available: true, CanAfford: true}}, nil
+	}
+	e := newTestRouter(h)
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/items?maxPrice=100&sort=-price", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotFilter.MaxPrice == nil || *gotFilter.MaxPrice != 100 || gotFilter.Sort != service.SortByPriceDesc {
+		t.Errorf("unexpected filter: %+v", gotFilter)
+	}
+	var body ItemsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(body.Items) != 1 || body.Items[0].Slug != "cup" || !body.Items[0].CanAfford {
+		t.Errorf("unexpected items: %+v", body.Items)
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected ETag header")
+	}
+	if rec := get("/api/items?maxPrice=100&sort=-price", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("expected status 304 for matching ETag, got %d", rec.Code)
+	}
+	if rec := get("/api/items", `"stale"`); rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 for stale ETag, got %d", rec.Code)
+	}
+
+	for _, target := range []string{"/api/items?sort=random", "/api/items?maxPrice=-1", "/api/items?maxPrice=abc"} {
+		if rec := get(target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, rec.Code)
+		}
+	}
+}
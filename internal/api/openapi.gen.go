@@ -6,6 +6,7 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/oapi-codegen/runtime"
@@ -15,6 +16,81 @@ const (
 	BearerAuthScopes = "BearerAuth.Scopes"
 )
 
+// Defines values for CoinTransactionType.
+const (
+	Received CoinTransactionType = "received"
+	Sent     CoinTransactionType = "sent"
+)
+
+// Defines values for ListingStatus.
+const (
+	Cancelled ListingStatus = "cancelled"
+	Open      ListingStatus = "open"
+	Sold      ListingStatus = "sold"
+)
+
+// Defines values for PromoKind.
+const (
+	Fixed   PromoKind = "fixed"
+	Percent PromoKind = "percent"
+)
+
+// AddCartItemRequest defines model for AddCartItemRequest.
+type AddCartItemRequest struct {
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Quantity Сколько добавить, больше нуля.
+	Quantity int `json:"quantity"`
+}
+
+// AdminItem defines model for AdminItem.
+type AdminItem struct {
+	// Active Продается ли предмет.
+	Active bool `json:"active"`
+
+	// CreatedAt Время добавления в каталог.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// MaxPerUser Сколько штук может купить один пользователь; отсутствует, если без ограничений.
+	MaxPerUser *int `json:"maxPerUser,omitempty"`
+
+	// Name Название предмета.
+	Name string `json:"name"`
+
+	// Price Цена в монетах.
+	Price int `json:"price"`
+
+	// Slug Идентификатор предмета, используемый при покупке.
+	Slug string `json:"slug"`
+
+	// Stock Сколько штук осталось; отсутствует, если количество не ограничено.
+	Stock *int `json:"stock,omitempty"`
+
+	// UpdatedAt Время последнего изменения.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// AdminItemsResponse defines model for AdminItemsResponse.
+type AdminItemsResponse struct {
+	Items []AdminItem `json:"items"`
+}
+
+// AdminUserResponse defines model for AdminUserResponse.
+type AdminUserResponse struct {
+	// Coins Количество доступных монет.
+	Coins int `json:"coins"`
+
+	// Id Идентификатор пользователя.
+	Id int `json:"id"`
+
+	// Role Роль пользователя: employee, admin или auditor.
+	Role string `json:"role"`
+
+	// Username Имя пользователя.
+	Username string `json:"username"`
+}
+
 // AuthRequest defines model for AuthRequest.
 type AuthRequest struct {
 	// Password Пароль для аутентификации.
@@ -26,18 +102,284 @@ type AuthRequest struct {
 
 // AuthResponse defines model for AuthResponse.
 type AuthResponse struct {
+	// RefreshToken Одноразовый токен для получения новой пары токенов.
+	RefreshToken *string `json:"refreshToken,omitempty"`
+
 	// Token JWT-токен для доступа к защищенным ресурсам.
 	Token *string `json:"token,omitempty"`
 }
 
+// BuyLine defines model for BuyLine.
+type BuyLine struct {
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Quantity Количество, от 1 до 1000.
+	Quantity int `json:"quantity"`
+}
+
+// BuyRequest defines model for BuyRequest.
+type BuyRequest struct {
+	// Items Строки покупки; одинаковые предметы складываются.
+	Items []BuyLine `json:"items"`
+
+	// PromoCode Промокод, необязательно.
+	PromoCode *string `json:"promoCode,omitempty"`
+}
+
+// CartLine defines model for CartLine.
+type CartLine struct {
+	// Available Можно ли сейчас купить это количество.
+	Available bool `json:"available"`
+
+	// CartPrice Цена за штуку на момент добавления в корзину.
+	CartPrice int `json:"cartPrice"`
+
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Name Название предмета.
+	Name string `json:"name"`
+
+	// Price Текущая цена за штуку.
+	Price int `json:"price"`
+
+	// Quantity Количество.
+	Quantity int `json:"quantity"`
+
+	// Total Стоимость строки по текущей цене.
+	Total int `json:"total"`
+}
+
+// CartResponse defines model for CartResponse.
+type CartResponse struct {
+	Lines []CartLine `json:"lines"`
+
+	// Total Стоимость корзины по текущим ценам.
+	Total int `json:"total"`
+}
+
+// CatalogItem defines model for CatalogItem.
+type CatalogItem struct {
+	// Available Можно ли купить предмет сейчас.
+	Available bool `json:"available"`
+
+	// CanAfford Хватает ли монет текущему пользователю.
+	CanAfford bool `json:"canAfford"`
+
+	// Name Название предмета.
+	Name string `json:"name"`
+
+	// Price Цена в монетах.
+	Price int `json:"price"`
+
+	// Slug Идентификатор предмета, используемый при покупке.
+	Slug string `json:"slug"`
+
+	// Stock Сколько штук осталось; отсутствует, если количество не ограничено.
+	Stock *int `json:"stock,omitempty"`
+}
+
+// CheckoutErrorResponse defines model for CheckoutErrorResponse.
+type CheckoutErrorResponse struct {
+	// Errors Сообщение об ошибке, описывающее проблему.
+	Errors   string            `json:"errors"`
+	Problems []CheckoutProblem `json:"problems"`
+}
+
+// CheckoutProblem defines model for CheckoutProblem.
+type CheckoutProblem struct {
+	// CartPrice Цена на момент добавления в корзину.
+	CartPrice int `json:"cartPrice"`
+
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Price Текущая цена; отсутствует для unavailable.
+	Price *int `json:"price,omitempty"`
+
+	// Reason Причина: price_changed, out_of_stock, unavailable или limit_reached.
+	Reason string `json:"reason"`
+
+	// Stock Сколько штук осталось; только для out_of_stock.
+	Stock *int `json:"stock,omitempty"`
+}
+
+// CheckoutRequest defines model for CheckoutRequest.
+type CheckoutRequest struct {
+	// PromoCode Промокод, необязательно.
+	PromoCode *string `json:"promoCode,omitempty"`
+}
+
+// CoinTransaction defines model for CoinTransaction.
+type CoinTransaction struct {
+	// Amount Количество монет.
+	Amount int `json:"amount"`
+
+	// Counterparty Имя получателя отправленного перевода или отправителя полученного.
+	Counterparty string `json:"counterparty"`
+
+	// CreatedAt Время перевода.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Id Номер записи истории.
+	Id int `json:"id"`
+
+	// Note Комментарий к переводу.
+	Note *string `json:"note,omitempty"`
+
+	// Type Направление перевода: отправлен или получен.
+	Type CoinTransactionType `json:"type"`
+}
+
+// CoinTransactionType Направление перевода: отправлен или получен.
+type CoinTransactionType string
+
+// CreateItemRequest defines model for CreateItemRequest.
+type CreateItemRequest struct {
+	// MaxPerUser Сколько штук может купить один пользователь, больше нуля; без него не ограничено.
+	MaxPerUser *int `json:"maxPerUser,omitempty"`
+
+	// Name Название предмета.
+	Name string `json:"name"`
+
+	// Price Цена в монетах, больше нуля.
+	Price int `json:"price"`
+
+	// Slug Идентификатор: строчные латинские буквы, цифры и дефис.
+	Slug string `json:"slug"`
+
+	// Stock Количество в наличии, не меньше нуля; без него количество не ограничено.
+	Stock *int `json:"stock,omitempty"`
+}
+
+// CreateListingRequest defines model for CreateListingRequest.
+type CreateListingRequest struct {
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Price Цена за все объявление в монетах.
+	Price int `json:"price"`
+
+	// Quantity Количество, от 1 до 1000.
+	Quantity *int `json:"quantity,omitempty"`
+}
+
+// CreatePromoCodeRequest defines model for CreatePromoCodeRequest.
+type CreatePromoCodeRequest struct {
+	// Code Промокод: латинские буквы, цифры и дефис, от 3 до 50 символов; регистр не важен.
+	Code string `json:"code"`
+
+	// Items Предметы, на которые действует скидка; без них на все.
+	Items *[]string `json:"items,omitempty"`
+
+	// Kind Вид скидки: процент или фиксированное число монет.
+	Kind PromoKind `json:"kind"`
+
+	// MaxPerUser Сколько раз промокод может применить один пользователь; без него не ограничено.
+	MaxPerUser *int `json:"maxPerUser,omitempty"`
+
+	// MaxRedemptions Сколько раз промокод можно применить всего; без него не ограничено.
+	MaxRedemptions *int `json:"maxRedemptions,omitempty"`
+
+	// ValidFrom Начало действия.
+	ValidFrom *time.Time `json:"validFrom,omitempty"`
+
+	// ValidUntil Окончание действия.
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+
+	// Value Процент от 1 до 100 или число монет.
+	Value int `json:"value"`
+}
+
 // ErrorResponse defines model for ErrorResponse.
 type ErrorResponse struct {
 	// Errors Сообщение об ошибке, описывающее проблему.
 	Errors *string `json:"errors,omitempty"`
 }
 
+// GiftEntry defines model for GiftEntry.
+type GiftEntry struct {
+	// CreatedAt Время подарка.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// FromUser Имя отправителя.
+	FromUser string `json:"fromUser"`
+
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Message Сообщение получателю.
+	Message *string `json:"message,omitempty"`
+
+	// Quantity Количество.
+	Quantity int `json:"quantity"`
+
+	// ToUser Имя получателя.
+	ToUser string `json:"toUser"`
+}
+
+// GiftHistory defines model for GiftHistory.
+type GiftHistory struct {
+	Received *[]GiftEntry `json:"received,omitempty"`
+	Sent     *[]GiftEntry `json:"sent,omitempty"`
+}
+
+// GiftRequest defines model for GiftRequest.
+type GiftRequest struct {
+	// FromInventory Передать предмет из своего инвентаря вместо покупки.
+	FromInventory *bool `json:"fromInventory,omitempty"`
+
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Message Сообщение получателю, до 200 символов.
+	Message *string `json:"message,omitempty"`
+
+	// Quantity Количество, от 1 до 1000.
+	Quantity *int `json:"quantity,omitempty"`
+
+	// ToUser Имя пользователя, которому дарится предмет.
+	ToUser string `json:"toUser"`
+}
+
+// GiftResponse defines model for GiftResponse.
+type GiftResponse struct {
+	// CreatedAt Время подарка.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Id Номер подарка.
+	Id int `json:"id"`
+
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Message Сообщение получателю.
+	Message *string `json:"message,omitempty"`
+
+	// OrderId Номер заказа, если предмет куплен.
+	OrderId *int `json:"orderId,omitempty"`
+
+	// Quantity Количество.
+	Quantity int `json:"quantity"`
+
+	// ToUser Имя получателя.
+	ToUser string `json:"toUser"`
+
+	// Total Сколько монет списано; 0 для подарка из инвентаря.
+	Total int `json:"total"`
+}
+
+// HistoryResponse defines model for HistoryResponse.
+type HistoryResponse struct {
+	// NextCursor Курсор следующей страницы; отсутствует на последней странице.
+	NextCursor   *string           `json:"nextCursor,omitempty"`
+	Transactions []CoinTransaction `json:"transactions"`
+}
+
 // InfoResponse defines model for InfoResponse.
 type InfoResponse struct {
+	// CoinHistory Последние 100 переводов каждого направления, от старых к новым.
 	CoinHistory *struct {
 		Received *[]struct {
 			// Amount Количество полученных монет.
@@ -56,8 +398,9 @@ type InfoResponse struct {
 	} `json:"coinHistory,omitempty"`
 
 	// Coins Количество доступных монет.
-	Coins     *int `json:"coins,omitempty"`
-	Inventory *[]struct {
+	Coins       *int         `json:"coins,omitempty"`
+	GiftHistory *GiftHistory `json:"giftHistory,omitempty"`
+	Inventory   *[]struct {
 		// Quantity Количество предметов.
 		Quantity *int `json:"quantity,omitempty"`
 
@@ -66,32 +409,471 @@ type InfoResponse struct {
 	} `json:"inventory,omitempty"`
 }
 
+// ItemsResponse defines model for ItemsResponse.
+type ItemsResponse struct {
+	Items []CatalogItem `json:"items"`
+}
+
+// Listing defines model for Listing.
+type Listing struct {
+	// ClosedAt Время продажи или отмены.
+	ClosedAt *time.Time `json:"closedAt,omitempty"`
+
+	// CreatedAt Время создания.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Fee Комиссия маркетплейса с продажи.
+	Fee int `json:"fee"`
+
+	// Id Номер объявления.
+	Id int `json:"id"`
+
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Price Цена за все объявление.
+	Price int `json:"price"`
+
+	// Quantity Количество.
+	Quantity int `json:"quantity"`
+
+	// Seller Имя продавца.
+	Seller string `json:"seller"`
+
+	// Status Состояние объявления.
+	Status ListingStatus `json:"status"`
+}
+
+// ListingStatus Состояние объявления.
+type ListingStatus string
+
+// ListingsResponse defines model for ListingsResponse.
+type ListingsResponse struct {
+	Listings []Listing `json:"listings"`
+}
+
+// LogoutRequest defines model for LogoutRequest.
+type LogoutRequest struct {
+	// RefreshToken Refresh-токен текущей сессии, который нужно отозвать.
+	RefreshToken *string `json:"refreshToken,omitempty"`
+}
+
+// Order defines model for Order.
+type Order struct {
+	// CreatedAt Время покупки.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Discount Скидка по промокоду.
+	Discount int `json:"discount"`
+
+	// Id Номер заказа.
+	Id    int           `json:"id"`
+	Lines []ReceiptLine `json:"lines"`
+
+	// PromoCode Примененный промокод.
+	PromoCode *string `json:"promoCode,omitempty"`
+
+	// Refunds Возвраты по заказу; только в чеке заказа.
+	Refunds *[]Refund `json:"refunds,omitempty"`
+
+	// Total Сколько монет списано.
+	Total int `json:"total"`
+}
+
+// OrdersResponse defines model for OrdersResponse.
+type OrdersResponse struct {
+	Orders []Order `json:"orders"`
+}
+
+// PriceChange defines model for PriceChange.
+type PriceChange struct {
+	// Action Тип изменения: created, updated, deactivated или restored.
+	Action string `json:"action"`
+
+	// ChangedAt Время изменения.
+	ChangedAt time.Time `json:"changedAt"`
+
+	// ChangedBy Идентификатор администратора, внесшего изменение.
+	ChangedBy *int `json:"changedBy,omitempty"`
+
+	// NewPrice Цена после изменения.
+	NewPrice int `json:"newPrice"`
+
+	// OldPrice Цена до изменения; отсутствует для created.
+	OldPrice *int `json:"oldPrice,omitempty"`
+}
+
+// PriceHistoryResponse defines model for PriceHistoryResponse.
+type PriceHistoryResponse struct {
+	History []PriceChange `json:"history"`
+}
+
+// PromoCode defines model for PromoCode.
+type PromoCode struct {
+	// Code Промокод: латинские буквы, цифры и дефис, от 3 до 50 символов; регистр не важен.
+	Code string `json:"code"`
+
+	// CreatedAt Время создания.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Items Предметы, на которые действует скидка; без них на все.
+	Items *[]string `json:"items,omitempty"`
+
+	// Kind Вид скидки: процент или фиксированное число монет.
+	Kind PromoKind `json:"kind"`
+
+	// MaxPerUser Сколько раз промокод может применить один пользователь; без него не ограничено.
+	MaxPerUser *int `json:"maxPerUser,omitempty"`
+
+	// MaxRedemptions Сколько раз промокод можно применить всего; без него не ограничено.
+	MaxRedemptions *int `json:"maxRedemptions,omitempty"`
+
+	// Redemptions Сколько раз промокод применен.
+	Redemptions int `json:"redemptions"`
+
+	// ValidFrom Начало действия.
+	ValidFrom *time.Time `json:"validFrom,omitempty"`
+
+	// ValidUntil Окончание действия.
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+
+	// Value Процент от 1 до 100 или число монет.
+	Value int `json:"value"`
+}
+
+// PromoCodesResponse defines model for PromoCodesResponse.
+type PromoCodesResponse struct {
+	PromoCodes []PromoCode `json:"promoCodes"`
+}
+
+// PromoKind Вид скидки: процент или фиксированное число монет.
+type PromoKind string
+
+// Receipt defines model for Receipt.
+type Receipt struct {
+	// Balance Сколько монет осталось.
+	Balance int `json:"balance"`
+
+	// CreatedAt Время покупки.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Discount Скидка по промокоду.
+	Discount int           `json:"discount"`
+	Lines    []ReceiptLine `json:"lines"`
+
+	// OrderId Номер заказа.
+	OrderId int `json:"orderId"`
+
+	// PromoCode Примененный промокод.
+	PromoCode *string `json:"promoCode,omitempty"`
+
+	// Total Сколько монет списано.
+	Total int `json:"total"`
+}
+
+// ReceiptLine defines model for ReceiptLine.
+type ReceiptLine struct {
+	// Discount Скидка по промокоду на строку.
+	Discount *int `json:"discount,omitempty"`
+
+	// Item Идентификатор предмета.
+	Item string `json:"item"`
+
+	// Name Название предмета на момент покупки.
+	Name string `json:"name"`
+
+	// Price Цена за штуку.
+	Price int `json:"price"`
+
+	// Quantity Количество.
+	Quantity int `json:"quantity"`
+
+	// Total Стоимость строки.
+	Total int `json:"total"`
+}
+
+// RefreshRequest defines model for RefreshRequest.
+type RefreshRequest struct {
+	// RefreshToken Refresh-токен, полученный при аутентификации или предыдущем обновлении.
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Refund defines model for Refund.
+type Refund struct {
+	// CreatedAt Время возврата.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Id Номер возврата.
+	Id    int           `json:"id"`
+	Lines []ReceiptLine `json:"lines"`
+
+	// OrderId Номер заказа.
+	OrderId int `json:"orderId"`
+
+	// Total Сколько монет возвращено.
+	Total int `json:"total"`
+}
+
+// RefundRequest defines model for RefundRequest.
+type RefundRequest struct {
+	// Items Что вернуть; без строк возвращается все, что еще не возвращено.
+	Items *[]BuyLine `json:"items,omitempty"`
+}
+
 // SendCoinRequest defines model for SendCoinRequest.
 type SendCoinRequest struct {
 	// Amount Количество монет, которые необходимо отправить.
 	Amount int `json:"amount"`
 
+	// Note Комментарий к переводу, до 200 символов; необязательно.
+	Note *string `json:"note,omitempty"`
+
 	// ToUser Имя пользователя, которому нужно отправить монеты.
 	ToUser string `json:"toUser"`
 }
 
+// SetCartQuantityRequest defines model for SetCartQuantityRequest.
+type SetCartQuantityRequest struct {
+	// Quantity Новое количество, от 1 до 1000.
+	Quantity int `json:"quantity"`
+}
+
+// SetRoleRequest defines model for SetRoleRequest.
+type SetRoleRequest struct {
+	// Role Новая роль: employee, admin или auditor.
+	Role string `json:"role"`
+}
+
+// UpdateItemRequest defines model for UpdateItemRequest.
+type UpdateItemRequest struct {
+	// ClearMaxPerUser Снять ограничение на одного пользователя.
+	ClearMaxPerUser *bool `json:"clearMaxPerUser,omitempty"`
+
+	// ClearStock Снять ограничение количества в наличии.
+	ClearStock *bool `json:"clearStock,omitempty"`
+
+	// MaxPerUser Новое ограничение на одного пользователя, больше нуля.
+	MaxPerUser *int `json:"maxPerUser,omitempty"`
+
+	// Name Новое название предмета.
+	Name *string `json:"name,omitempty"`
+
+	// Price Новая цена в монетах, больше нуля.
+	Price *int `json:"price,omitempty"`
+
+	// Stock Новое количество в наличии, не меньше нуля.
+	Stock *int `json:"stock,omitempty"`
+}
+
+// GetApiHistoryParams defines parameters for GetApiHistory.
+type GetApiHistoryParams struct {
+	// Direction Показать только отправленные (sent) или полученные (received) переводы.
+	Direction *string `form:"direction,omitempty" json:"direction,omitempty"`
+
+	// Counterparty Показать только переводы с этим пользователем.
+	Counterparty *string `form:"counterparty,omitempty" json:"counterparty,omitempty"`
+
+	// MinAmount Показать только переводы не меньше этой суммы.
+	MinAmount *int `form:"minAmount,omitempty" json:"minAmount,omitempty"`
+
+	// MaxAmount Показать только переводы не больше этой суммы.
+	MaxAmount *int `form:"maxAmount,omitempty" json:"maxAmount,omitempty"`
+
+	// From Показать только переводы, сделанные начиная с этого момента.
+	From *time.Time `form:"from,omitempty" json:"from,omitempty"`
+
+	// To Показать только переводы, сделанные до этого момента.
+	To *time.Time `form:"to,omitempty" json:"to,omitempty"`
+
+	// Limit Сколько переводов вернуть, по умолчанию 20.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor Курсор nextCursor из предыдущей страницы.
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+}
+
+// GetApiItemsParams defines parameters for GetApiItems.
+type GetApiItemsParams struct {
+	// MaxPrice Показать только предметы не дороже указанной цены.
+	MaxPrice *int `form:"maxPrice,omitempty" json:"maxPrice,omitempty"`
+
+	// Sort Порядок сортировки: name (по умолчанию), price или -price.
+	Sort *string `form:"sort,omitempty" json:"sort,omitempty"`
+}
+
+// GetApiMarketListingsParams defines parameters for GetApiMarketListings.
+type GetApiMarketListingsParams struct {
+	// Item Показать только объявления этого предмета.
+	Item *string `form:"item,omitempty" json:"item,omitempty"`
+
+	// Limit Сколько объявлений вернуть, по умолчанию 20.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Offset Сколько объявлений пропустить.
+	Offset *int `form:"offset,omitempty" json:"offset,omitempty"`
+}
+
+// GetApiOrdersParams defines parameters for GetApiOrders.
+type GetApiOrdersParams struct {
+	// Limit Сколько заказов вернуть, по умолчанию 20.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Offset Сколько заказов пропустить.
+	Offset *int `form:"offset,omitempty" json:"offset,omitempty"`
+}
+
+// PostApiAdminItemsJSONRequestBody defines body for PostApiAdminItems for application/json ContentType.
+type PostApiAdminItemsJSONRequestBody = CreateItemRequest
+
+// PatchApiAdminItemsSlugJSONRequestBody defines body for PatchApiAdminItemsSlug for application/json ContentType.
+type PatchApiAdminItemsSlugJSONRequestBody = UpdateItemRequest
+
+// PostApiAdminOrdersOrderIdRefundJSONRequestBody defines body for PostApiAdminOrdersOrderIdRefund for application/json ContentType.
+type PostApiAdminOrdersOrderIdRefundJSONRequestBody = RefundRequest
+
+// PostApiAdminPromoCodesJSONRequestBody defines body for PostApiAdminPromoCodes for application/json ContentType.
+type PostApiAdminPromoCodesJSONRequestBody = CreatePromoCodeRequest
+
+// PutApiAdminUsersUserIdRoleJSONRequestBody defines body for PutApiAdminUsersUserIdRole for application/json ContentType.
+type PutApiAdminUsersUserIdRoleJSONRequestBody = SetRoleRequest
+
 // PostApiAuthJSONRequestBody defines body for PostApiAuth for application/json ContentType.
 type PostApiAuthJSONRequestBody = AuthRequest
 
+// PostApiAuthLogoutJSONRequestBody defines body for PostApiAuthLogout for application/json ContentType.
+type PostApiAuthLogoutJSONRequestBody = LogoutRequest
+
+// PostApiAuthRefreshJSONRequestBody defines body for PostApiAuthRefresh for application/json ContentType.
+type PostApiAuthRefreshJSONRequestBody = RefreshRequest
+
+// PostApiBuyJSONRequestBody defines body for PostApiBuy for application/json ContentType.
+type PostApiBuyJSONRequestBody = BuyRequest
+
+// PostApiCartCheckoutJSONRequestBody defines body for PostApiCartCheckout for application/json ContentType.
+type PostApiCartCheckoutJSONRequestBody = CheckoutRequest
+
+// PostApiCartItemsJSONRequestBody defines body for PostApiCartItems for application/json ContentType.
+type PostApiCartItemsJSONRequestBody = AddCartItemRequest
+
+// PutApiCartItemsItemJSONRequestBody defines body for PutApiCartItemsItem for application/json ContentType.
+type PutApiCartItemsItemJSONRequestBody = SetCartQuantityRequest
+
+// PostApiGiftJSONRequestBody defines body for PostApiGift for application/json ContentType.
+type PostApiGiftJSONRequestBody = GiftRequest
+
+// PostApiMarketListingsJSONRequestBody defines body for PostApiMarketListings for application/json ContentType.
+type PostApiMarketListingsJSONRequestBody = CreateListingRequest
+
+// PostApiOrdersOrderIdRefundJSONRequestBody defines body for PostApiOrdersOrderIdRefund for application/json ContentType.
+type PostApiOrdersOrderIdRefundJSONRequestBody = RefundRequest
+
 // PostApiSendCoinJSONRequestBody defines body for PostApiSendCoin for application/json ContentType.
 type PostApiSendCoinJSONRequestBody = SendCoinRequest
 
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// Получить все предметы каталога.
+	// (GET /api/admin/items)
+	GetApiAdminItems(ctx echo.Context) error
+	// Добавить предмет в каталог.
+	// (POST /api/admin/items)
+	PostApiAdminItems(ctx echo.Context) error
+	// Изменить название или цену предмета.
+	// (PATCH /api/admin/items/{slug})
+	PatchApiAdminItemsSlug(ctx echo.Context, slug string) error
+	// Снять предмет с продажи.
+	// (POST /api/admin/items/{slug}/deactivate)
+	PostApiAdminItemsSlugDeactivate(ctx echo.Context, slug string) error
+	// Получить историю изменений предмета.
+	// (GET /api/admin/items/{slug}/history)
+	GetApiAdminItemsSlugHistory(ctx echo.Context, slug string) error
+	// Вернуть предмет в продажу.
+	// (POST /api/admin/items/{slug}/restore)
+	PostApiAdminItemsSlugRestore(ctx echo.Context, slug string) error
+	// Вернуть покупку любого пользователя.
+	// (POST /api/admin/orders/{orderId}/refund)
+	PostApiAdminOrdersOrderIdRefund(ctx echo.Context, orderId int) error
+	// Получить все промокоды.
+	// (GET /api/admin/promo-codes)
+	GetApiAdminPromoCodes(ctx echo.Context) error
+	// Создать промокод.
+	// (POST /api/admin/promo-codes)
+	PostApiAdminPromoCodes(ctx echo.Context) error
+	// Получить данные пользователя.
+	// (GET /api/admin/users/{userId})
+	GetApiAdminUsersUserId(ctx echo.Context, userId int) error
+	// Отозвать все сессии пользователя.
+	// (POST /api/admin/users/{userId}/revoke-sessions)
+	PostApiAdminUsersUserIdRevokeSessions(ctx echo.Context, userId int) error
+	// Назначить роль пользователю.
+	// (PUT /api/admin/users/{userId}/role)
+	PutApiAdminUsersUserIdRole(ctx echo.Context, userId int) error
+	// Снять блокировку входа пользователя.
+	// (POST /api/admin/users/{userId}/unlock)
+	PostApiAdminUsersUserIdUnlock(ctx echo.Context, userId int) error
 	// Аутентификация и получение JWT-токена.
 	// (POST /api/auth)
 	PostApiAuth(ctx echo.Context) error
+	// Выйти из системы и отозвать токены.
+	// (POST /api/auth/logout)
+	PostApiAuthLogout(ctx echo.Context) error
+	// Обновить пару токенов по refresh-токену.
+	// (POST /api/auth/refresh)
+	PostApiAuthRefresh(ctx echo.Context) error
+	// Купить несколько предметов за монеты.
+	// (POST /api/buy)
+	PostApiBuy(ctx echo.Context) error
 	// Купить предмет за монеты.
 	// (GET /api/buy/{item})
 	GetApiBuyItem(ctx echo.Context, item string) error
+	// Получить корзину с текущими ценами.
+	// (GET /api/cart)
+	GetApiCart(ctx echo.Context) error
+	// Оформить корзину.
+	// (POST /api/cart/checkout)
+	PostApiCartCheckout(ctx echo.Context) error
+	// Добавить предмет в корзину.
+	// (POST /api/cart/items)
+	PostApiCartItems(ctx echo.Context) error
+	// Убрать предмет из корзины.
+	// (DELETE /api/cart/items/{item})
+	DeleteApiCartItemsItem(ctx echo.Context, item string) error
+	// Задать количество предмета в корзине.
+	// (PUT /api/cart/items/{item})
+	PutApiCartItemsItem(ctx echo.Context, item string) error
+	// Подарить предмет другому пользователю.
+	// (POST /api/gift)
+	PostApiGift(ctx echo.Context) error
+	// Получить историю переводов монет.
+	// (GET /api/history)
+	GetApiHistory(ctx echo.Context, params GetApiHistoryParams) error
 	// Получить информацию о монетах, инвентаре и истории транзакций.
 	// (GET /api/info)
 	GetApiInfo(ctx echo.Context) error
+	// Получить каталог мерча.
+	// (GET /api/items)
+	GetApiItems(ctx echo.Context, params GetApiItemsParams) error
+	// Получить открытые объявления маркетплейса.
+	// (GET /api/market/listings)
+	GetApiMarketListings(ctx echo.Context, params GetApiMarketListingsParams) error
+	// Выставить предмет из инвентаря на продажу.
+	// (POST /api/market/listings)
+	PostApiMarketListings(ctx echo.Context) error
+	// Купить объявление.
+	// (POST /api/market/listings/{listingId}/buy)
+	PostApiMarketListingsListingIdBuy(ctx echo.Context, listingId int) error
+	// Отменить свое объявление.
+	// (POST /api/market/listings/{listingId}/cancel)
+	PostApiMarketListingsListingIdCancel(ctx echo.Context, listingId int) error
+	// Получить свои заказы.
+	// (GET /api/orders)
+	GetApiOrders(ctx echo.Context, params GetApiOrdersParams) error
+	// Получить чек заказа.
+	// (GET /api/orders/{orderId})
+	GetApiOrdersOrderId(ctx echo.Context, orderId int) error
+	// Вернуть купленные предметы.
+	// (POST /api/orders/{orderId}/refund)
+	PostApiOrdersOrderIdRefund(ctx echo.Context, orderId int) error
 	// Отправить монеты другому пользователю.
 	// (POST /api/sendCoin)
 	PostApiSendCoin(ctx echo.Context) error
@@ -102,35 +884,421 @@ type ServerInterfaceWrapper struct {
 	Handler ServerInterface
 }
 
-// PostApiAuth converts echo context to params.
-func (w *ServerInterfaceWrapper) PostApiAuth(ctx echo.Context) error {
+// GetApiAdminItems converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiAdminItems(ctx echo.Context) error {
 	var err error
 
+	ctx.Set(BearerAuthScopes, []string{})
+
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.PostApiAuth(ctx)
+	err = w.Handler.GetApiAdminItems(ctx)
 	return err
 }
 
-// GetApiBuyItem converts echo context to params.
-func (w *ServerInterfaceWrapper) GetApiBuyItem(ctx echo.Context) error {
+// PostApiAdminItems converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAdminItems(ctx echo.Context) error {
 	var err error
-	// ------------- Path parameter "item" -------------
-	var item string
-
-	err = runtime.BindStyledParameterWithOptions("simple", "item", ctx.Param("item"), &item, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter item: %s", err))
-	}
 
 	ctx.Set(BearerAuthScopes, []string{})
 
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.GetApiBuyItem(ctx, item)
+	err = w.Handler.PostApiAdminItems(ctx)
 	return err
 }
 
-// GetApiInfo converts echo context to params.
-func (w *ServerInterfaceWrapper) GetApiInfo(ctx echo.Context) error {
+// PatchApiAdminItemsSlug converts echo context to params.
+func (w *ServerInterfaceWrapper) PatchApiAdminItemsSlug(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "slug" -------------
+	var slug string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "slug", ctx.Param("slug"), &slug, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter slug: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PatchApiAdminItemsSlug(ctx, slug)
+	return err
+}
+
+// PostApiAdminItemsSlugDeactivate converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAdminItemsSlugDeactivate(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "slug" -------------
+	var slug string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "slug", ctx.Param("slug"), &slug, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter slug: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAdminItemsSlugDeactivate(ctx, slug)
+	return err
+}
+
+// GetApiAdminItemsSlugHistory converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiAdminItemsSlugHistory(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "slug" -------------
+	var slug string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "slug", ctx.Param("slug"), &slug, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter slug: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiAdminItemsSlugHistory(ctx, slug)
+	return err
+}
+
+// PostApiAdminItemsSlugRestore converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAdminItemsSlugRestore(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "slug" -------------
+	var slug string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "slug", ctx.Param("slug"), &slug, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter slug: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAdminItemsSlugRestore(ctx, slug)
+	return err
+}
+
+// PostApiAdminOrdersOrderIdRefund converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAdminOrdersOrderIdRefund(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "orderId" -------------
+	var orderId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "orderId", ctx.Param("orderId"), &orderId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter orderId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAdminOrdersOrderIdRefund(ctx, orderId)
+	return err
+}
+
+// GetApiAdminPromoCodes converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiAdminPromoCodes(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiAdminPromoCodes(ctx)
+	return err
+}
+
+// PostApiAdminPromoCodes converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAdminPromoCodes(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAdminPromoCodes(ctx)
+	return err
+}
+
+// GetApiAdminUsersUserId converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiAdminUsersUserId(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "userId" -------------
+	var userId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "userId", ctx.Param("userId"), &userId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter userId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiAdminUsersUserId(ctx, userId)
+	return err
+}
+
+// PostApiAdminUsersUserIdRevokeSessions converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAdminUsersUserIdRevokeSessions(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "userId" -------------
+	var userId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "userId", ctx.Param("userId"), &userId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter userId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAdminUsersUserIdRevokeSessions(ctx, userId)
+	return err
+}
+
+// PutApiAdminUsersUserIdRole converts echo context to params.
+func (w *ServerInterfaceWrapper) PutApiAdminUsersUserIdRole(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "userId" -------------
+	var userId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "userId", ctx.Param("userId"), &userId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter userId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PutApiAdminUsersUserIdRole(ctx, userId)
+	return err
+}
+
+// PostApiAdminUsersUserIdUnlock converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAdminUsersUserIdUnlock(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "userId" -------------
+	var userId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "userId", ctx.Param("userId"), &userId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter userId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAdminUsersUserIdUnlock(ctx, userId)
+	return err
+}
+
+// PostApiAuth converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAuth(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAuth(ctx)
+	return err
+}
+
+// PostApiAuthLogout converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAuthLogout(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAuthLogout(ctx)
+	return err
+}
+
+// PostApiAuthRefresh converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiAuthRefresh(ctx echo.Context) error {
+	var err error
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiAuthRefresh(ctx)
+	return err
+}
+
+// PostApiBuy converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiBuy(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiBuy(ctx)
+	return err
+}
+
+// GetApiBuyItem converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiBuyItem(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "item" -------------
+	var item string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "item", ctx.Param("item"), &item, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter item: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiBuyItem(ctx, item)
+	return err
+}
+
+// GetApiCart converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiCart(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiCart(ctx)
+	return err
+}
+
+// PostApiCartCheckout converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiCartCheckout(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiCartCheckout(ctx)
+	return err
+}
+
+// PostApiCartItems converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiCartItems(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiCartItems(ctx)
+	return err
+}
+
+// DeleteApiCartItemsItem converts echo context to params.
+func (w *ServerInterfaceWrapper) DeleteApiCartItemsItem(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "item" -------------
+	var item string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "item", ctx.Param("item"), &item, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter item: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.DeleteApiCartItemsItem(ctx, item)
+	return err
+}
+
+// PutApiCartItemsItem converts echo context to params.
+func (w *ServerInterfaceWrapper) PutApiCartItemsItem(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "item" -------------
+	var item string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "item", ctx.Param("item"), &item, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter item: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PutApiCartItemsItem(ctx, item)
+	return err
+}
+
+// PostApiGift converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiGift(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiGift(ctx)
+	return err
+}
+
+// GetApiHistory converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiHistory(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetApiHistoryParams
+	// ------------- Optional query parameter "direction" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "direction", ctx.QueryParams(), &params.Direction)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter direction: %s", err))
+	}
+
+	// ------------- Optional query parameter "counterparty" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "counterparty", ctx.QueryParams(), &params.Counterparty)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter counterparty: %s", err))
+	}
+
+	// ------------- Optional query parameter "minAmount" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "minAmount", ctx.QueryParams(), &params.MinAmount)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter minAmount: %s", err))
+	}
+
+	// ------------- Optional query parameter "maxAmount" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "maxAmount", ctx.QueryParams(), &params.MaxAmount)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter maxAmount: %s", err))
+	}
+
+	// ------------- Optional query parameter "from" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "from", ctx.QueryParams(), &params.From)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter from: %s", err))
+	}
+
+	// ------------- Optional query parameter "to" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "to", ctx.QueryParams(), &params.To)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter to: %s", err))
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", ctx.QueryParams(), &params.Cursor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter cursor: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiHistory(ctx, params)
+	return err
+}
+
+// GetApiInfo converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiInfo(ctx echo.Context) error {
 	var err error
 
 	ctx.Set(BearerAuthScopes, []string{})
@@ -140,6 +1308,177 @@ func (w *ServerInterfaceWrapper) GetApiInfo(ctx echo.Context) error {
 	return err
 }
 
+// GetApiItems converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiItems(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetApiItemsParams
+	// ------------- Optional query parameter "maxPrice" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "maxPrice", ctx.QueryParams(), &params.MaxPrice)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter maxPrice: %s", err))
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", ctx.QueryParams(), &params.Sort)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sort: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiItems(ctx, params)
+	return err
+}
+
+// GetApiMarketListings converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiMarketListings(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetApiMarketListingsParams
+	// ------------- Optional query parameter "item" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "item", ctx.QueryParams(), &params.Item)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter item: %s", err))
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "offset" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "offset", ctx.QueryParams(), &params.Offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter offset: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiMarketListings(ctx, params)
+	return err
+}
+
+// PostApiMarketListings converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiMarketListings(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiMarketListings(ctx)
+	return err
+}
+
+// PostApiMarketListingsListingIdBuy converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiMarketListingsListingIdBuy(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "listingId" -------------
+	var listingId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "listingId", ctx.Param("listingId"), &listingId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter listingId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiMarketListingsListingIdBuy(ctx, listingId)
+	return err
+}
+
+// PostApiMarketListingsListingIdCancel converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiMarketListingsListingIdCancel(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "listingId" -------------
+	var listingId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "listingId", ctx.Param("listingId"), &listingId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter listingId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiMarketListingsListingIdCancel(ctx, listingId)
+	return err
+}
+
+// GetApiOrders converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiOrders(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetApiOrdersParams
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "offset" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "offset", ctx.QueryParams(), &params.Offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter offset: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiOrders(ctx, params)
+	return err
+}
+
+// GetApiOrdersOrderId converts echo context to params.
+func (w *ServerInterfaceWrapper) GetApiOrdersOrderId(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "orderId" -------------
+	var orderId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "orderId", ctx.Param("orderId"), &orderId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter orderId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetApiOrdersOrderId(ctx, orderId)
+	return err
+}
+
+// PostApiOrdersOrderIdRefund converts echo context to params.
+func (w *ServerInterfaceWrapper) PostApiOrdersOrderIdRefund(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "orderId" -------------
+	var orderId int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "orderId", ctx.Param("orderId"), &orderId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter orderId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostApiOrdersOrderIdRefund(ctx, orderId)
+	return err
+}
+
 // PostApiSendCoin converts echo context to params.
 func (w *ServerInterfaceWrapper) PostApiSendCoin(ctx echo.Context) error {
 	var err error
@@ -179,9 +1518,40 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 		Handler: si,
 	}
 
+	router.GET(baseURL+"/api/admin/items", wrapper.GetApiAdminItems)
+	router.POST(baseURL+"/api/admin/items", wrapper.PostApiAdminItems)
+	router.PATCH(baseURL+"/api/admin/items/:slug", wrapper.PatchApiAdminItemsSlug)
+	router.POST(baseURL+"/api/admin/items/:slug/deactivate", wrapper.PostApiAdminItemsSlugDeactivate)
+	router.GET(baseURL+"/api/admin/items/:slug/history", wrapper.GetApiAdminItemsSlugHistory)
+	router.POST(baseURL+"/api/admin/items/:slug/restore", wrapper.PostApiAdminItemsSlugRestore)
+	router.POST(baseURL+"/api/admin/orders/:orderId/refund", wrapper.PostApiAdminOrdersOrderIdRefund)
+	router.GET(baseURL+"/api/admin/promo-codes", wrapper.GetApiAdminPromoCodes)
+	router.POST(baseURL+"/api/admin/promo-codes", wrapper.PostApiAdminPromoCodes)
+	router.GET(baseURL+"/api/admin/users/:userId", wrapper.GetApiAdminUsersUserId)
+	router.POST(baseURL+"/api/admin/users/:userId/revoke-sessions", wrapper.PostApiAdminUsersUserIdRevokeSessions)
+	router.PUT(baseURL+"/api/admin/users/:userId/role", wrapper.PutApiAdminUsersUserIdRole)
+	router.POST(baseURL+"/api/admin/users/:userId/unlock", wrapper.PostApiAdminUsersUserIdUnlock)
 	router.POST(baseURL+"/api/auth", wrapper.PostApiAuth)
+	router.POST(baseURL+"/api/auth/logout", wrapper.PostApiAuthLogout)
+	router.POST(baseURL+"/api/auth/refresh", wrapper.PostApiAuthRefresh)
+	router.POST(baseURL+"/api/buy", wrapper.PostApiBuy)
 	router.GET(baseURL+"/api/buy/:item", wrapper.GetApiBuyItem)
+	router.GET(baseURL+"/api/cart", wrapper.GetApiCart)
+	router.POST(baseURL+"/api/cart/checkout", wrapper.PostApiCartCheckout)
+	router.POST(baseURL+"/api/cart/items", wrapper.PostApiCartItems)
+	router.DELETE(baseURL+"/api/cart/items/:item", wrapper.DeleteApiCartItemsItem)
+	router.PUT(baseURL+"/api/cart/items/:item", wrapper.PutApiCartItemsItem)
+	router.POST(baseURL+"/api/gift", wrapper.PostApiGift)
+	router.GET(baseURL+"/api/history", wrapper.GetApiHistory)
 	router.GET(baseURL+"/api/info", wrapper.GetApiInfo)
+	router.GET(baseURL+"/api/items", wrapper.GetApiItems)
+	router.GET(baseURL+"/api/market/listings", wrapper.GetApiMarketListings)
+	router.POST(baseURL+"/api/market/listings", wrapper.PostApiMarketListings)
+	router.POST(baseURL+"/api/market/listings/:listingId/buy", wrapper.PostApiMarketListingsListingIdBuy)
+	router.POST(baseURL+"/api/market/listings/:listingId/cancel", wrapper.PostApiMarketListingsListingIdCancel)
+	router.GET(baseURL+"/api/orders", wrapper.GetApiOrders)
+	router.GET(baseURL+"/api/orders/:orderId", wrapper.GetApiOrdersOrderId)
+	router.POST(baseURL+"/api/orders/:orderId/refund", wrapper.PostApiOrdersOrderIdRefund)
 	router.POST(baseURL+"/api/sendCoin", wrapper.PostApiSendCoin)
 
 }
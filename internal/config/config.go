@@ -1,7 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -11,7 +16,52 @@ type Config struct {
 	DatabasePassword string
 	DatabaseName     string
 	ServerPort       string
-	JWTSecret        string
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+
+	// JWTSigningKeyFile is a PEM encoded RSA or Ed25519 private key. The
+	// server refuses to start without it unless JWTEphemeralKey is set.
+	JWTSigningKeyFile string
+	// JWTEphemeralKey generates a signing key on startup instead. It is for
+	// development only: tokens do not survive a restart and are refused by
+	// other instances.
+	JWTEphemeralKey bool
+	// JWTVerificationKeyFiles are keys of previous signing keys that are still
+	// accepted during a rotation.
+	JWTVerificationKeyFiles []string
+	// JWTIssuer and JWTAudience are put into issued tokens and required on
+	// incoming ones.
+	JWTIssuer   string
+	JWTAudience string
+	// JWTLeeway is the clock skew tolerated when checking exp, iat and nbf.
+	JWTLeeway time.Duration
+
+	// RevocationCacheTTL bounds how long a revocation made by another
+	// instance can go unnoticed.
+	RevocationCacheTTL time.Duration
+	// RevokedTokensPruneInterval is how often revocations of expired tokens
+	// are deleted.
+	RevokedTokensPruneInterval time.Duration
+
+	// LoginMaxFailures and LoginMaxFailuresPerIP lock a username or a client IP
+	// out for LoginLockout. Before that, failed logins back off exponentially
+	// starting at LoginBackoffBase.
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginBackoffBase      time.Duration
+	LoginLockout          time.Duration
+
+	// Argon2id cost parameters used for password hashing.
+	PasswordHashTime      uint32
+	PasswordHashMemoryKiB uint32
+	PasswordHashThreads   uint8
+
+	// RefundWindow is how long after a purchase users may refund it.
+	RefundWindow time.Duration
+
+	// MarketFeePercent of every marketplace sale is kept from the seller,
+	// 0 to 100.
+	MarketFeePercent int
 }
 
 func LoadConfig() (*Config, error) {
@@ -22,7 +72,51 @@ func LoadConfig() (*Config, error) {
 		DatabasePassword: getEnv("DATABASE_PASSWORD", "password"),
 		DatabaseName:     getEnv("DATABASE_NAME", "shop"),
 		ServerPort:       getEnv("SERVER_PORT", "8080"),
-		JWTSecret:        getEnv("JWT_SECRET", "secret"),
+		AccessTokenTTL:   getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:  getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		JWTSigningKeyFile:       getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTEphemeralKey:         getEnvBool("JWT_EPHEMERAL_KEY", false),
+		JWTVerificationKeyFiles: getEnvList("JWT_VERIFICATION_KEY_FILES"),
+		JWTIssuer:               getEnv("JWT_ISSUER", "avito-shop"),
+		JWTAudience:             getEnv("JWT_AUDIENCE", "avito-shop"),
+		JWTLeeway:               getEnvDuration("JWT_LEEWAY", 30*time.Second),
+
+		RevocationCacheTTL:         getEnvDuration("REVOCATION_CACHE_TTL", 30*time.Second),
+		RevokedTokensPruneInterval: getEnvDuration("REVOKED_TOKENS_PRUNE_INTERVAL", time.Hour),
+
+		LoginMaxFailures:      getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginMaxFailuresPerIP: getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 50),
+		LoginBackoffBase:      getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginLockout:          getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+
+		RefundWindow: getEnvDuration("REFUND_WINDOW", 14*24*time.Hour),
+
+		MarketFeePercent: getEnvInt("MARKET_FEE_PERCENT", 5),
+	}
+	// argon2 panics on zero rounds or threads, on every login
+	hashTime := getEnvInt("PASSWORD_HASH_TIME", 1)
+	hashMemory := getEnvInt("PASSWORD_HASH_MEMORY_KIB", 64*1024)
+	hashThreads := getEnvInt("PASSWORD_HASH_THREADS", 4)
+	if hashTime < 1 || int64(hashTime) > math.MaxUint32 {
+		return nil, fmt.Errorf("PASSWORD_HASH_TIME must be at least 1, got %d", hashTime)
+	}
+	if hashThreads < 1 || hashThreads > math.MaxUint8 {
+		return nil, fmt.Errorf("PASSWORD_HASH_THREADS must be 1 to 255, got %d", hashThreads)
+	}
+	if hashMemory < 8*hashThreads || int64(hashMemory) > math.MaxUint32 {
+		return nil, fmt.Errorf("PASSWORD_HASH_MEMORY_KIB must be at least 8 per thread (%d), got %d", 8*hashThreads, hashMemory)
+	}
+	cfg.PasswordHashTime = uint32(hashTime)
+	cfg.PasswordHashMemoryKiB = uint32(hashMemory)
+	cfg.PasswordHashThreads = uint8(hashThreads)
+
+	if cfg.RevokedTokensPruneInterval <= 0 {
+		return nil, fmt.Errorf("REVOKED_TOKENS_PRUNE_INTERVAL must be positive, got %s", cfg.RevokedTokensPruneInterval)
+	}
+	// a fee out of range would mint or destroy coins in every sale
+	if cfg.MarketFeePercent < 0 || cfg.MarketFeePercent > 100 {
+		return nil, fmt.Errorf("MARKET_FEE_PERCENT must be 0 to 100, got %d", cfg.MarketFeePercent)
 	}
 	return cfg, nil
 }
@@ -33,3 +127,49 @@ func getEnv(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+func getEnvInt(key string, defaultVal int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultVal
+	}
+	return n
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultVal
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultVal
+	}
+	return b
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultVal
+	}
+	return d
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,62 @@
+package config
+
+import "testing"
+
+func TestLoadConfig_MarketFeePercent(t *testing.T) {
+	cases := []struct {
+		value string
+		want  int
+		valid bool
+	}{
+		{"0", 0, true},
+		{"5", 5, true},
+		{"100", 100, true},
+		{"-1", 0, false},
+		{"101", 0, false},
+	}
+	for _, tc := range cases {
+		t.Setenv("MARKET_FEE_PERCENT", tc.value)
+		cfg, err := LoadConfig()
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("%s: expected the fee to be refused", tc.value)
+			}
+			continue
+		}
+		if err != nil || cfg.MarketFeePercent != tc.want {
+			t.Errorf("%s: expected a fee of %d, got %v", tc.value, tc.want, err)
+		}
+	}
+}
+
+func TestLoadConfig_PasswordHashParams(t *testing.T) {
+	cases := []struct {
+		time, memory, threads string
+		valid                 bool
+	}{
+		{"1", "65536", "4", true},
+		{"3", "32", "4", true},
+		{"0", "65536", "4", false},
+		{"1", "65536", "0", false},
+		{"1", "65536", "256", false},
+		{"1", "31", "4", false},
+	}
+	for _, tc := range cases {
+		t.Setenv("PASSWORD_HASH_TIME", tc.time)
+		t.Setenv("PASSWORD_HASH_MEMORY_KIB", tc.memory)
+		t.Setenv("PASSWORD_HASH_THREADS", tc.threads)
+		cfg, err := LoadConfig()
+		name := "t=" + tc.time + ",m=" + tc.memory + ",p=" + tc.threads
+		if !tc.valid {
+			if err == nil {
+				t.Errorf("%s: expected the parameters to be refused", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if cfg.PasswordHashThreads == 0 || cfg.PasswordHashTime == 0 {
+			t.Errorf("%s: unexpected parameters %+v", name, cfg)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type cartDBImplementation struct {
+	db *sql.DB
+}
+
+func NewCartDB(dbConn *sql.DB) CartDB {
+	return &cartDBImplementation{
+		db: dbConn,
+	}
+}
+
+const cartQuery = `
+SELECT cl.quantity, cl.price, cl.added_at,
+       i.id, i.slug, i.name, i.price, i.stock, i.max_per_user, i.active, i.created_at, i.updated_at
+FROM cart_lines cl
+JOIN items i ON i.id = cl.item_id
+WHERE cl.user_id=$1
+ORDER BY i.slug
+`
+
+func (c *cartDBImplementation) GetCart(userID int) ([]CartLine, error) {
+	rows, err := c.db.Query(cartQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart of user %d: %w", userID, err)
+	}
+	return scanCartLines(rows)
+}
+
+func (c *cartDBImplementation) GetCartForUpdate(tx *sql.Tx, userID int) ([]CartLine, error) {
+	rows, err := tx.Query(cartQuery+"FOR UPDATE OF cl", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart of user %d for update: %w", userID, err)
+	}
+	return scanCartLines(rows)
+}
+
+func scanCartLines(rows *sql.Rows) ([]CartLine, error) {
+	defer rows.Close()
+
+	var lines []CartLine
+	for rows.Next() {
+		var l CartLine
+		it := &l.Item
+		if err := rows.Scan(&l.Quantity, &l.Price, &l.AddedAt,
+			&it.ID, &it.Slug, &it.Name, &it.Price, &it.Stock, &it.MaxPerUser, &it.Active, &it.CreatedAt, &it.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan cart line: %w", err)
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+	return lines, nil
+}
+
+func (c *cartDBImplementation) AddCartLine(userID, itemID, quantity, price, maxQuantity int) (int, error) {
+	var total int
+	err := c.db.QueryRow(`
+INSERT INTO cart_lines (user_id, item_id, quantity, price, added_at)
+VALUES ($1, $2, $3, $4, $6)
+ON CONFLICT (user_id, item_id) DO UPDATE SET
+    quantity = cart_lines.quantity + EXCLUDED.quantity,
+    price = EXCLUDED.price,
+    added_at = EXCLUDED.added_at
+WHERE cart_lines.quantity + EXCLUDED.quantity <= $5
+RETURNING quantity
+`, userID, itemID, quantity, price, maxQuantity, time.Now().UTC()).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add item %d to cart of user %d: %w", itemID, userID, err)
+	}
+	return total, nil
+}
+
+func (c *cartDBImplementation) SetCartLine(userID, itemID, quantity, price int) error {
+	_, err := c.db.Exec(`
+INSERT INTO cart_lines (user_id, item_id, quantity, price, added_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id, item_id) DO UPDATE SET
+    quantity = EXCLUDED.quantity,
+    price = EXCLUDED.price,
+    added_at = EXCLUDED.added_at
+`, userID, itemID, quantity, price, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set item %d in cart of user %d: %w", itemID, userID, err)
+	}
+	return nil
+}
+
+func (c *cartDBImplementation) DeleteCartLine(userID, itemID int) error {
+	res, err := c.db.Exec("DELETE FROM cart_lines WHERE user_id=$1 AND item_id=$2", userID, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to delete item %d from cart of user %d: %w", itemID, userID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed to delete item %d from cart of user %d: %w", itemID, userID, sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (c *cartDBImplementation) ClearCart(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("DELETE FROM cart_lines WHERE user_id=$1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear cart of user %d: %w", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type catalogDBImplementation struct {
+	db *sql.DB
+}
+
+func NewCatalogDB(dbConn *sql.DB) CatalogDB {
+	return &catalogDBImplementation{
+		db: dbConn,
+	}
+}
+
+const itemColumns = "id, slug, name, price, stock, max_per_user, active, created_at, updated_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanItem(row rowScanner) (Item, error) {
+	var it Item
+	err := row.Scan(&it.ID, &it.Slug, &it.Name, &it.Price, &it.Stock, &it.MaxPerUser, &it.Active, &it.CreatedAt, &it.UpdatedAt)
+	return it, err
+}
+
+func (c *catalogDBImplementation) BeginTx() (*sql.Tx, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
+func (c *catalogDBImplementation) GetActiveItemForUpdate(tx *sql.Tx, slug string) (Item, error) {
+	it, err := scanItem(tx.QueryRow(`
+SELECT `+itemColumns+`
+FROM items
+WHERE slug=$1 AND active
+FOR UPDATE
+`, slug))
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to get item '%s' for update: %w", slug, err)
+	}
+	return it, nil
+}
+
+func (c *catalogDBImplementation) DecreaseStock(tx *sql.Tx, itemID, quantity int) error {
+	_, err := tx.Exec("UPDATE items SET stock = stock - $1 WHERE id=$2 AND stock IS NOT NULL", quantity, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to decrease stock of item %d: %w", itemID, err)
+	}
+	return nil
+}
+
+func (c *catalogDBImplementation) IncreaseStock(tx *sql.Tx, itemID, quantity int) error {
+	_, err := tx.Exec("UPDATE items SET stock = stock + $1 WHERE id=$2 AND stock IS NOT NULL", quantity, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to increase stock of item %d: %w", itemID, err)
+	}
+	return nil
+}
+
+func (c *catalogDBImplementation) ListActiveItems(maxPrice *int) ([]Item, error) {
+	rows, err := c.db.Query(`
+SELECT `+itemColumns+`
+FROM items
+WHERE active AND ($1::INTEGER IS NULL OR price <= $1)
+ORDER BY slug
+`, maxPrice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	return scanItems(rows)
+}
+
+func (c *catalogDBImplementation) ListItems() ([]Item, error) {
+	rows, err := c.db.Query("SELECT " + itemColumns + " FROM items ORDER BY slug")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	return scanItems(rows)
+}
+
+func scanItems(rows *sql.Rows) ([]Item, error) {
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		it, err := scanItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	return items, nil
+}
+
+func (c *catalogDBImplementation) GetItem(slug string) (Item, error) {
+	it, err := scanItem(c.db.QueryRow("SELECT "+itemColumns+" FROM items WHERE slug=$1", slug))
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to get item '%s': %w", slug, err)
+	}
+	return it, nil
+}
+
+func (c *catalogDBImplementation) GetItemForUpdate(tx *sql.Tx, slug string) (Item, error) {
+	it, err := scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM items WHERE slug=$1 FOR UPDATE", slug))
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to get item '%s' for update: %w", slug, err)
+	}
+	return it, nil
+}
+
+func (c *catalogDBImplementation) InsertItem(tx *sql.Tx, item Item) (Item, error) {
+	it, err := scanItem(tx.QueryRow(`
+INSERT INTO items (slug, name, price, stock, max_per_user)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (slug) DO NOTHING
+RETURNING `+itemColumns, item.Slug, item.Name, item.Price, item.Stock, item.MaxPerUser))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, ErrItemExists
+	}
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to insert item '%s': %w", item.Slug, err)
+	}
+	return it, nil
+}
+
+func (c *catalogDBImplementation) UpdateItem(tx *sql.Tx, item Item) (Item, error) {
+	it, err := scanItem(tx.QueryRow(`
+UPDATE items SET name=$2, price=$3, stock=$4, max_per_user=$5, active=$6, updated_at=$7
+WHERE id=$1
+RETURNING `+itemColumns, item.ID, item.Name, item.Price, item.Stock, item.MaxPerUser, item.Active, time.Now().UTC()))
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to update item %d: %w", item.ID, err)
+	}
+	return it, nil
+}
+
+func (c *catalogDBImplementation) InsertPriceChange(tx *sql.Tx, change PriceChange) error {
+	_, err := tx.Exec(`
+INSERT INTO price_history (item_id, action, old_price, new_price, changed_by, changed_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+`, change.ItemID, change.Action, change.OldPrice, change.NewPrice, change.ChangedBy, change.ChangedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert price change of item %d: %w", change.ItemID, err)
+	}
+	return nil
+}
+
+func (c *catalogDBImplementation) GetPriceHistory(itemID int) ([]PriceChange, error) {
+	rows, err := c.db.Query(`
+SELECT id, item_id, action, old_price, new_price, changed_by, changed_at
+FROM price_history
+WHERE item_id=$1
+ORDER BY changed_at, id
+`, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get price history of item %d: %w", itemID, err)
+	}
+	defer rows.Close()
+
+	var history []PriceChange
+	for rows.Next() {
+		var pc PriceChange
+		if err := rows.Scan(&pc.ID, &pc.ItemID, &pc.Action, &pc.OldPrice, &pc.NewPrice, &pc.ChangedBy, &pc.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan price change: %w", err)
+		}
+		history = append(history, pc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get price history of item %d: %w", itemID, err)
+	}
+	return history, nil
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// checkViolation is the SQLSTATE of writes refused by a CHECK constraint.
+const checkViolation = "23514"
+
+// constraintErrors maps the CHECK constraints of the schema to the errors
+// returned for the writes they refuse.
+var constraintErrors = map[string]error{
+	"users_coins_nonnegative":           ErrNegativeCoins,
+	"inventories_quantity_nonnegative":  ErrNegativeQuantity,
+	"coin_transactions_amount_positive": ErrInvalidAmount,
+	"coin_transactions_type_check":      ErrInvalidTransactionType,
+}
+
+// constraintError returns err wrapped in the error of the CHECK constraint it
+// violates, if any, so callers can tell the refused write with errors.Is.
+func constraintError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != checkViolation {
+		return err
+	}
+	if known, ok := constraintErrors[pqErr.Constraint]; ok {
+		return fmt.Errorf("%w: %w", known, err)
+	}
+	return err
+}
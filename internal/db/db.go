@@ -3,7 +3,10 @@ package db
 import (
 	"avito-shop/internal/config"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -11,15 +14,32 @@ import (
 type CoinInventoryDB interface {
 	BeginTx() (*sql.Tx, error)
 	GetCoinsForUpdate(tx *sql.Tx, userID int) (int, error)
-	IncreaseCoins(tx *sql.Tx, userID, amount int) error
-	DecreaseCoins(tx *sql.Tx, userID, amount int) error
+	// PostEntry records the entry in the ledger and applies its postings to
+	// the balances of the users cached in users.coins. It returns
+	// ErrUnbalancedEntry if the postings do not sum up to zero, and
+	// ErrNegativeCoins instead of leaving a user with negative coins.
+	PostEntry(tx *sql.Tx, entry JournalEntry) (JournalEntry, error)
+	// IncreaseItem adds delta of the item to the user, creating the row of
+	// the item if needed.
 	IncreaseItem(tx *sql.Tx, userID int, item string, delta int) error
-	InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int) error
-	InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int) error
+	// InsertTransaction returns ErrInvalidAmount for amounts that are not
+	// positive. An empty note is stored as NULL.
+	InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int, note string) error
+	InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int, note string) error
 	GetUserIDByUsernameForUpdate(tx *sql.Tx, username string) (int, error)
+	// GetUserIDByUsername is GetUserIDByUsernameForUpdate without the lock,
+	// for callers that lock users in a fixed order afterwards.
+	GetUserIDByUsername(tx *sql.Tx, username string) (int, error)
+	// DecreaseItem takes delta of the item from the user. It returns
+	// sql.ErrNoRows if the user owns fewer.
+	DecreaseItem(tx *sql.Tx, userID int, item string, delta int) error
+	// GetItemQuantity returns how many of the item the user owns, 0 if none.
+	GetItemQuantity(tx *sql.Tx, userID int, item string) (int, error)
 	GetUserCoins(userID int) (int, error)
 	GetInventory(userID int) ([]InventoryItem, error)
-	GetTransactions(userID int, transactionType string) ([]Transaction, error)
+	// GetCoinHistory returns up to limit transactions of the user matching
+	// the filter, newest first, starting after the cursor.
+	GetCoinHistory(userID int, filter HistoryFilter, cursor HistoryCursor, limit int) ([]Transaction, error)
 }
 type InventoryItem struct {
 	Type     string
@@ -27,12 +47,458 @@ type InventoryItem struct {
 }
 
 type Transaction struct {
+	ID   int
+	Type string
+	// Counterparty is the recipient of sent transactions and the sender of
+	// received ones.
 	Counterparty string
 	Amount       int
+	Note         sql.NullString
+	CreatedAt    time.Time
+}
+
+// HistoryFilter selects transactions for GetCoinHistory. Zero fields match
+// all transactions.
+type HistoryFilter struct {
+	Type         string
+	Counterparty string
+	// MinAmount and MaxAmount are both inclusive.
+	MinAmount sql.NullInt64
+	MaxAmount sql.NullInt64
+	// From is inclusive and To exclusive.
+	From sql.NullTime
+	To   sql.NullTime
+}
+
+// HistoryCursor is the last transaction of a page of the history; the next
+// page starts with the transaction before it. The zero cursor starts with the
+// newest transaction.
+type HistoryCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// Types of coin transactions.
+const (
+	TransactionSent     = "sent"
+	TransactionReceived = "received"
+)
+
+// Writes refused by the CHECK constraints of the schema return these errors.
+var (
+	ErrNegativeCoins          = errors.New("coins cannot go negative")
+	ErrNegativeQuantity       = errors.New("quantity cannot go negative")
+	ErrInvalidAmount          = errors.New("amount must be positive")
+	ErrInvalidTransactionType = errors.New("invalid transaction type")
+)
+
+// Kinds of ledger accounts. Every user has an account; coins are granted from
+// the issuance account and paid to the revenue account of the shop.
+const (
+	AccountUser     = "user"
+	AccountIssuance = "issuance"
+	AccountRevenue  = "revenue"
+)
+
+// Account identifies a ledger account.
+type Account struct {
+	Kind string
+	// UserID is only set for AccountUser.
+	UserID int
+}
+
+var (
+	IssuanceAccount = Account{Kind: AccountIssuance}
+	RevenueAccount  = Account{Kind: AccountRevenue}
+)
+
+func UserAccount(userID int) Account {
+	return Account{Kind: AccountUser, UserID: userID}
+}
+
+func (a Account) String() string {
+	if a.Kind == AccountUser {
+		return fmt.Sprintf("account of user %d", a.UserID)
+	}
+	return a.Kind + " account"
+}
+
+// Kinds of journal entries.
+const (
+	EntryOpening    = "opening"
+	EntryGrant      = "grant"
+	EntryPurchase   = "purchase"
+	EntryRefund     = "refund"
+	EntryTransfer   = "transfer"
+	EntryMarketSale = "market_sale"
+)
+
+// Posting moves Amount coins into Account, or out of it if negative.
+type Posting struct {
+	Account Account
+	Amount  int
+}
+
+// JournalEntry is a balance change: its postings sum up to zero. Entries are
+// never changed once posted.
+type JournalEntry struct {
+	ID   int
+	Kind string
+	// OrderID, RefundID and ListingID are set to what the entry pays for.
+	OrderID   sql.NullInt64
+	RefundID  sql.NullInt64
+	ListingID sql.NullInt64
+	Postings  []Posting
+	CreatedAt time.Time
+}
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry is not balanced")
+	ErrAccountNotFound = errors.New("ledger account not found")
+)
+
+// Balance puts the coins cached for a user next to the balance of their
+// ledger account.
+type Balance struct {
+	UserID   int
+	Username string
+	Coins    int
+	Ledger   int
+	// HasAccount is false for users without a ledger account, whose Ledger
+	// balance is then meaningless.
+	HasAccount bool
+}
+
+// LedgerDB reads the ledger back to check users.coins against it.
+type LedgerDB interface {
+	BeginTx() (*sql.Tx, error)
+	// ListBalances returns the balances of all users, ordered by ID.
+	ListBalances() ([]Balance, error)
+	// ListUnbalancedEntries returns the IDs of the entries whose postings do
+	// not sum up to zero.
+	ListUnbalancedEntries() ([]int, error)
+	// RepairCoins locks the user and sets their coins to the balance of their
+	// account. It returns the balance as it was before, and
+	// ErrAccountNotFound for users without an account.
+	RepairCoins(tx *sql.Tx, userID int) (Balance, error)
+}
+
+// Item is an entry of the catalog. Stock and MaxPerUser are NULL for unlimited
+// items.
+type Item struct {
+	ID         int
+	Slug       string
+	Name       string
+	Price      int
+	Stock      sql.NullInt64
+	MaxPerUser sql.NullInt64
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type PriceChange struct {
+	ID        int
+	ItemID    int
+	Action    string
+	OldPrice  sql.NullInt64
+	NewPrice  int
+	ChangedBy sql.NullInt64
+	ChangedAt time.Time
+}
+
+var ErrItemExists = errors.New("item already exists")
+
+type CatalogDB interface {
+	BeginTx() (*sql.Tx, error)
+	// GetActiveItemForUpdate returns sql.ErrNoRows for unknown and deactivated
+	// items. The row stays locked, so neither its price nor its stock can
+	// change before tx ends.
+	GetActiveItemForUpdate(tx *sql.Tx, slug string) (Item, error)
+	// DecreaseStock takes quantity off a limited item; it ignores unlimited ones.
+	DecreaseStock(tx *sql.Tx, itemID, quantity int) error
+	// IncreaseStock puts returned items back; it ignores unlimited ones.
+	IncreaseStock(tx *sql.Tx, itemID, quantity int) error
+	// ListActiveItems returns the active items ordered by slug, only those
+	// costing at most maxPrice unless it is nil.
+	ListActiveItems(maxPrice *int) ([]Item, error)
+	// ListItems returns all items, deactivated ones included, ordered by slug.
+	ListItems() ([]Item, error)
+	GetItem(slug string) (Item, error)
+	// GetItemForUpdate waits for purchases holding the item to finish.
+	GetItemForUpdate(tx *sql.Tx, slug string) (Item, error)
+	// InsertItem returns ErrItemExists if the slug is taken.
+	InsertItem(tx *sql.Tx, item Item) (Item, error)
+	// UpdateItem stores everything but the slug and the timestamps of item.
+	UpdateItem(tx *sql.Tx, item Item) (Item, error)
+	InsertPriceChange(tx *sql.Tx, change PriceChange) error
+	// GetPriceHistory returns the changes of an item, oldest first.
+	GetPriceHistory(itemID int) ([]PriceChange, error)
+}
+
+// CartLine is a line of a cart along with the current state of its item.
+type CartLine struct {
+	Item     Item
+	Quantity int
+	// Price is the price of one unit when the line was last added or changed.
+	Price   int
+	AddedAt time.Time
+}
+
+type CartDB interface {
+	// GetCart returns the lines ordered by item slug.
+	GetCart(userID int) ([]CartLine, error)
+	// GetCartForUpdate is GetCart locking the lines until tx ends.
+	GetCartForUpdate(tx *sql.Tx, userID int) ([]CartLine, error)
+	// AddCartLine adds quantity to the line of the item, creating it if needed,
+	// and returns the new quantity. It returns sql.ErrNoRows instead of letting
+	// the line grow over maxQuantity.
+	AddCartLine(userID, itemID, quantity, price, maxQuantity int) (int, error)
+	SetCartLine(userID, itemID, quantity, price int) error
+	DeleteCartLine(userID, itemID int) error
+	ClearCart(tx *sql.Tx, userID int) error
+}
+
+// Order is a purchase as it was made. Its lines keep the slug, name and price
+// of the items at that time.
+type Order struct {
+	ID     int
+	UserID int
+	// RecipientID is set for orders bought as a gift for another user.
+	RecipientID sql.NullInt64
+	// PromoCode is set when Discount was taken off by a promo code.
+	PromoCode sql.NullString
+	Discount  int
+	Total     int
+	CreatedAt time.Time
+	Lines     []OrderLine
+}
+
+type OrderLine struct {
+	ItemID   int
+	Item     string
+	Name     string
+	Quantity int
+	Price    int
+	// Discount is taken off Price times Quantity to make up Total.
+	Discount int
+	Total    int
+	// Refunded is how many of Quantity were refunded since.
+	Refunded int
+}
+
+// Refund returns lines of an order. Its lines carry the refunded quantities
+// at the prices of the order, less their share of its discount.
+type Refund struct {
+	ID         int
+	OrderID    int
+	RefundedBy int
+	Total      int
+	CreatedAt  time.Time
+	Lines      []OrderLine
+}
+
+type OrderDB interface {
+	// InsertOrder stores the order with its lines and returns it with its ID
+	// and creation time set.
+	InsertOrder(tx *sql.Tx, order Order) (Order, error)
+	// ListOrders returns a page of the orders of the user with their lines,
+	// newest first.
+	ListOrders(userID, limit, offset int) ([]Order, error)
+	// GetOrder returns sql.ErrNoRows unless the order belongs to the user.
+	GetOrder(userID, orderID int) (Order, error)
+	// GetOrderForUpdate returns the order of any user and locks it, so its
+	// lines cannot be refunded concurrently.
+	GetOrderForUpdate(tx *sql.Tx, orderID int) (Order, error)
+	// InsertRefund stores the refund and counts its lines as refunded in the
+	// order. It returns sql.ErrNoRows instead of refunding more of a line than
+	// was bought.
+	InsertRefund(tx *sql.Tx, refund Refund) (Refund, error)
+	// GetRefunds returns the refunds of the order, oldest first.
+	GetRefunds(orderID int) ([]Refund, error)
+}
+
+// Kinds of promo codes.
+const (
+	PromoPercent = "percent"
+	PromoFixed   = "fixed"
+)
+
+// PromoCode takes Value percent or Value coins off the items it applies to.
+// Limits and validity bounds are NULL when not set.
+type PromoCode struct {
+	ID    int
+	Code  string
+	Kind  string
+	Value int
+	// Items are the slugs of the items the code applies to; empty for all.
+	Items          []string
+	MaxRedemptions sql.NullInt64
+	MaxPerUser     sql.NullInt64
+	Redemptions    int
+	ValidFrom      sql.NullTime
+	ValidUntil     sql.NullTime
+	CreatedBy      int
+	CreatedAt      time.Time
+}
+
+var (
+	ErrPromoCodeExists = errors.New("promo code already exists")
+	// ErrPromoItemNotFound is returned for codes restricted to unknown items.
+	ErrPromoItemNotFound = errors.New("promo code item not found")
+)
+
+type PromoDB interface {
+	BeginTx() (*sql.Tx, error)
+	// InsertPromoCode returns ErrPromoCodeExists if the code is taken and
+	// ErrPromoItemNotFound if one of its items does not exist.
+	InsertPromoCode(tx *sql.Tx, code PromoCode) (PromoCode, error)
+	ListPromoCodes() ([]PromoCode, error)
+	// GetPromoCodeForUpdate locks the code, so its redemptions cannot be
+	// counted concurrently.
+	GetPromoCodeForUpdate(tx *sql.Tx, code string) (PromoCode, error)
+	// CountRedemptions returns how many times the user redeemed the code.
+	CountRedemptions(tx *sql.Tx, promoCodeID, userID int) (int, error)
+	// InsertRedemption records the use of the code for the order. It returns
+	// sql.ErrNoRows instead of going over the redemption limit of the code.
+	InsertRedemption(tx *sql.Tx, promoCodeID, userID, orderID, discount int) error
+}
+
+// Gift is an item given to another user, either bought for them or taken from
+// the inventory of the sender.
+type Gift struct {
+	ID         int
+	FromUserID int
+	FromUser   string
+	ToUserID   int
+	ToUser     string
+	Item       string
+	Quantity   int
+	Message    sql.NullString
+	// OrderID is set for bought gifts.
+	OrderID   sql.NullInt64
+	CreatedAt time.Time
+}
+
+type GiftDB interface {
+	// InsertGift returns the gift with its ID and creation time set; the
+	// usernames are not filled in.
+	InsertGift(tx *sql.Tx, gift Gift) (Gift, error)
+	// GetGifts returns the gifts sent or received by the user, oldest first.
+	GetGifts(userID int) ([]Gift, error)
+}
+
+// Statuses of a listing. Only open listings can be bought or cancelled.
+const (
+	ListingOpen      = "open"
+	ListingSold      = "sold"
+	ListingCancelled = "cancelled"
+)
+
+// Listing offers Quantity of Item on the marketplace for Price coins in total.
+// The items are held by the listing until it is closed.
+type Listing struct {
+	ID       int
+	SellerID int
+	Seller   string
+	Item     string
+	Quantity int
+	Price    int
+	Status   string
+	// BuyerID and Fee are set once the listing is sold.
+	BuyerID   sql.NullInt64
+	Fee       int
+	CreatedAt time.Time
+	ClosedAt  sql.NullTime
+}
+
+type ListingDB interface {
+	// InsertListing returns the listing open, with its ID and creation time
+	// set; the seller name is not filled in.
+	InsertListing(tx *sql.Tx, listing Listing) (Listing, error)
+	// GetListingForUpdate locks the listing, so it cannot be closed
+	// concurrently.
+	GetListingForUpdate(tx *sql.Tx, listingID int) (Listing, error)
+	// CloseListing moves an open listing to status, recording the buyer and
+	// the fee of a sale. It returns sql.ErrNoRows if the listing is not open.
+	CloseListing(tx *sql.Tx, listingID int, status string, buyerID sql.NullInt64, fee int) error
+	// ListOpenListings returns a page of the open listings, newest first,
+	// optionally only those of one item.
+	ListOpenListings(item string, limit, offset int) ([]Listing, error)
+}
+
+var ErrUserExists = errors.New("user already exists")
+
+// UserAuthData is what a login needs to know about a user.
+type UserAuthData struct {
+	ID           int
+	PasswordHash string
+	Role         string
+}
+
+type User struct {
+	ID       int
+	Username string
+	Role     string
+	Coins    int
 }
 
 type AuthDB interface {
-	GetUserAuthData(username string) (int, string, error)
+	BeginTx() (*sql.Tx, error)
+	GetUserAuthData(username string) (UserAuthData, error)
+	// CreateUser opens the ledger account of the user and grants it coins from
+	// the issuance account. It returns ErrUserExists if the username was taken
+	// concurrently. New users get the default role of the users table.
+	CreateUser(tx *sql.Tx, username, passwordHash string, coins int) (int, error)
+	UpdatePasswordHash(userID int, passwordHash string) error
+	GetUser(userID int) (User, error)
+	SetUserRole(userID int, role string) error
+}
+
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	Username  string
+	Role      string
+	FamilyID  string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	RevokedAt sql.NullTime
+}
+
+type RefreshTokenDB interface {
+	BeginTx() (*sql.Tx, error)
+	InsertRefreshToken(tx *sql.Tx, userID int, familyID, tokenHash string, expiresAt time.Time) error
+	GetRefreshTokenForUpdate(tx *sql.Tx, tokenHash string) (RefreshToken, error)
+	MarkRefreshTokenUsed(tx *sql.Tx, id int) error
+	RevokeRefreshTokenFamily(tx *sql.Tx, familyID string) error
+	RevokeUserRefreshTokens(tx *sql.Tx, userID int) error
+}
+
+type RevocationDB interface {
+	BeginTx() (*sql.Tx, error)
+	InsertRevokedToken(jti string, userID int, expiresAt time.Time) error
+	IsTokenRevoked(jti string) (bool, error)
+	// DeleteExpiredRevokedTokens deletes the revocations of tokens expired
+	// before the given time and returns how many there were.
+	DeleteExpiredRevokedTokens(before time.Time) (int64, error)
+	SetSessionsRevokedAt(tx *sql.Tx, userID int, revokedAt time.Time) error
+	GetSessionsRevokedAt(userID int) (sql.NullTime, error)
+}
+
+type LoginAttempt struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// LoginAttemptDB counts failed logins per key, such as a username or an IP.
+type LoginAttemptDB interface {
+	// GetLoginAttempt returns the zero LoginAttempt for keys without failures.
+	GetLoginAttempt(key string) (LoginAttempt, error)
+	// RecordLoginFailure counts a failure at the given time. Failures that
+	// happened no later than expireBefore are forgotten first.
+	RecordLoginFailure(key string, at, expireBefore time.Time) (LoginAttempt, error)
+	ResetLoginAttempts(key string) error
 }
 
 func Connect(cfg *config.Config) (*sql.DB, error) {
@@ -43,7 +509,8 @@ func Connect(cfg *config.Config) (*sql.DB, error) {
 		cfg.DatabasePassword,
 		cfg.DatabaseName,
 	)
-	fmt.Printf("Connecting to database: host=%s port=%s user=%s password=%s dbname=%s sslmode=disable\n",
+	// stdout is left to the output of subcommands
+	fmt.Fprintf(os.Stderr, "Connecting to database: host=%s port=%s user=%s password=%s dbname=%s sslmode=disable\n",
 		cfg.DatabaseHost, cfg.DatabasePort, cfg.DatabaseUser, cfg.DatabasePassword, cfg.DatabaseName)
 
 	db, err := sql.Open("postgres", connStr)
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type giftDBImplementation struct {
+	db *sql.DB
+}
+
+func NewGiftDB(dbConn *sql.DB) GiftDB {
+	return &giftDBImplementation{
+		db: dbConn,
+	}
+}
+
+func (g *giftDBImplementation) InsertGift(tx *sql.Tx, gift Gift) (Gift, error) {
+	gift.CreatedAt = time.Now().UTC()
+	err := tx.QueryRow(`
+INSERT INTO gifts (from_user_id, to_user_id, item, quantity, message, order_id, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id
+`, gift.FromUserID, gift.ToUserID, gift.Item, gift.Quantity, gift.Message, gift.OrderID, gift.CreatedAt).Scan(&gift.ID)
+	if err != nil {
+		return Gift{}, fmt.Errorf("failed to insert gift from user %d to user %d: %w", gift.FromUserID, gift.ToUserID, err)
+	}
+	return gift, nil
+}
+
+func (g *giftDBImplementation) GetGifts(userID int) ([]Gift, error) {
+	rows, err := g.db.Query(`
+SELECT g.id, g.from_user_id, f.username, g.to_user_id, t.username,
+       g.item, g.quantity, g.message, g.order_id, g.created_at
+FROM gifts g
+JOIN users f ON f.id = g.from_user_id
+JOIN users t ON t.id = g.to_user_id
+WHERE g.from_user_id=$1 OR g.to_user_id=$1
+ORDER BY g.id
+`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gifts of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var gifts []Gift
+	for rows.Next() {
+		var gift Gift
+		if err := rows.Scan(&gift.ID, &gift.FromUserID, &gift.FromUser, &gift.ToUserID, &gift.ToUser,
+			&gift.Item, &gift.Quantity, &gift.Message, &gift.OrderID, &gift.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan gift: %w", err)
+		}
+		gifts = append(gifts, gift)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get gifts of user %d: %w", userID, err)
+	}
+	return gifts, nil
+}
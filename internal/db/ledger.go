@@ -0,0 +1,174 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (c *coinInventoryDBImplementation) PostEntry(tx *sql.Tx, entry JournalEntry) (JournalEntry, error) {
+	return postEntry(tx, entry)
+}
+
+// openUserAccount creates the ledger account of a new user.
+func openUserAccount(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("INSERT INTO ledger_accounts (kind, user_id) VALUES ($1, $2)", AccountUser, userID)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger account of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// postEntry inserts the entry and its postings, and keeps users.coins in step
+// with the postings to user accounts. The database checks again at commit
+// that the entry is balanced.
+func postEntry(tx *sql.Tx, entry JournalEntry) (JournalEntry, error) {
+	sum := 0
+	for _, p := range entry.Postings {
+		if p.Amount == 0 {
+			return JournalEntry{}, fmt.Errorf("%w: %s entry posts nothing to the %s", ErrUnbalancedEntry, entry.Kind, p.Account)
+		}
+		sum += p.Amount
+	}
+	if len(entry.Postings) < 2 || sum != 0 {
+		return JournalEntry{}, fmt.Errorf("%w: %s entry of %d postings sums up to %d", ErrUnbalancedEntry, entry.Kind, len(entry.Postings), sum)
+	}
+
+	entry.CreatedAt = time.Now().UTC()
+	err := tx.QueryRow(`
+INSERT INTO journal_entries (kind, order_id, refund_id, listing_id, created_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id
+`, entry.Kind, entry.OrderID, entry.RefundID, entry.ListingID, entry.CreatedAt).Scan(&entry.ID)
+	if err != nil {
+		return JournalEntry{}, fmt.Errorf("failed to insert %s entry: %w", entry.Kind, err)
+	}
+
+	for _, p := range entry.Postings {
+		var res sql.Result
+		if p.Account.Kind == AccountUser {
+			res, err = tx.Exec(`
+INSERT INTO journal_postings (entry_id, account_id, amount)
+SELECT $1, id, $3 FROM ledger_accounts WHERE user_id=$2
+`, entry.ID, p.Account.UserID, p.Amount)
+		} else {
+			res, err = tx.Exec(`
+INSERT INTO journal_postings (entry_id, account_id, amount)
+SELECT $1, id, $3 FROM ledger_accounts WHERE kind=$2 AND user_id IS NULL
+`, entry.ID, p.Account.Kind, p.Amount)
+		}
+		if err != nil {
+			return JournalEntry{}, fmt.Errorf("failed to post %d to the %s: %w", p.Amount, p.Account, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return JournalEntry{}, fmt.Errorf("failed to post %d to the %s: %w", p.Amount, p.Account, ErrAccountNotFound)
+		}
+
+		if p.Account.Kind == AccountUser {
+			if _, err := tx.Exec("UPDATE users SET coins = coins + $1 WHERE id=$2", p.Amount, p.Account.UserID); err != nil {
+				return JournalEntry{}, fmt.Errorf("failed to update coins of user %d: %w", p.Account.UserID, constraintError(err))
+			}
+		}
+	}
+	return entry, nil
+}
+
+type ledgerDBImplementation struct {
+	db *sql.DB
+}
+
+func NewLedgerDB(dbConn *sql.DB) LedgerDB {
+	return &ledgerDBImplementation{
+		db: dbConn,
+	}
+}
+
+func (l *ledgerDBImplementation) BeginTx() (*sql.Tx, error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
+// balanceQuery is run as a single statement, so the coins and the postings
+// it reads are consistent even while coins are being moved.
+const balanceQuery = `
+SELECT u.id, u.username, u.coins, COALESCE(SUM(p.amount), 0), a.id IS NOT NULL
+FROM users u
+LEFT JOIN ledger_accounts a ON a.user_id = u.id
+LEFT JOIN journal_postings p ON p.account_id = a.id
+`
+
+func (l *ledgerDBImplementation) ListBalances() ([]Balance, error) {
+	rows, err := l.db.Query(balanceQuery + "GROUP BY u.id, a.id ORDER BY u.id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list balances: %w", err)
+	}
+	defer rows.Close()
+
+	var balances []Balance
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.UserID, &b.Username, &b.Coins, &b.Ledger, &b.HasAccount); err != nil {
+			return nil, fmt.Errorf("failed to scan balance: %w", err)
+		}
+		balances = append(balances, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list balances: %w", err)
+	}
+	return balances, nil
+}
+
+func (l *ledgerDBImplementation) ListUnbalancedEntries() ([]int, error) {
+	rows, err := l.db.Query(`
+SELECT e.id
+FROM journal_entries e
+LEFT JOIN journal_postings p ON p.entry_id = e.id
+GROUP BY e.id
+HAVING COALESCE(SUM(p.amount), 0) <> 0 OR COUNT(p.id) < 2
+ORDER BY e.id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unbalanced entries: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan entry id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list unbalanced entries: %w", err)
+	}
+	return ids, nil
+}
+
+func (l *ledgerDBImplementation) RepairCoins(tx *sql.Tx, userID int) (Balance, error) {
+	// coins are only moved with the user locked, so no postings to the
+	// account can be added until the repair commits
+	if _, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+		return Balance{}, fmt.Errorf("failed to lock user %d: %w", userID, err)
+	}
+	var b Balance
+	err := tx.QueryRow(balanceQuery+"WHERE u.id=$1 GROUP BY u.id, a.id", userID).
+		Scan(&b.UserID, &b.Username, &b.Coins, &b.Ledger, &b.HasAccount)
+	if err != nil {
+		return Balance{}, fmt.Errorf("failed to get balance of user %d: %w", userID, err)
+	}
+	if !b.HasAccount {
+		return b, fmt.Errorf("failed to repair coins of user %d: %w", userID, ErrAccountNotFound)
+	}
+	if b.Coins == b.Ledger {
+		return b, nil
+	}
+	if _, err := tx.Exec("UPDATE users SET coins=$2 WHERE id=$1", userID, b.Ledger); err != nil {
+		return Balance{}, fmt.Errorf("failed to repair coins of user %d: %w", userID, err)
+	}
+	return b, nil
+}
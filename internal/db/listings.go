@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type listingDBImplementation struct {
+	db *sql.DB
+}
+
+func NewListingDB(dbConn *sql.DB) ListingDB {
+	return &listingDBImplementation{
+		db: dbConn,
+	}
+}
+
+const listingColumns = "l.id, l.seller_id, u.username, l.item, l.quantity, l.price, l.status, l.buyer_id, l.fee, l.created_at, l.closed_at"
+
+func (l *listingDBImplementation) InsertListing(tx *sql.Tx, listing Listing) (Listing, error) {
+	listing.Status = ListingOpen
+	listing.CreatedAt = time.Now().UTC()
+	err := tx.QueryRow(`
+INSERT INTO listings (seller_id, item, quantity, price, status, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
+`, listing.SellerID, listing.Item, listing.Quantity, listing.Price, listing.Status, listing.CreatedAt).Scan(&listing.ID)
+	if err != nil {
+		return Listing{}, fmt.Errorf("failed to insert listing of user %d: %w", listing.SellerID, err)
+	}
+	return listing, nil
+}
+
+func (l *listingDBImplementation) GetListingForUpdate(tx *sql.Tx, listingID int) (Listing, error) {
+	row := tx.QueryRow(`
+SELECT `+listingColumns+`
+FROM listings l
+JOIN users u ON u.id = l.seller_id
+WHERE l.id=$1
+FOR UPDATE OF l
+`, listingID)
+	listing, err := scanListing(row)
+	if err != nil {
+		return Listing{}, fmt.Errorf("failed to get listing %d for update: %w", listingID, err)
+	}
+	return listing, nil
+}
+
+func (l *listingDBImplementation) CloseListing(tx *sql.Tx, listingID int, status string, buyerID sql.NullInt64, fee int) error {
+	res, err := tx.Exec(`
+UPDATE listings SET status=$2, buyer_id=$3, fee=$4, closed_at=$5
+WHERE id=$1 AND status='open'
+`, listingID, status, buyerID, fee, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to close listing %d: %w", listingID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to close listing %d: %w", listingID, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("listing %d is not open: %w", listingID, sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (l *listingDBImplementation) ListOpenListings(item string, limit, offset int) ([]Listing, error) {
+	rows, err := l.db.Query(`
+SELECT `+listingColumns+`
+FROM listings l
+JOIN users u ON u.id = l.seller_id
+WHERE l.status='open' AND ($1::VARCHAR = '' OR l.item = $1)
+ORDER BY l.id DESC
+LIMIT $2 OFFSET $3
+`, item, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list listings: %w", err)
+	}
+	defer rows.Close()
+
+	var listings []Listing
+	for rows.Next() {
+		listing, err := scanListing(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan listing: %w", err)
+		}
+		listings = append(listings, listing)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list listings: %w", err)
+	}
+	return listings, nil
+}
+
+func scanListing(row rowScanner) (Listing, error) {
+	var listing Listing
+	err := row.Scan(&listing.ID, &listing.SellerID, &listing.Seller, &listing.Item, &listing.Quantity, &listing.Price,
+		&listing.Status, &listing.BuyerID, &listing.Fee, &listing.CreatedAt, &listing.ClosedAt)
+	return listing, err
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type loginAttemptDBImplementation struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptDB(dbConn *sql.DB) LoginAttemptDB {
+	return &loginAttemptDBImplementation{
+		db: dbConn,
+	}
+}
+
+func (l *loginAttemptDBImplementation) GetLoginAttempt(key string) (LoginAttempt, error) {
+	var a LoginAttempt
+	err := l.db.QueryRow("SELECT failures, last_failure FROM login_attempts WHERE key=$1", key).
+		Scan(&a.Failures, &a.LastFailure)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginAttempt{}, nil
+	}
+	if err != nil {
+		return LoginAttempt{}, fmt.Errorf("failed to get login attempts for '%s': %w", key, err)
+	}
+	return a, nil
+}
+
+func (l *loginAttemptDBImplementation) RecordLoginFailure(key string, at, expireBefore time.Time) (LoginAttempt, error) {
+	var a LoginAttempt
+	err := l.db.QueryRow(`
+INSERT INTO login_attempts (key, failures, last_failure)
+VALUES ($1, 1, $2)
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE WHEN login_attempts.last_failure <= $3 THEN 1 ELSE login_attempts.failures + 1 END,
+    last_failure = EXCLUDED.last_failure
+RETURNING failures, last_failure
+`, key, at.UTC(), expireBefore.UTC()).Scan(&a.Failures, &a.LastFailure)
+	if err != nil {
+		return LoginAttempt{}, fmt.Errorf("failed to record login failure for '%s': %w", key, err)
+	}
+	return a, nil
+}
+
+func (l *loginAttemptDBImplementation) ResetLoginAttempts(key string) error {
+	_, err := l.db.Exec("DELETE FROM login_attempts WHERE key=$1", key)
+	if err != nil {
+		return fmt.Errorf("failed to reset login attempts for '%s': %w", key, err)
+	}
+	return nil
+}
+
+// memoryLoginAttemptDB keeps the counters in process. It suits tests and single
+// instance deployments; counters are lost on restart.
+type memoryLoginAttemptDB struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+}
+
+func NewMemoryLoginAttemptDB() LoginAttemptDB {
+	return &memoryLoginAttemptDB{
+		attempts: make(map[string]LoginAttempt),
+	}
+}
+
+func (m *memoryLoginAttemptDB) GetLoginAttempt(key string) (LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.attempts[key], nil
+}
+
+func (m *memoryLoginAttemptDB) RecordLoginFailure(key string, at, expireBefore time.Time) (LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.attempts[key]
+	if !a.LastFailure.After(expireBefore) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailure = at
+	m.attempts[key] = a
+	return a, nil
+}
+
+func (m *memoryLoginAttemptDB) ResetLoginAttempts(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type orderDBImplementation struct {
+	db *sql.DB
+}
+
+func NewOrderDB(dbConn *sql.DB) OrderDB {
+	return &orderDBImplementation{
+		db: dbConn,
+	}
+}
+
+func (o *orderDBImplementation) InsertOrder(tx *sql.Tx, order Order) (Order, error) {
+	order.CreatedAt = time.Now().UTC()
+	err := tx.QueryRow(`
+INSERT INTO orders (user_id, recipient_id, promo_code, discount, total, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
+`, order.UserID, order.RecipientID, order.PromoCode, order.Discount, order.Total, order.CreatedAt).Scan(&order.ID)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to insert order of user %d: %w", order.UserID, err)
+	}
+	for _, l := range order.Lines {
+		_, err := tx.Exec(`
+INSERT INTO order_lines (order_id, item_id, item, name, quantity, price, discount, total)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`, order.ID, l.ItemID, l.Item, l.Name, l.Quantity, l.Price, l.Discount, l.Total)
+		if err != nil {
+			return Order{}, fmt.Errorf("failed to insert line '%s' of order %d: %w", l.Item, order.ID, err)
+		}
+	}
+	return order, nil
+}
+
+func (o *orderDBImplementation) ListOrders(userID, limit, offset int) ([]Order, error) {
+	rows, err := o.db.Query(`
+SELECT o.id, o.user_id, o.recipient_id, o.promo_code, o.discount, o.total, o.created_at,
+       ol.item_id, ol.item, ol.name, ol.quantity, ol.price, ol.discount, ol.total, ol.refunded
+FROM (
+    SELECT id, user_id, recipient_id, promo_code, discount, total, created_at
+    FROM orders
+    WHERE user_id=$1
+    ORDER BY id DESC
+    LIMIT $2 OFFSET $3
+) o
+JOIN order_lines ol ON ol.order_id = o.id
+ORDER BY o.id DESC, ol.item
+`, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders of user %d: %w", userID, err)
+	}
+	return scanOrders(rows)
+}
+
+func (o *orderDBImplementation) GetOrder(userID, orderID int) (Order, error) {
+	rows, err := o.db.Query(`
+SELECT o.id, o.user_id, o.recipient_id, o.promo_code, o.discount, o.total, o.created_at,
+       ol.item_id, ol.item, ol.name, ol.quantity, ol.price, ol.discount, ol.total, ol.refunded
+FROM orders o
+JOIN order_lines ol ON ol.order_id = o.id
+WHERE o.id=$1 AND o.user_id=$2
+ORDER BY ol.item
+`, orderID, userID)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to get order %d: %w", orderID, err)
+	}
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(orders) == 0 {
+		return Order{}, fmt.Errorf("failed to get order %d: %w", orderID, sql.ErrNoRows)
+	}
+	return orders[0], nil
+}
+
+func (o *orderDBImplementation) GetOrderForUpdate(tx *sql.Tx, orderID int) (Order, error) {
+	rows, err := tx.Query(`
+SELECT o.id, o.user_id, o.recipient_id, o.promo_code, o.discount, o.total, o.created_at,
+       ol.item_id, ol.item, ol.name, ol.quantity, ol.price, ol.discount, ol.total, ol.refunded
+FROM orders o
+JOIN order_lines ol ON ol.order_id = o.id
+WHERE o.id=$1
+ORDER BY ol.item
+FOR UPDATE OF o
+`, orderID)
+	if err != nil {
+		return Order{}, fmt.Errorf("failed to get order %d for update: %w", orderID, err)
+	}
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(orders) == 0 {
+		return Order{}, fmt.Errorf("failed to get order %d for update: %w", orderID, sql.ErrNoRows)
+	}
+	return orders[0], nil
+}
+
+// scanOrders groups the joined rows of orders and their lines; the rows of an
+// order must be adjacent.
+func scanOrders(rows *sql.Rows) ([]Order, error) {
+	defer rows.Close()
+
+	var orders []Order
+	for rows.Next() {
+		var ord Order
+		var l OrderLine
+		if err := rows.Scan(&ord.ID, &ord.UserID, &ord.RecipientID, &ord.PromoCode, &ord.Discount, &ord.Total, &ord.CreatedAt,
+			&l.ItemID, &l.Item, &l.Name, &l.Quantity, &l.Price, &l.Discount, &l.Total, &l.Refunded); err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		if n := len(orders); n == 0 || orders[n-1].ID != ord.ID {
+			orders = append(orders, ord)
+		}
+		last := &orders[len(orders)-1]
+		last.Lines = append(last.Lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get orders: %w", err)
+	}
+	return orders, nil
+}
+
+func (o *orderDBImplementation) InsertRefund(tx *sql.Tx, refund Refund) (Refund, error) {
+	refund.CreatedAt = time.Now().UTC()
+	err := tx.QueryRow(`
+INSERT INTO refunds (order_id, refunded_by, total, created_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id
+`, refund.OrderID, refund.RefundedBy, refund.Total, refund.CreatedAt).Scan(&refund.ID)
+	if err != nil {
+		return Refund{}, fmt.Errorf("failed to insert refund of order %d: %w", refund.OrderID, err)
+	}
+	for _, l := range refund.Lines {
+		res, err := tx.Exec(`
+UPDATE order_lines SET refunded = refunded + $3
+WHERE order_id=$1 AND item_id=$2 AND refunded + $3 <= quantity
+`, refund.OrderID, l.ItemID, l.Quantity)
+		if err != nil {
+			return Refund{}, fmt.Errorf("failed to refund line '%s' of order %d: %w", l.Item, refund.OrderID, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return Refund{}, fmt.Errorf("failed to refund line '%s' of order %d: %w", l.Item, refund.OrderID, sql.ErrNoRows)
+		}
+		_, err = tx.Exec(`
+INSERT INTO refund_lines (refund_id, item_id, item, name, quantity, price, discount, total)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`, refund.ID, l.ItemID, l.Item, l.Name, l.Quantity, l.Price, l.Discount, l.Total)
+		if err != nil {
+			return Refund{}, fmt.Errorf("failed to insert line '%s' of refund %d: %w", l.Item, refund.ID, err)
+		}
+	}
+	return refund, nil
+}
+
+func (o *orderDBImplementation) GetRefunds(orderID int) ([]Refund, error) {
+	rows, err := o.db.Query(`
+SELECT r.id, r.order_id, r.refunded_by, r.total, r.created_at,
+       rl.item_id, rl.item, rl.name, rl.quantity, rl.price, rl.discount, rl.total
+FROM refunds r
+JOIN refund_lines rl ON rl.refund_id = r.id
+WHERE r.order_id=$1
+ORDER BY r.id, rl.item
+`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunds of order %d: %w", orderID, err)
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+	for rows.Next() {
+		var r Refund
+		var l OrderLine
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.RefundedBy, &r.Total, &r.CreatedAt,
+			&l.ItemID, &l.Item, &l.Name, &l.Quantity, &l.Price, &l.Discount, &l.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan refund: %w", err)
+		}
+		if n := len(refunds); n == 0 || refunds[n-1].ID != r.ID {
+			refunds = append(refunds, r)
+		}
+		last := &refunds[len(refunds)-1]
+		last.Lines = append(last.Lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get refunds of order %d: %w", orderID, err)
+	}
+	return refunds, nil
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type promoDBImplementation struct {
+	db *sql.DB
+}
+
+func NewPromoDB(dbConn *sql.DB) PromoDB {
+	return &promoDBImplementation{
+		db: dbConn,
+	}
+}
+
+const promoCodeColumns = "p.id, p.code, p.kind, p.value, p.max_redemptions, p.max_per_user, p.redemptions, p.valid_from, p.valid_until, p.created_by, p.created_at"
+
+func scanPromoCode(row rowScanner, extra ...any) (PromoCode, error) {
+	var c PromoCode
+	dest := append([]any{&c.ID, &c.Code, &c.Kind, &c.Value, &c.MaxRedemptions, &c.MaxPerUser, &c.Redemptions,
+		&c.ValidFrom, &c.ValidUntil, &c.CreatedBy, &c.CreatedAt}, extra...)
+	err := row.Scan(dest...)
+	return c, err
+}
+
+func (p *promoDBImplementation) BeginTx() (*sql.Tx, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
+func (p *promoDBImplementation) InsertPromoCode(tx *sql.Tx, code PromoCode) (PromoCode, error) {
+	code.CreatedAt = time.Now().UTC()
+	err := tx.QueryRow(`
+INSERT INTO promo_codes (code, kind, value, max_redemptions, max_per_user, valid_from, valid_until, created_by, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (code) DO NOTHING
+RETURNING id
+`, code.Code, code.Kind, code.Value, code.MaxRedemptions, code.MaxPerUser, code.ValidFrom, code.ValidUntil,
+		code.CreatedBy, code.CreatedAt).Scan(&code.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PromoCode{}, ErrPromoCodeExists
+	}
+	if err != nil {
+		return PromoCode{}, fmt.Errorf("failed to insert promo code '%s': %w", code.Code, err)
+	}
+	for _, slug := range code.Items {
+		res, err := tx.Exec(`
+INSERT INTO promo_code_items (promo_code_id, item_id)
+SELECT $1, id FROM items WHERE slug=$2
+ON CONFLICT DO NOTHING
+`, code.ID, slug)
+		if err != nil {
+			return PromoCode{}, fmt.Errorf("failed to insert item '%s' of promo code '%s': %w", slug, code.Code, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return PromoCode{}, fmt.Errorf("%w: %s", ErrPromoItemNotFound, slug)
+		}
+	}
+	return code, nil
+}
+
+func (p *promoDBImplementation) ListPromoCodes() ([]PromoCode, error) {
+	rows, err := p.db.Query(`
+SELECT ` + promoCodeColumns + `, i.slug
+FROM promo_codes p
+LEFT JOIN promo_code_items pi ON pi.promo_code_id = p.id
+LEFT JOIN items i ON i.id = pi.item_id
+ORDER BY p.id, i.slug
+`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list promo codes: %w", err)
+	}
+	defer rows.Close()
+
+	var codes []PromoCode
+	for rows.Next() {
+		var slug sql.NullString
+		c, err := scanPromoCode(rows, &slug)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan promo code: %w", err)
+		}
+		if n := len(codes); n == 0 || codes[n-1].ID != c.ID {
+			codes = append(codes, c)
+		}
+		if slug.Valid {
+			last := &codes[len(codes)-1]
+			last.Items = append(last.Items, slug.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list promo codes: %w", err)
+	}
+	return codes, nil
+}
+
+func (p *promoDBImplementation) GetPromoCodeForUpdate(tx *sql.Tx, code string) (PromoCode, error) {
+	c, err := scanPromoCode(tx.QueryRow("SELECT "+promoCodeColumns+" FROM promo_codes p WHERE p.code=$1 FOR UPDATE", code))
+	if err != nil {
+		return PromoCode{}, fmt.Errorf("failed to get promo code '%s' for update: %w", code, err)
+	}
+
+	rows, err := tx.Query(`
+SELECT i.slug
+FROM promo_code_items pi
+JOIN items i ON i.id = pi.item_id
+WHERE pi.promo_code_id=$1
+ORDER BY i.slug
+`, c.ID)
+	if err != nil {
+		return PromoCode{}, fmt.Errorf("failed to get items of promo code '%s': %w", code, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return PromoCode{}, fmt.Errorf("failed to scan item of promo code '%s': %w", code, err)
+		}
+		c.Items = append(c.Items, slug)
+	}
+	if err := rows.Err(); err != nil {
+		return PromoCode{}, fmt.Errorf("failed to get items of promo code '%s': %w", code, err)
+	}
+	return c, nil
+}
+
+func (p *promoDBImplementation) CountRedemptions(tx *sql.Tx, promoCodeID, userID int) (int, error) {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM promo_redemptions WHERE promo_code_id=$1 AND user_id=$2", promoCodeID, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count redemptions of promo code %d by user %d: %w", promoCodeID, userID, err)
+	}
+	return n, nil
+}
+
+func (p *promoDBImplementation) InsertRedemption(tx *sql.Tx, promoCodeID, userID, orderID, discount int) error {
+	res, err := tx.Exec(`
+UPDATE promo_codes SET redemptions = redemptions + 1
+WHERE id=$1 AND (max_redemptions IS NULL OR redemptions < max_redemptions)
+`, promoCodeID)
+	if err != nil {
+		return fmt.Errorf("failed to redeem promo code %d: %w", promoCodeID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("promo code %d is used up: %w", promoCodeID, sql.ErrNoRows)
+	}
+	_, err = tx.Exec(`
+INSERT INTO promo_redemptions (promo_code_id, user_id, order_id, discount, created_at)
+VALUES ($1, $2, $3, $4, $5)
+`, promoCodeID, userID, orderID, discount, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert redemption of promo code %d: %w", promoCodeID, err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 )
 
@@ -25,17 +26,82 @@ func NewAuthDB(dbConn *sql.DB) AuthDB {
 	}
 }
 
-func (a *authDBImplementation) GetUserAuthData(username string) (int, string, error) {
-	var (
-		id           int
-		passwordHash string
-	)
-	err := a.db.QueryRow("SELECT id, password_hash FROM users WHERE username=$1", username).
-		Scan(&id, &passwordHash)
+func (a *authDBImplementation) GetUserAuthData(username string) (UserAuthData, error) {
+	var data UserAuthData
+	err := a.db.QueryRow("SELECT id, password_hash, role FROM users WHERE username=$1", username).
+		Scan(&data.ID, &data.PasswordHash, &data.Role)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to get user auth data for '%s': %w", username, err)
+		return UserAuthData{}, fmt.Errorf("failed to get user auth data for '%s': %w", username, err)
 	}
-	return id, passwordHash, nil
+	return data, nil
+}
+
+func (a *authDBImplementation) BeginTx() (*sql.Tx, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
+func (a *authDBImplementation) CreateUser(tx *sql.Tx, username, passwordHash string, coins int) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+INSERT INTO users (username, password_hash, coins)
+VALUES ($1, $2, 0)
+ON CONFLICT (username) DO NOTHING
+RETURNING id
+`, username, passwordHash).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUserExists
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create user '%s': %w", username, err)
+	}
+
+	if err := openUserAccount(tx, id); err != nil {
+		return 0, err
+	}
+	if coins == 0 {
+		return id, nil
+	}
+	_, err = postEntry(tx, JournalEntry{Kind: EntryGrant, Postings: []Posting{
+		{Account: IssuanceAccount, Amount: -coins},
+		{Account: UserAccount(id), Amount: coins},
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to grant coins to user '%s': %w", username, err)
+	}
+	return id, nil
+}
+
+func (a *authDBImplementation) UpdatePasswordHash(userID int, passwordHash string) error {
+	_, err := a.db.Exec("UPDATE users SET password_hash=$1 WHERE id=$2", passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password hash for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (a *authDBImplementation) GetUser(userID int) (User, error) {
+	var u User
+	err := a.db.QueryRow("SELECT id, username, role, coins FROM users WHERE id=$1", userID).
+		Scan(&u.ID, &u.Username, &u.Role, &u.Coins)
+	if err != nil {
+		return User{}, fmt.Errorf("failed to get user %d: %w", userID, err)
+	}
+	return u, nil
+}
+
+func (a *authDBImplementation) SetUserRole(userID int, role string) error {
+	res, err := a.db.Exec("UPDATE users SET role=$1 WHERE id=$2", role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set role for user %d: %w", userID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed to set role for user %d: %w", userID, sql.ErrNoRows)
+	}
+	return nil
 }
 
 func (c *coinInventoryDBImplementation) BeginTx() (*sql.Tx, error) {
@@ -55,43 +121,53 @@ func (c *coinInventoryDBImplementation) GetCoinsForUpdate(tx *sql.Tx, userID int
 	return coins, nil
 }
 
-func (c *coinInventoryDBImplementation) IncreaseCoins(tx *sql.Tx, userID int, amount int) error {
-	_, err := tx.Exec("UPDATE users SET coins = coins + $1 WHERE id=$2", amount, userID)
+func (c *coinInventoryDBImplementation) IncreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
+	_, err := tx.Exec(`
+INSERT INTO inventories (user_id, item_type, quantity)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, item_type) DO UPDATE SET quantity = inventories.quantity + EXCLUDED.quantity
+`, userID, item, delta)
 	if err != nil {
-		return fmt.Errorf("failed to increase coins: %w", err)
+		return fmt.Errorf("failed to add %d '%s' to user %d: %w", delta, item, userID, constraintError(err))
 	}
 	return nil
 }
 
-func (c *coinInventoryDBImplementation) DecreaseCoins(tx *sql.Tx, userID int, amount int) error {
-	_, err := tx.Exec("UPDATE users SET coins = coins - $1 WHERE id=$2", amount, userID)
+func (c *coinInventoryDBImplementation) DecreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
+	res, err := tx.Exec("UPDATE inventories SET quantity = quantity - $1 WHERE user_id=$2 AND item_type=$3 AND quantity >= $1",
+		delta, userID, item)
+	if err != nil {
+		return fmt.Errorf("failed to decrease item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed to decrease item '%s' of user %d: %w", item, userID, sql.ErrNoRows)
+	}
+	_, err = tx.Exec("DELETE FROM inventories WHERE user_id=$1 AND item_type=$2 AND quantity = 0", userID, item)
 	if err != nil {
-		return fmt.Errorf("failed to decrease coins: %w", err)
+		return fmt.Errorf("failed to delete empty item: %w", err)
 	}
 	return nil
 }
 
-func (c *coinInventoryDBImplementation) IncreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
-	res, err := tx.Exec("UPDATE inventories SET quantity = quantity + $1 WHERE user_id=$2 AND item_type=$3", delta, userID, item)
-	if err != nil {
-		return fmt.Errorf("failed to update item: %w", err)
+func (c *coinInventoryDBImplementation) GetItemQuantity(tx *sql.Tx, userID int, item string) (int, error) {
+	var quantity int
+	err := tx.QueryRow("SELECT quantity FROM inventories WHERE user_id=$1 AND item_type=$2", userID, item).Scan(&quantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
 	}
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		_, err = tx.Exec("INSERT INTO inventories (user_id, item_type, quantity) VALUES ($1, $2, $3)",
-			userID, item, delta)
-		if err != nil {
-			return fmt.Errorf("failed to insert new item: %w", err)
-		}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get quantity of '%s' owned by user %d: %w", item, userID, err)
 	}
-	return nil
+	return quantity, nil
 }
 
-func (c *coinInventoryDBImplementation) InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int) error {
-	_, err := tx.Exec("INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount) VALUES ($1, $2, $3, $4)",
-		userID, transactionType, counterparty, amount)
+func (c *coinInventoryDBImplementation) InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int, note string) error {
+	_, err := tx.Exec(`
+INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount, note)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+`, userID, transactionType, counterparty, amount, note)
 	if err != nil {
-		return fmt.Errorf("failed to insert transaction: %w", err)
+		return fmt.Errorf("failed to insert transaction: %w", constraintError(err))
 	}
 	return nil
 }
@@ -105,13 +181,22 @@ func (c *coinInventoryDBImplementation) GetUserIDByUsernameForUpdate(tx *sql.Tx,
 	return userID, nil
 }
 
-func (c *coinInventoryDBImplementation) InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int) error {
+func (c *coinInventoryDBImplementation) GetUserIDByUsername(tx *sql.Tx, username string) (int, error) {
+	var userID int
+	err := tx.QueryRow("SELECT id FROM users WHERE username=$1", username).Scan(&userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find user by username %q: %w", username, err)
+	}
+	return userID, nil
+}
+
+func (c *coinInventoryDBImplementation) InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int, note string) error {
 	_, err := tx.Exec(`
-INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount) 
-VALUES ($1, 'received', (SELECT username FROM users WHERE id=$2), $3)
-`, toUserID, fromUserID, amount)
+INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount, note)
+VALUES ($1, 'received', (SELECT username FROM users WHERE id=$2), $3, NULLIF($4, ''))
+`, toUserID, fromUserID, amount, note)
 	if err != nil {
-		return fmt.Errorf("failed to insert received transaction: %w", err)
+		return fmt.Errorf("failed to insert received transaction: %w", constraintError(err))
 	}
 	return nil
 }
@@ -142,24 +227,46 @@ func (c *coinInventoryDBImplementation) GetInventory(userID int) ([]InventoryIte
 	return items, nil
 }
 
-func (c *coinInventoryDBImplementation) GetTransactions(userID int, transactionType string) ([]Transaction, error) {
+const transactionColumns = "id, transaction_type, counterparty, amount, note, created_at"
+
+func (c *coinInventoryDBImplementation) GetCoinHistory(userID int, filter HistoryFilter, cursor HistoryCursor, limit int) ([]Transaction, error) {
+	// the row comparison on the cursor keeps to the (user_id, created_at, id)
+	// index, however deep the page
 	rows, err := c.db.Query(`
-        SELECT counterparty, amount
-        FROM coin_transactions
-        WHERE user_id=$1 AND transaction_type=$2
-    `, userID, transactionType)
+SELECT `+transactionColumns+`
+FROM coin_transactions
+WHERE user_id=$1
+  AND ($2::VARCHAR = '' OR transaction_type = $2)
+  AND ($3::VARCHAR = '' OR counterparty = $3)
+  AND ($4::INT IS NULL OR amount >= $4)
+  AND ($5::INT IS NULL OR amount <= $5)
+  AND ($6::TIMESTAMPTZ IS NULL OR created_at >= $6)
+  AND ($7::TIMESTAMPTZ IS NULL OR created_at < $7)
+  AND ($8::INT = 0 OR (created_at, id) < ($9::TIMESTAMPTZ, $8))
+ORDER BY created_at DESC, id DESC
+LIMIT $10
+`, userID, filter.Type, filter.Counterparty, filter.MinAmount, filter.MaxAmount, filter.From, filter.To,
+		cursor.ID, cursor.CreatedAt, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query %s transactions: %w", transactionType, err)
+		return nil, fmt.Errorf("failed to query coin history of user %d: %w", userID, err)
 	}
+	return scanTransactions(rows)
+}
+
+func scanTransactions(rows *sql.Rows) ([]Transaction, error) {
 	defer rows.Close()
 
 	var trans []Transaction
 	for rows.Next() {
 		var t Transaction
-		if e2 := rows.Scan(&t.Counterparty, &t.Amount); e2 != nil {
-			continue
+		if err := rows.Scan(&t.ID, &t.Type, &t.Counterparty, &t.Amount, &t.Note, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
 		}
+		t.CreatedAt = t.CreatedAt.UTC()
 		trans = append(trans, t)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transactions: %w", err)
+	}
 	return trans, nil
 }
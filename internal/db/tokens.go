@@ -0,0 +1,144 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type revocationDBImplementation struct {
+	db *sql.DB
+}
+
+func NewRevocationDB(dbConn *sql.DB) RevocationDB {
+	return &revocationDBImplementation{
+		db: dbConn,
+	}
+}
+
+type refreshTokenDBImplementation struct {
+	db *sql.DB
+}
+
+func NewRefreshTokenDB(dbConn *sql.DB) RefreshTokenDB {
+	return &refreshTokenDBImplementation{
+		db: dbConn,
+	}
+}
+
+func (r *refreshTokenDBImplementation) BeginTx() (*sql.Tx, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
+func (r *refreshTokenDBImplementation) InsertRefreshToken(tx *sql.Tx, userID int, familyID, tokenHash string, expiresAt time.Time) error {
+	_, err := tx.Exec("INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, familyID, tokenHash, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+	return nil
+}
+
+func (r *refreshTokenDBImplementation) GetRefreshTokenForUpdate(tx *sql.Tx, tokenHash string) (RefreshToken, error) {
+	var rt RefreshToken
+	err := tx.QueryRow(`
+SELECT rt.id, rt.user_id, u.username, u.role, rt.family_id, rt.expires_at, rt.used_at, rt.revoked_at
+FROM refresh_tokens rt
+JOIN users u ON u.id = rt.user_id
+WHERE rt.token_hash=$1
+FOR UPDATE OF rt
+`, tokenHash).Scan(&rt.ID, &rt.UserID, &rt.Username, &rt.Role, &rt.FamilyID, &rt.ExpiresAt, &rt.UsedAt, &rt.RevokedAt)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	return rt, nil
+}
+
+func (r *refreshTokenDBImplementation) MarkRefreshTokenUsed(tx *sql.Tx, id int) error {
+	_, err := tx.Exec("UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id=$1", id)
+	if err != nil {
+		return fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	return nil
+}
+
+func (r *refreshTokenDBImplementation) RevokeRefreshTokenFamily(tx *sql.Tx, familyID string) error {
+	_, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id=$1 AND revoked_at IS NULL", familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+func (r *refreshTokenDBImplementation) RevokeUserRefreshTokens(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id=$1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func (r *revocationDBImplementation) BeginTx() (*sql.Tx, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
+func (r *revocationDBImplementation) InsertRevokedToken(jti string, userID int, expiresAt time.Time) error {
+	_, err := r.db.Exec(`
+INSERT INTO revoked_tokens (jti, user_id, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (jti) DO NOTHING
+`, jti, userID, expiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert revoked token: %w", err)
+	}
+	return nil
+}
+
+func (r *revocationDBImplementation) IsTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti=$1)", jti).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+func (r *revocationDBImplementation) DeleteExpiredRevokedTokens(before time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM revoked_tokens WHERE expires_at < $1", before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revoked tokens: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revoked tokens: %w", err)
+	}
+	return n, nil
+}
+
+func (r *revocationDBImplementation) SetSessionsRevokedAt(tx *sql.Tx, userID int, revokedAt time.Time) error {
+	res, err := tx.Exec("UPDATE users SET sessions_revoked_at=$1 WHERE id=$2", revokedAt.UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions of user %d: %w", userID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed to revoke sessions of user %d: %w", userID, sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (r *revocationDBImplementation) GetSessionsRevokedAt(userID int) (sql.NullTime, error) {
+	var revokedAt sql.NullTime
+	err := r.db.QueryRow("SELECT sessions_revoked_at FROM users WHERE id=$1", userID).Scan(&revokedAt)
+	if err != nil {
+		return sql.NullTime{}, fmt.Errorf("failed to get sessions revocation of user %d: %w", userID, err)
+	}
+	return revokedAt, nil
+}
@@ -0,0 +1,277 @@
+// Package jwtkeys holds the asymmetric keys used to sign and verify access
+// tokens and publishes the verification keys as a JSON Web Key Set.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnknownKey         = errors.New("unknown signing key")
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+)
+
+// minRSABits rejects keys too weak for RS256.
+const minRSABits = 2048
+
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+}
+
+// KeySet signs tokens with one key and verifies them with any of the active
+// keys, so that tokens signed before a rotation stay valid until they expire.
+type KeySet struct {
+	signingKey  Key
+	private     crypto.PrivateKey
+	verifyKeys  map[string]Key
+	verifyOrder []string
+}
+
+// Load reads the PEM encoded signing key and any number of extra verification
+// keys (public keys or private keys of retired signing keys).
+func Load(signingKeyFile string, verificationKeyFiles []string) (*KeySet, error) {
+	block, err := readPEM(signingKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	private, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", signingKeyFile, err)
+	}
+	ks, err := New(private)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", signingKeyFile, err)
+	}
+
+	for _, file := range verificationKeyFiles {
+		block, err := readPEM(file)
+		if err != nil {
+			return nil, err
+		}
+		public, err := parsePublicKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse verification key %s: %w", file, err)
+		}
+		if err := ks.AddVerificationKey(public); err != nil {
+			return nil, fmt.Errorf("invalid verification key %s: %w", file, err)
+		}
+	}
+	return ks, nil
+}
+
+// Generate creates a key set with a fresh Ed25519 key. Tokens signed with it do
+// not survive a restart, so it is meant for development and tests.
+func Generate() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return New(private)
+}
+
+// New builds a key set signing with private, an *rsa.PrivateKey or ed25519.PrivateKey.
+func New(private crypto.PrivateKey) (*KeySet, error) {
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	key, err := newKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{
+		signingKey:  key,
+		private:     private,
+		verifyKeys:  map[string]Key{key.ID: key},
+		verifyOrder: []string{key.ID},
+	}, nil
+}
+
+func (ks *KeySet) AddVerificationKey(public crypto.PublicKey) error {
+	key, err := newKey(public)
+	if err != nil {
+		return err
+	}
+	if _, ok := ks.verifyKeys[key.ID]; ok {
+		return nil
+	}
+	ks.verifyKeys[key.ID] = key
+	ks.verifyOrder = append(ks.verifyOrder, key.ID)
+	return nil
+}
+
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signingKey.ID
+}
+
+// Sign creates a token with the kid header of the signing key.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signingKey.Method, claims)
+	token.Header["kid"] = ks.signingKey.ID
+	return token.SignedString(ks.private)
+}
+
+// Keyfunc resolves the verification key by the kid header. The algorithm must
+// match the key type, so a public key can never be used as an HMAC secret.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.verifyKeys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return key.Public, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every verification key, the signing key first.
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(ks.verifyOrder))}
+	for _, kid := range ks.verifyOrder {
+		key := ks.verifyKeys[kid]
+		jwk := publicJWK(key.Public)
+		jwk.Kid = key.ID
+		jwk.Use = "sig"
+		jwk.Alg = key.Method.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func newKey(public crypto.PublicKey) (Key, error) {
+	var method jwt.SigningMethod
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return Key{}, fmt.Errorf("RSA key must be at least %d bits", minRSABits)
+		}
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return Key{}, ErrUnsupportedKeyType
+	}
+	kid, err := thumbprint(publicJWK(public))
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{ID: kid, Method: method, Public: public}, nil
+}
+
+func publicJWK(public crypto.PublicKey) JWK {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	}
+	return JWK{}
+}
+
+// thumbprint is the RFC 7638 key ID: a hash over the required public members
+// of the JWK in lexicographic order, so every instance derives the same kid.
+func thumbprint(jwk JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", ErrUnsupportedKeyType
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	return block, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("%w: PEM block %q", ErrUnsupportedKeyType, block.Type)
+}
+
+func parsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	private, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	return signer.Public(), nil
+}
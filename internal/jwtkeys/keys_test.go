@@ -0,0 +1,161 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return path
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestLoad_RSA(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	file := writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(private))
+
+	ks, err := Load(file, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	parsed, err := jwt.Parse(token, ks.Keyfunc)
+	if err != nil || !parsed.Valid {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if parsed.Method.Alg() != "RS256" || parsed.Header["kid"] != ks.SigningKeyID() {
+		t.Errorf("unexpected header: %v", parsed.Header)
+	}
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != "RSA" || jwks.Keys[0].E != "AQAB" || jwks.Keys[0].N == "" {
+		t.Errorf("unexpected JWKS: %+v", jwks)
+	}
+}
+
+func TestLoad_Ed25519(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	ks, err := Load(writePEM(t, "ed.pem", "PRIVATE KEY", der), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	parsed, err := jwt.Parse(token, ks.Keyfunc)
+	if err != nil || parsed.Method.Alg() != "EdDSA" {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if jwk := ks.JWKS().Keys[0]; jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Kid != ks.SigningKeyID() {
+		t.Errorf("unexpected JWK: %+v", jwk)
+	}
+
+	again, err := New(private)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again.SigningKeyID() != ks.SigningKeyID() {
+		t.Errorf("expected kid to be derived from the key")
+	}
+}
+
+func TestLoad_Rotation(t *testing.T) {
+	oldKeys, err := Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	oldToken, err := oldKeys.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	oldPublic, err := x509.MarshalPKIXPublicKey(oldKeys.signingKey.Public)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	ks, err := Load(writePEM(t, "new.pem", "PRIVATE KEY", der), []string{writePEM(t, "old.pem", "PUBLIC KEY", oldPublic)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := jwt.Parse(oldToken, ks.Keyfunc); err != nil {
+		t.Errorf("expected token signed by the retired key to verify: %v", err)
+	}
+	newToken, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if _, err := jwt.Parse(newToken, oldKeys.Keyfunc); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey from the old key set, got %v", err)
+	}
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != ks.SigningKeyID() || jwks.Keys[1].Kid != oldKeys.SigningKeyID() {
+		t.Errorf("unexpected JWKS: %+v", jwks)
+	}
+}
+
+func TestNew_RejectsWeakRSA(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if _, err := New(private); err == nil {
+		t.Errorf("expected error for 1024 bit key")
+	}
+}
+
+func TestKeyfunc_AlgorithmMismatch(t *testing.T) {
+	ks, err := Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	token.Header["kid"] = ks.SigningKeyID()
+	signed, err := token.SignedString([]byte(ks.signingKey.Public.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if _, err := jwt.Parse(signed, ks.Keyfunc); err == nil {
+		t.Errorf("expected HS256 token to be rejected")
+	}
+}
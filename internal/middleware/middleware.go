@@ -3,15 +3,115 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
+	"avito-shop/internal/token"
 	"avito-shop/pkg"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
 )
 
-func JWTAuthMiddleware(secret string, log pkg.Logger) echo.MiddlewareFunc {
+type RevocationChecker interface {
+	IsRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error)
+}
+
+type JWTConfig struct {
+	// Keyfunc resolves the key a token was signed with.
+	Keyfunc jwt.Keyfunc
+	// Expectations are the issuer, audience and clock skew tokens are checked against.
+	Expectations token.Expectations
+	// Skipper lets public routes through without a token; nil protects every route.
+	Skipper Skipper
+	// Revocations rejects logged out tokens; nil disables the check.
+	Revocations RevocationChecker
+}
+
+// Skipper reports whether the middleware should let the request through untouched.
+type Skipper func(c echo.Context) bool
+
+// PublicRoutes skips requests whose matched route is one of patterns. A pattern
+// ending with "*" matches every route under that prefix, e.g. "/docs/*".
+func PublicRoutes(patterns ...string) Skipper {
+	return func(c echo.Context) bool {
+		route := c.Path()
+		for _, p := range patterns {
+			if matchRoute(p, route) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func matchRoute(pattern, route string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(route, prefix)
+	}
+	return route == pattern
+}
+
+// RoutePolicy allows the routes matching Route, written as for PublicRoutes,
+// only to users having one of Roles. Empty Methods match every method.
+type RoutePolicy struct {
+	Route   string
+	Methods []string
+	Roles   []string
+}
+
+func (p RoutePolicy) matches(c echo.Context) bool {
+	if !matchRoute(p.Route, c.Path()) {
+		return false
+	}
+	if len(p.Methods) == 0 {
+		return true
+	}
+	for _, m := range p.Methods {
+		if m == c.Request().Method {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks the roles of the authenticated user against the first
+// policy matching the request. Requests no policy matches are let through, so
+// it must run after JWTAuthMiddleware.
+func Authorize(policies []RoutePolicy, log pkg.Logger) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			for _, p := range policies {
+				if !p.matches(c) {
+					continue
+				}
+				claims, ok := token.FromContext(c)
+				if !ok {
+					return c.JSON(http.StatusUnauthorized, map[string]string{"errors": "Unauthorized"})
+				}
+				for _, role := range p.Roles {
+					if claims.HasRole(role) {
+						return next(c)
+					}
+				}
+				log.Warn("Access denied", zap.Int("userID", claims.UserID), zap.String("route", c.Path()), zap.Strings("roles", claims.Roles))
+				return c.JSON(http.StatusForbidden, map[string]string{"errors": "Forbidden"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// JWTAuthMiddleware validates the bearer token and stores its claims in the
+// context, see token.FromContext.
+func JWTAuthMiddleware(cfg JWTConfig, log pkg.Logger) echo.MiddlewareFunc {
+	// exp, iat and nbf are checked by Claims.Validate with the configured leeway
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Skipper != nil && cfg.Skipper(c) {
+				return next(c)
+			}
+
 			authHeader := c.Request().Header.Get("Authorization")
 			if authHeader == "" {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"errors": "Authorization header missing"})
@@ -19,17 +119,27 @@ func JWTAuthMiddleware(secret string, log pkg.Logger) echo.MiddlewareFunc {
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 			// проверка подмены токена, сделал я это для безопасности сервиса и защиты от подмены
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, jwt.ErrSignatureInvalid
-				}
-				return []byte(secret), nil
-			})
-			if err != nil || !token.Valid {
-				log.Warn("Invalid JWT token")
+			claims := &token.Claims{}
+			parsed, err := parser.ParseWithClaims(tokenString, claims, cfg.Keyfunc)
+			if err == nil && parsed.Valid {
+				err = claims.Validate(cfg.Expectations, time.Now())
+			}
+			if err != nil {
+				log.Warn("Invalid JWT token", zap.Error(err))
 				return c.JSON(http.StatusUnauthorized, map[string]string{"errors": "Invalid token"})
 			}
-			c.Set("user", token.Claims)
+			if cfg.Revocations != nil {
+				revoked, err := cfg.Revocations.IsRevoked(claims.ID, claims.UserID, claims.IssuedAt.Time)
+				if err != nil {
+					log.Error("failed to check token revocation", zap.Error(err))
+					return c.JSON(http.StatusInternalServerError, map[string]string{"errors": "Internal server error"})
+				}
+				if revoked {
+					log.Warn("Revoked JWT token", zap.Int("userID", claims.UserID))
+					return c.JSON(http.StatusUnauthorized, map[string]string{"errors": "Token revoked"})
+				}
+			}
+			token.ToContext(c, claims)
 			return next(c)
 		}
 	}
@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"avito-shop/internal/jwtkeys"
+	"avito-shop/internal/token"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestPublicRoutes(t *testing.T) {
+	skipper := PublicRoutes("/api/auth", "/docs/*")
+	cases := map[string]bool{
+		"/api/auth":       true,
+		"/api/auth/other": false,
+		"/docs/":          true,
+		"/docs/index":     true,
+		"/api/info":       false,
+		"":                false,
+	}
+	e := echo.New()
+	for route, want := range cases {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		c.SetPath(route)
+		if got := skipper(c); got != want {
+			t.Errorf("route %q: expected skip=%v, got %v", route, want, got)
+		}
+	}
+}
+
+func TestJWTAuthMiddleware_SkipsPublicRoutes(t *testing.T) {
+	e := echo.New()
+	keys, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	e.Use(JWTAuthMiddleware(JWTConfig{Keyfunc: keys.Keyfunc, Skipper: PublicRoutes("/public")}, zap.NewNop()))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/public", ok)
+	e.GET("/private", ok)
+
+	for path, want := range map[string]int{"/public": http.StatusOK, "/private": http.StatusUnauthorized} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, rec.Code)
+		}
+	}
+}
+
+type mockRevocations map[string]bool
+
+func (m mockRevocations) IsRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error) {
+	return m[tokenID], nil
+}
+
+var testExpectations = token.Expectations{Issuer: "avito-shop", Audience: "avito-shop"}
+
+func testClaims(tokenID string) *token.Claims {
+	now := time.Now()
+	return &token.Claims{
+		UserID: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    testExpectations.Issuer,
+			Audience:  jwt.ClaimStrings{testExpectations.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func signTestToken(t *testing.T, keys *jwtkeys.KeySet, claims jwt.Claims) string {
+	t.Helper()
+	signed, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestJWTAuthMiddleware_RejectsRevokedTokens(t *testing.T) {
+	keys, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	e := echo.New()
+	e.Use(JWTAuthMiddleware(JWTConfig{
+		Keyfunc:      keys.Keyfunc,
+		Expectations: testExpectations,
+		Revocations:  mockRevocations{"revoked": true},
+	}, zap.NewNop()))
+	e.GET("/private", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	cases := map[string]struct {
+		claims *token.Claims
+		want   int
+	}{
+		"valid":   {testClaims("ok"), http.StatusOK},
+		"revoked": {testClaims("revoked"), http.StatusUnauthorized},
+		"no jti":  {testClaims(""), http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/private", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(t, keys, tc.claims))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected status %d, got %d", name, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestJWTAuthMiddleware_ValidatesClaims(t *testing.T) {
+	keys, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	e := echo.New()
+	e.Use(JWTAuthMiddleware(JWTConfig{Keyfunc: keys.Keyfunc, Expectations: testExpectations}, zap.NewNop()))
+	e.GET("/private", func(c echo.Context) error {
+		claims, ok := token.FromContext(c)
+		if !ok || claims.UserID != 1 {
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	wrongIssuer := testClaims("iss")
+	wrongIssuer.Issuer = "someone-else"
+	wrongAudience := testClaims("aud")
+	wrongAudience.Audience = jwt.ClaimStrings{"another-service"}
+	expired := testClaims("exp")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	cases := map[string]struct {
+		claims *token.Claims
+		want   int
+	}{
+		"valid":          {testClaims("ok"), http.StatusOK},
+		"wrong issuer":   {wrongIssuer, http.StatusUnauthorized},
+		"wrong audience": {wrongAudience, http.StatusUnauthorized},
+		"expired":        {expired, http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/private", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(t, keys, tc.claims))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected status %d, got %d", name, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestJWTAuthMiddleware_RejectsForeignKeys(t *testing.T) {
+	keys, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	other, err := jwtkeys.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	e := echo.New()
+	e.Use(JWTAuthMiddleware(JWTConfig{Keyfunc: keys.Keyfunc, Expectations: testExpectations}, zap.NewNop()))
+	e.GET("/private", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	claims := testClaims("ok")
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = keys.SigningKeyID()
+	hmacToken, err := hmac.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	for name, signed := range map[string]string{
+		"own key":     signTestToken(t, keys, claims),
+		"foreign key": signTestToken(t, other, claims),
+		"hmac":        hmacToken,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/private", nil)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		want := http.StatusUnauthorized
+		if name == "own key" {
+			want = http.StatusOK
+		}
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d", name, want, rec.Code)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	policies := []RoutePolicy{
+		{Route: "/admin/*", Methods: []string{http.MethodGet}, Roles: []string{"admin", "auditor"}},
+		{Route: "/admin/*", Roles: []string{"admin"}},
+	}
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if role := c.Request().Header.Get("X-Role"); role != "" {
+				token.ToContext(c, &token.Claims{UserID: 1, Roles: []string{role}})
+			}
+			return next(c)
+		}
+	})
+	e.Use(Authorize(policies, zap.NewNop()))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/admin/users", ok)
+	e.POST("/admin/users", ok)
+	e.GET("/public", ok)
+
+	cases := []struct {
+		method, path, role string
+		want               int
+	}{
+		{http.MethodGet, "/admin/users", "admin", http.StatusOK},
+		{http.MethodGet, "/admin/users", "auditor", http.StatusOK},
+		{http.MethodGet, "/admin/users", "employee", http.StatusForbidden},
+		{http.MethodPost, "/admin/users", "admin", http.StatusOK},
+		{http.MethodPost, "/admin/users", "auditor", http.StatusForbidden},
+		{http.MethodPost, "/admin/users", "", http.StatusUnauthorized},
+		{http.MethodGet, "/public", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.role != "" {
+			req.Header.Set("X-Role", tc.role)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s as %q: expected status %d, got %d", tc.method, tc.path, tc.role, tc.want, rec.Code)
+		}
+	}
+}
@@ -2,58 +2,408 @@ package service
 
 import (
 	"avito-shop/internal/db"
+	"avito-shop/internal/token"
 	"avito-shop/pkg"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"go.uber.org/zap"
 )
 
+// InitialCoins is the balance granted to a user on registration.
+const InitialCoins = 1000
+
+var (
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrInvalidRole         = errors.New("invalid role")
+)
+
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+type User struct {
+	ID       int
+	Username string
+	Role     string
+	Coins    int
+}
+
+type TokenSigner interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+type TokenConfig struct {
+	Signer     TokenSigner
+	Issuer     string
+	Audience   string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
 type AuthService interface {
-	Authenticate(username, password string) (string, error)
+	// Authenticate issues tokens for the user, registering the username on first login.
+	// Repeated failures from the username or the client IP are throttled, see
+	// LoginThrottle.
+	Authenticate(username, password, clientIP string) (Tokens, error)
+
+	// Refresh exchanges a refresh token for a new token pair. Every refresh token
+	// can be used once; presenting it again revokes all tokens descended from
+	// the same login.
+	Refresh(refreshToken string) (Tokens, error)
+
+	// Logout revokes the access token and, if given, the refresh token family
+	// it was issued with.
+	Logout(userID int, tokenID string, expiresAt time.Time, refreshToken string) error
+
+	// RevokeAllSessions signs the user out everywhere.
+	RevokeAllSessions(userID int) error
+
+	GetUser(userID int) (User, error)
+
+	// UnlockUser lifts a login lockout of the user.
+	UnlockUser(userID int) error
+
+	// SetRole changes the role of the user and revokes their sessions, so that
+	// tokens carrying the old role stop working right away.
+	SetRole(userID int, role string) error
 }
 
 type authService struct {
-	authDB    db.AuthDB
-	log       pkg.Logger
-	jwtSecret string
+	authDB      db.AuthDB
+	tokenDB     db.RefreshTokenDB
+	revocations RevocationStore
+	throttle    LoginThrottle
+	hasher      PasswordHasher
+	log         pkg.Logger
+	cfg         TokenConfig
 }
 
-func NewAuthService(authDB db.AuthDB, logger pkg.Logger, jwtSecret string) AuthService {
+func NewAuthService(
+	authDB db.AuthDB,
+	tokenDB db.RefreshTokenDB,
+	revocations RevocationStore,
+	throttle LoginThrottle,
+	hasher PasswordHasher,
+	logger pkg.Logger,
+	cfg TokenConfig,
+) AuthService {
 	return &authService{
-		authDB:    authDB,
-		log:       logger,
-		jwtSecret: jwtSecret,
+		authDB:      authDB,
+		tokenDB:     tokenDB,
+		revocations: revocations,
+		throttle:    throttle,
+		hasher:      hasher,
+		log:         logger,
+		cfg:         cfg,
 	}
 }
 
-func (s *authService) Authenticate(username, password string) (string, error) {
-	if s.jwtSecret == "" {
-		s.log.Error("auth: empty JWT secret key")
-		return "", errors.New("could not generate token: empty secret key")
+func (s *authService) Authenticate(username, password, clientIP string) (Tokens, error) {
+	if s.cfg.Signer == nil {
+		s.log.Error("auth: no JWT signing key")
+		return Tokens{}, errors.New("could not generate token: no signing key")
+	}
+	if err := s.throttle.Allow(username, clientIP); err != nil {
+		return Tokens{}, err
+	}
+	user, err := s.login(username, password)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = s.register(username, password)
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		s.throttle.Failure(username, clientIP)
 	}
-	id, passHash, err := s.authDB.GetUserAuthData(username)
 	if err != nil {
-		s.log.Warn("invalid credentials", zap.String("username", username), zap.Error(err))
-		return "", fmt.Errorf("invalid credentials: %w", err)
+		return Tokens{}, err
 	}
-	if passHash != password {
-		s.log.Warn("invalid credentials: password mismatch", zap.String("username", username))
-		return "", fmt.Errorf("invalid credentials: password mismatch")
+	s.throttle.Success(username)
+
+	familyID, err := randomToken()
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not generate token family: %w", err)
+	}
+	tx, err := s.tokenDB.BeginTx()
+	if err != nil {
+		return Tokens{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	tokens, err := s.issueTokens(tx, user.ID, username, user.Role, familyID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit refresh token", zap.String("username", username), zap.Error(err))
+		return Tokens{}, err
 	}
+	s.log.Info("User authenticated", zap.Int("userID", user.ID), zap.String("username", username))
+	return tokens, nil
+}
+
+func (s *authService) Refresh(refreshToken string) (Tokens, error) {
+	tx, err := s.tokenDB.BeginTx()
+	if err != nil {
+		return Tokens{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rt, err := s.tokenDB.GetRefreshTokenForUpdate(tx, hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		s.log.Warn("unknown refresh token")
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		s.log.Error("failed to get refresh token", zap.Error(err))
+		return Tokens{}, err
+	}
+	if rt.RevokedAt.Valid || time.Now().After(rt.ExpiresAt) {
+		s.log.Warn("revoked or expired refresh token", zap.Int("userID", rt.UserID))
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+	if rt.UsedAt.Valid {
+		// the token was already exchanged, so someone else holds a copy of it
+		if err := s.tokenDB.RevokeRefreshTokenFamily(tx, rt.FamilyID); err != nil {
+			s.log.Error("failed to revoke refresh token family", zap.Int("userID", rt.UserID), zap.Error(err))
+			return Tokens{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			s.log.Error("failed to commit refresh token family revocation", zap.Error(err))
+			return Tokens{}, err
+		}
+		s.log.Warn("refresh token reuse detected, family revoked", zap.Int("userID", rt.UserID))
+		return Tokens{}, ErrRefreshTokenReused
+	}
+
+	if err := s.tokenDB.MarkRefreshTokenUsed(tx, rt.ID); err != nil {
+		s.log.Error("failed to mark refresh token used", zap.Int("userID", rt.UserID), zap.Error(err))
+		return Tokens{}, err
+	}
+	tokens, err := s.issueTokens(tx, rt.UserID, rt.Username, rt.Role, rt.FamilyID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit token refresh", zap.Int("userID", rt.UserID), zap.Error(err))
+		return Tokens{}, err
+	}
+	s.log.Info("Tokens refreshed", zap.Int("userID", rt.UserID))
+	return tokens, nil
+}
+
+func (s *authService) Logout(userID int, tokenID string, expiresAt time.Time, refreshToken string) error {
+	if refreshToken != "" {
+		if err := s.revokeRefreshFamily(userID, refreshToken); err != nil {
+			return err
+		}
+	}
+	if err := s.revocations.RevokeToken(tokenID, userID, expiresAt); err != nil {
+		return err
+	}
+	s.log.Info("User logged out", zap.Int("userID", userID))
+	return nil
+}
+
+func (s *authService) RevokeAllSessions(userID int) error {
+	err := s.revocations.RevokeUser(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (s *authService) GetUser(userID int) (User, error) {
+	u, err := s.authDB.GetUser(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get user", zap.Int("userID", userID), zap.Error(err))
+		return User{}, err
+	}
+	return User{ID: u.ID, Username: u.Username, Role: u.Role, Coins: u.Coins}, nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  id,
-		"username": username,
-		"exp":      time.Now().Add(1 * time.Hour).Unix(),
+func (s *authService) UnlockUser(userID int) error {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	return s.throttle.Unlock(user.Username)
+}
+
+func (s *authService) SetRole(userID int, role string) error {
+	if !token.IsValidRole(role) {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	err := s.authDB.SetUserRole(userID, role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to set role", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	if err := s.revocations.RevokeUser(userID); err != nil {
+		return err
+	}
+	s.log.Info("User role changed", zap.Int("userID", userID), zap.String("role", role))
+	return nil
+}
+
+func (s *authService) revokeRefreshFamily(userID int, refreshToken string) error {
+	tx, err := s.tokenDB.BeginTx()
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rt, err := s.tokenDB.GetRefreshTokenForUpdate(tx, hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && rt.UserID != userID) {
+		s.log.Warn("logout with foreign or unknown refresh token", zap.Int("userID", userID))
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		s.log.Error("failed to get refresh token", zap.Error(err))
+		return err
+	}
+	if err := s.tokenDB.RevokeRefreshTokenFamily(tx, rt.FamilyID); err != nil {
+		s.log.Error("failed to revoke refresh token family", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	return tx.Commit()
+}
+
+// issueTokens signs an access token and stores a new refresh token of the family.
+func (s *authService) issueTokens(tx *sql.Tx, userID int, username, role, familyID string) (Tokens, error) {
+	tokenID, err := randomToken()
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not generate token id: %w", err)
+	}
+	now := time.Now()
+	accessToken, err := s.cfg.Signer.Sign(&token.Claims{
+		UserID:   userID,
+		Username: username,
+		Roles:    []string{role},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    s.cfg.Issuer,
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{s.cfg.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.AccessTTL)),
+		},
 	})
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
 	if err != nil {
 		s.log.Error("failed to generate token", zap.String("username", username), zap.Error(err))
-		return "", fmt.Errorf("could not generate token: %w", err)
+		return Tokens{}, fmt.Errorf("could not generate token: %w", err)
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not generate refresh token: %w", err)
+	}
+	expiresAt := time.Now().Add(s.cfg.RefreshTTL)
+	if err := s.tokenDB.InsertRefreshToken(tx, userID, familyID, hashToken(refreshToken), expiresAt); err != nil {
+		s.log.Error("failed to store refresh token", zap.Int("userID", userID), zap.Error(err))
+		return Tokens{}, err
+	}
+	return Tokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// login checks the password of an existing user. A missing user is reported
+// as sql.ErrNoRows so that the caller can register it.
+func (s *authService) login(username, password string) (db.UserAuthData, error) {
+	user, err := s.authDB.GetUserAuthData(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.UserAuthData{}, err
+	}
+	if err != nil {
+		s.log.Error("failed to get user auth data", zap.String("username", username), zap.Error(err))
+		return db.UserAuthData{}, fmt.Errorf("failed to get user auth data: %w", err)
+	}
+	match, needsRehash := s.hasher.Verify(user.PasswordHash, password)
+	if !match {
+		s.log.Warn("invalid credentials: password mismatch", zap.String("username", username))
+		return db.UserAuthData{}, fmt.Errorf("%w: password mismatch", ErrInvalidCredentials)
+	}
+	if needsRehash {
+		s.rehash(user.ID, password)
+	}
+	return user, nil
+}
+
+// rehash upgrades a stored password hash after a successful login. Failures are
+// only logged: the user is already authenticated and the next login retries.
+func (s *authService) rehash(userID int, password string) {
+	newHash, err := s.hasher.Hash(password)
+	if err != nil {
+		s.log.Error("failed to rehash password", zap.Int("userID", userID), zap.Error(err))
+		return
+	}
+	if err := s.authDB.UpdatePasswordHash(userID, newHash); err != nil {
+		s.log.Error("failed to store rehashed password", zap.Int("userID", userID), zap.Error(err))
+		return
+	}
+	s.log.Info("Password hash upgraded", zap.Int("userID", userID))
+}
+
+// register creates a new user with InitialCoins. If another request registered
+// the same username first, the password is checked against that account instead.
+func (s *authService) register(username, password string) (db.UserAuthData, error) {
+	passHash, err := s.hasher.Hash(password)
+	if err != nil {
+		s.log.Error("failed to hash password", zap.String("username", username), zap.Error(err))
+		return db.UserAuthData{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := s.authDB.BeginTx()
+	if err != nil {
+		return db.UserAuthData{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	id, err := s.authDB.CreateUser(tx, username, passHash, InitialCoins)
+	if errors.Is(err, db.ErrUserExists) {
+		_ = tx.Rollback()
+		s.log.Warn("user registered concurrently", zap.String("username", username))
+		return s.login(username, password)
+	}
+	if err != nil {
+		s.log.Error("failed to create user", zap.String("username", username), zap.Error(err))
+		return db.UserAuthData{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit user creation", zap.String("username", username), zap.Error(err))
+		return db.UserAuthData{}, err
 	}
-	s.log.Info("User authenticated", zap.Int("userID", id), zap.String("username", username))
-	return tokenString, nil
+	s.log.Info("User registered", zap.Int("userID", id), zap.String("username", username))
+	return db.UserAuthData{ID: id, Role: token.RoleEmployee}, nil
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs and JSON.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is the form refresh tokens are stored in, so a database leak does
+// not expose usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
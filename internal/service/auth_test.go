@@ -1,118 +1,647 @@
 package service
 
 import (
+	"avito-shop/internal/db"
+	"avito-shop/internal/jwtkeys"
+	"avito-shop/internal/token"
+	"database/sql"
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var ErrInvalidCredentials = errors.New("invalid credentials")
+var testHasher = NewArgon2idHasher(Argon2idParams{Time: 1, MemoryKiB: 64, Threads: 1})
 
 type mockAuthDB struct {
-	GetUserAuthDataFunc func(username string) (int, string, error)
+	db                     *sql.DB
+	GetUserAuthDataFunc    func(username string) (db.UserAuthData, error)
+	CreateUserFunc         func(username, passwordHash string, coins int) (int, error)
+	UpdatePasswordHashFunc func(userID int, passwordHash string) error
+	GetUserFunc            func(userID int) (db.User, error)
+	SetUserRoleFunc        func(userID int, role string) error
 }
 
-func (m *mockAuthDB) GetUserAuthData(username string) (int, string, error) {
+func (m *mockAuthDB) BeginTx() (*sql.Tx, error) {
+	return m.db.Begin()
+}
+
+func (m *mockAuthDB) GetUserAuthData(username string) (db.UserAuthData, error) {
 	return m.GetUserAuthDataFunc(username)
 }
+
+func (m *mockAuthDB) CreateUser(tx *sql.Tx, username, passwordHash string, coins int) (int, error) {
+	return m.CreateUserFunc(username, passwordHash, coins)
+}
+
+func (m *mockAuthDB) UpdatePasswordHash(userID int, passwordHash string) error {
+	if m.UpdatePasswordHashFunc == nil {
+		return nil
+	}
+	return m.UpdatePasswordHashFunc(userID, passwordHash)
+}
+
+func (m *mockAuthDB) GetUser(userID int) (db.User, error) {
+	return m.GetUserFunc(userID)
+}
+
+func (m *mockAuthDB) SetUserRole(userID int, role string) error {
+	return m.SetUserRoleFunc(userID, role)
+}
+
+type mockRefreshTokenDB struct {
+	db     *sql.DB
+	tokens map[string]*db.RefreshToken
+}
+
+func newMockRefreshTokenDB(dbConn *sql.DB) *mockRefreshTokenDB {
+	return &mockRefreshTokenDB{db: dbConn, tokens: map[string]*db.RefreshToken{}}
+}
+
+func (m *mockRefreshTokenDB) BeginTx() (*sql.Tx, error) {
+	return m.db.Begin()
+}
+
+func (m *mockRefreshTokenDB) InsertRefreshToken(tx *sql.Tx, userID int, familyID, tokenHash string, expiresAt time.Time) error {
+	m.tokens[tokenHash] = &db.RefreshToken{
+		ID:        len(m.tokens) + 1,
+		UserID:    userID,
+		Username:  "testuser",
+		Role:      token.RoleEmployee,
+		FamilyID:  familyID,
+		ExpiresAt: expiresAt,
+	}
+	return nil
+}
+
+func (m *mockRefreshTokenDB) GetRefreshTokenForUpdate(tx *sql.Tx, tokenHash string) (db.RefreshToken, error) {
+	rt, ok := m.tokens[tokenHash]
+	if !ok {
+		return db.RefreshToken{}, sql.ErrNoRows
+	}
+	return *rt, nil
+}
+
+func (m *mockRefreshTokenDB) MarkRefreshTokenUsed(tx *sql.Tx, id int) error {
+	for _, rt := range m.tokens {
+		if rt.ID == id {
+			rt.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *mockRefreshTokenDB) RevokeRefreshTokenFamily(tx *sql.Tx, familyID string) error {
+	for _, rt := range m.tokens {
+		if rt.FamilyID == familyID {
+			rt.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *mockRefreshTokenDB) RevokeUserRefreshTokens(tx *sql.Tx, userID int) error {
+	for _, rt := range m.tokens {
+		if rt.UserID == userID {
+			rt.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+type mockRevocationStore struct {
+	revokedTokens map[string]int
+	revokedUsers  []int
+}
+
+func (m *mockRevocationStore) RevokeToken(tokenID string, userID int, expiresAt time.Time) error {
+	m.revokedTokens[tokenID] = userID
+	return nil
+}
+
+func (m *mockRevocationStore) RevokeUser(userID int) error {
+	m.revokedUsers = append(m.revokedUsers, userID)
+	return nil
+}
+
+func (m *mockRevocationStore) IsRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error) {
+	_, ok := m.revokedTokens[tokenID]
+	return ok, nil
+}
+
+var testKeys = func() *jwtkeys.KeySet {
+	keys, err := jwtkeys.Generate()
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}()
+
+var testTokenConfig = TokenConfig{
+	Signer:     testKeys,
+	Issuer:     "avito-shop",
+	Audience:   "avito-shop",
+	AccessTTL:  time.Hour,
+	RefreshTTL: 24 * time.Hour,
+}
+
+func parseTestToken(t *testing.T, signed string) *token.Claims {
+	t.Helper()
+	claims := &token.Claims{}
+	if _, err := jwt.ParseWithClaims(signed, claims, testKeys.Keyfunc); err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	return claims
+}
+
+const testClientIP = "192.0.2.1"
+
+var testThrottleConfig = ThrottleConfig{
+	MaxUserFailures: 3,
+	MaxIPFailures:   10,
+	BackoffBase:     time.Second,
+	Lockout:         time.Minute,
+}
+
+type authFixture struct {
+	svc         AuthService
+	tokenDB     *mockRefreshTokenDB
+	revocations *mockRevocationStore
+	attempts    db.LoginAttemptDB
+	mock        sqlmock.Sqlmock
+}
+
+// newTestAuthService wires the mocks to one sqlmock connection; callers declare
+// the transactions they expect on the returned mock.
+func newTestAuthService(t *testing.T, authDB *mockAuthDB, cfg TokenConfig) authFixture {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	authDB.db = dbConn
+	f := authFixture{
+		tokenDB:     newMockRefreshTokenDB(dbConn),
+		revocations: &mockRevocationStore{revokedTokens: map[string]int{}},
+		attempts:    db.NewMemoryLoginAttemptDB(),
+		mock:        mock,
+	}
+	throttle := NewLoginThrottle(f.attempts, &mockLogger{}, testThrottleConfig)
+	f.svc = NewAuthService(authDB, f.tokenDB, f.revocations, throttle, testHasher, &mockLogger{}, cfg)
+	return f
+}
+
+func expectTxs(mock sqlmock.Sqlmock, commits ...bool) {
+	for _, commit := range commits {
+		mock.ExpectBegin()
+		if commit {
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+	}
+}
+
 func TestAuthService_Authenticate_Success(t *testing.T) {
 	mockDB := &mockAuthDB{
-		GetUserAuthDataFunc: func(username string) (int, string, error) {
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
 			if username == "testuser" {
-				return 1, "secret", nil
+				return db.UserAuthData{ID: 1, PasswordHash: "secret", Role: token.RoleAdmin}, nil
 			}
-			return 0, "", errors.New("not found")
+			return db.UserAuthData{}, errors.New("not found")
 		},
 	}
-	logger := &mockLogger{}
-	authSvc := NewAuthService(mockDB, logger, "jwtSecret")
+	f := newTestAuthService(t, mockDB, testTokenConfig)
+	expectTxs(f.mock, true)
 
-	tokenStr, err := authSvc.Authenticate("testuser", "secret")
+	tokens, err := f.svc.Authenticate("testuser", "secret", testClientIP)
 	if err != nil {
 		t.Fatalf("expected success, got error: %v", err)
 	}
-	if tokenStr == "" {
-		t.Errorf("expected non-empty token")
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("expected non-empty tokens, got %+v", tokens)
 	}
-	parsed, err := jwt.Parse(tokenStr, func(tok *jwt.Token) (interface{}, error) {
-		return []byte("jwtSecret"), nil
-	})
-	if err != nil || !parsed.Valid {
-		t.Errorf("failed to parse or invalid token: %v", err)
+	claims := parseTestToken(t, tokens.AccessToken)
+	if claims.UserID != 1 || claims.Username != "testuser" || claims.Subject != "1" {
+		t.Errorf("claims mismatch: %+v", claims)
 	}
-	claims, ok := parsed.Claims.(jwt.MapClaims)
-	if !ok {
-		t.Errorf("unexpected claims type: %T", parsed.Claims)
+	if !claims.HasRole(token.RoleAdmin) || claims.HasRole(token.RoleEmployee) {
+		t.Errorf("expected only the admin role, got %v", claims.Roles)
+	}
+	if err := claims.Validate(token.Expectations{Issuer: "avito-shop", Audience: "avito-shop"}, time.Now()); err != nil {
+		t.Errorf("issued claims do not validate: %v", err)
 	}
-	if claims["user_id"] != float64(1) || claims["username"] != "testuser" {
-		t.Errorf("claims mismatch: %v", claims)
+	if _, ok := f.tokenDB.tokens[hashToken(tokens.RefreshToken)]; !ok {
+		t.Errorf("expected refresh token to be stored hashed")
 	}
-	if exp, ok := claims["exp"].(float64); !ok || exp < float64(time.Now().Unix()) {
-		t.Errorf("token exp is not set properly: %v", claims["exp"])
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
 	}
 }
 
-func TestAuthService_Authenticate_UserNotFound(t *testing.T) {
+func TestAuthService_Authenticate_LookupError(t *testing.T) {
 	mockDB := &mockAuthDB{
-		GetUserAuthDataFunc: func(username string) (int, string, error) {
-			return 0, "", errors.New("no rows")
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			return db.UserAuthData{}, errors.New("connection reset")
 		},
 	}
-	authSvc := NewAuthService(mockDB, &mockLogger{}, "secretJWT")
+	f := newTestAuthService(t, mockDB, testTokenConfig)
 
-	tokenStr, err := authSvc.Authenticate("unknownUser", "anyPass")
+	tokens, err := f.svc.Authenticate("unknownUser", "anyPass", testClientIP)
 	if err == nil {
 		t.Fatalf("expected error, got nil")
 	}
-	if !strings.Contains(err.Error(), "invalid credentials") {
-		t.Errorf("expected 'invalid credentials', got: %v", err)
+	if errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("lookup failure must not be reported as invalid credentials: %v", err)
 	}
-	if tokenStr != "" {
-		t.Errorf("expected empty token, got: %s", tokenStr)
+	if tokens.AccessToken != "" {
+		t.Errorf("expected empty token, got: %s", tokens.AccessToken)
+	}
+}
+
+func TestAuthService_Authenticate_RegistersNewUser(t *testing.T) {
+	var (
+		createdCoins int
+		createdHash  string
+	)
+	mockDB := &mockAuthDB{
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			return db.UserAuthData{}, sql.ErrNoRows
+		},
+		CreateUserFunc: func(username, passwordHash string, coins int) (int, error) {
+			createdCoins = coins
+			createdHash = passwordHash
+			return 7, nil
+		},
+	}
+	f := newTestAuthService(t, mockDB, testTokenConfig)
+	expectTxs(f.mock, true, true)
+
+	tokens, err := f.svc.Authenticate("newbie", "pass", testClientIP)
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if createdCoins != InitialCoins {
+		t.Errorf("expected user created with %d coins, got %d", InitialCoins, createdCoins)
+	}
+	if match, _ := testHasher.Verify(createdHash, "pass"); !match || createdHash == "pass" {
+		t.Errorf("expected hashed password to be stored, got %q", createdHash)
+	}
+	if claims := parseTestToken(t, tokens.AccessToken); claims.UserID != 7 {
+		t.Errorf("claims mismatch: %+v", claims)
+	}
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestAuthService_Authenticate_ConcurrentRegistration(t *testing.T) {
+	lookups := 0
+	mockDB := &mockAuthDB{
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			lookups++
+			if lookups == 1 {
+				return db.UserAuthData{}, sql.ErrNoRows
+			}
+			return db.UserAuthData{ID: 3, PasswordHash: "otherPass", Role: token.RoleEmployee}, nil
+		},
+		CreateUserFunc: func(username, passwordHash string, coins int) (int, error) {
+			return 0, db.ErrUserExists
+		},
+	}
+	f := newTestAuthService(t, mockDB, testTokenConfig)
+	expectTxs(f.mock, false)
+
+	tokens, err := f.svc.Authenticate("racer", "myPass", testClientIP)
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	if tokens.AccessToken != "" {
+		t.Errorf("expected empty token, got: %s", tokens.AccessToken)
+	}
+	if lookups != 2 {
+		t.Errorf("expected password to be checked against the existing user, lookups=%d", lookups)
+	}
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
 	}
 }
 
 func TestAuthService_Authenticate_WrongPassword(t *testing.T) {
 	mockDB := &mockAuthDB{
-		GetUserAuthDataFunc: func(username string) (int, string, error) {
-			return 2, "realPass", nil
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			return db.UserAuthData{ID: 2, PasswordHash: "realPass", Role: token.RoleEmployee}, nil
 		},
 	}
-	authSvc := NewAuthService(mockDB, &mockLogger{}, "someSecret")
+	f := newTestAuthService(t, mockDB, testTokenConfig)
 
-	tokenStr, err := authSvc.Authenticate("someuser", "wrongPass")
-	if err == nil {
-		t.Fatalf("expected error, got nil")
+	tokens, err := f.svc.Authenticate("someuser", "wrongPass", testClientIP)
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
 	}
 	if !strings.Contains(err.Error(), "invalid credentials") {
 		t.Errorf("expected 'invalid credentials', got: %v", err)
 	}
-	if tokenStr != "" {
-		t.Errorf("expected empty token when error, got: %s", tokenStr)
+	if tokens.AccessToken != "" {
+		t.Errorf("expected empty token when error, got: %s", tokens.AccessToken)
 	}
 }
 
-func TestAuthService_Authenticate_JWTError(t *testing.T) {
+func TestAuthService_Authenticate_ThrottlesFailures(t *testing.T) {
+	lookups := 0
 	mockDB := &mockAuthDB{
-		GetUserAuthDataFunc: func(username string) (int, string, error) {
-			return 1, "secretPass", nil
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			lookups++
+			return db.UserAuthData{ID: 2, PasswordHash: "realPass", Role: token.RoleEmployee}, nil
 		},
 	}
-	logger := &mockLogger{}
+	f := newTestAuthService(t, mockDB, testTokenConfig)
+
+	if _, err := f.svc.Authenticate("someuser", "wrongPass", testClientIP); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+	}
+	_, err := f.svc.Authenticate("someuser", "realPass", testClientIP)
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("expected ThrottledError, got %v", err)
+	}
+	if lookups != 1 {
+		t.Errorf("expected throttled login to skip the password check, got %d lookups", lookups)
+	}
+
+	if a, _ := f.attempts.GetLoginAttempt(userKey("someuser")); a.Failures != 1 {
+		t.Errorf("expected 1 recorded failure, got %d", a.Failures)
+	}
+	mockDB.GetUserFunc = func(userID int) (db.User, error) {
+		return db.User{ID: userID, Username: "someuser"}, nil
+	}
+	if err := f.svc.UnlockUser(2); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if a, _ := f.attempts.GetLoginAttempt(userKey("someuser")); a.Failures != 0 {
+		t.Errorf("expected failures to be reset, got %d", a.Failures)
+	}
+}
 
-	authSvc := NewAuthService(mockDB, logger, "")
+func TestAuthService_Authenticate_JWTError(t *testing.T) {
+	mockDB := &mockAuthDB{
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			return db.UserAuthData{ID: 1, PasswordHash: "secretPass", Role: token.RoleEmployee}, nil
+		},
+	}
+	cfg := testTokenConfig
+	cfg.Signer = nil
+	f := newTestAuthService(t, mockDB, cfg)
 
-	tokenStr, err := authSvc.Authenticate("testuser", "secretPass")
+	tokens, err := f.svc.Authenticate("testuser", "secretPass", testClientIP)
 	if err == nil {
 		t.Fatalf("expected error generating token, got nil")
 	}
-	if !strings.Contains(err.Error(), "could not generate token: empty secret") {
+	if !strings.Contains(err.Error(), "could not generate token: no signing key") {
 		t.Errorf("unexpected error: %v", err)
 	}
-	if tokenStr != "" {
-		t.Errorf("expected empty token, got %s", tokenStr)
+	if tokens.AccessToken != "" {
+		t.Errorf("expected empty token, got %s", tokens.AccessToken)
+	}
+}
+
+func TestAuthService_Authenticate_RehashesPlaintextPassword(t *testing.T) {
+	var storedHash string
+	mockDB := &mockAuthDB{
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			return db.UserAuthData{ID: 5, PasswordHash: "legacyPass", Role: token.RoleEmployee}, nil
+		},
+		UpdatePasswordHashFunc: func(userID int, passwordHash string) error {
+			if userID != 5 {
+				t.Errorf("unexpected user id %d", userID)
+			}
+			storedHash = passwordHash
+			return nil
+		},
+	}
+	f := newTestAuthService(t, mockDB, testTokenConfig)
+	expectTxs(f.mock, true)
+
+	if _, err := f.svc.Authenticate("olduser", "legacyPass", testClientIP); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	match, needsRehash := testHasher.Verify(storedHash, "legacyPass")
+	if !match || needsRehash {
+		t.Errorf("expected upgraded argon2id hash, got %q", storedHash)
+	}
+}
+
+func TestAuthService_Authenticate_HashedPasswordNotRehashed(t *testing.T) {
+	hash, err := testHasher.Hash("secret")
+	if err != nil {
+		t.Fatalf("failed to hash: %v", err)
+	}
+	mockDB := &mockAuthDB{
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			return db.UserAuthData{ID: 1, PasswordHash: hash, Role: token.RoleEmployee}, nil
+		},
+		UpdatePasswordHashFunc: func(userID int, passwordHash string) error {
+			t.Errorf("unexpected rehash for user %d", userID)
+			return nil
+		},
+	}
+	f := newTestAuthService(t, mockDB, testTokenConfig)
+	expectTxs(f.mock, true)
+
+	if _, err := f.svc.Authenticate("testuser", "secret", testClientIP); err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if _, err := f.svc.Authenticate("testuser", "Secret", testClientIP); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func loginForRefresh(t *testing.T) (authFixture, Tokens) {
+	t.Helper()
+	mockDB := &mockAuthDB{
+		GetUserAuthDataFunc: func(username string) (db.UserAuthData, error) {
+			return db.UserAuthData{ID: 1, PasswordHash: "secret", Role: token.RoleEmployee}, nil
+		},
+	}
+	f := newTestAuthService(t, mockDB, testTokenConfig)
+	expectTxs(f.mock, true)
+	tokens, err := f.svc.Authenticate("testuser", "secret", testClientIP)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	return f, tokens
+}
+
+func TestAuthService_Refresh_RotatesToken(t *testing.T) {
+	f, first := loginForRefresh(t)
+	expectTxs(f.mock, true)
+
+	second, err := f.svc.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if second.AccessToken == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Errorf("expected a new token pair, got %+v", second)
+	}
+	old := f.tokenDB.tokens[hashToken(first.RefreshToken)]
+	rotated := f.tokenDB.tokens[hashToken(second.RefreshToken)]
+	if !old.UsedAt.Valid {
+		t.Errorf("expected exchanged token to be marked used")
+	}
+	if rotated == nil || rotated.FamilyID != old.FamilyID {
+		t.Errorf("expected rotated token in the same family")
+	}
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestAuthService_Refresh_ReuseRevokesFamily(t *testing.T) {
+	f, first := loginForRefresh(t)
+	expectTxs(f.mock, true, true, false)
+
+	second, err := f.svc.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	if _, err := f.svc.Refresh(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := f.svc.Refresh(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected descendant token to be revoked, got %v", err)
+	}
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestAuthService_Refresh_ExpiredOrUnknown(t *testing.T) {
+	f, first := loginForRefresh(t)
+	expectTxs(f.mock, false, false)
+
+	f.tokenDB.tokens[hashToken(first.RefreshToken)].ExpiresAt = time.Now().Add(-time.Minute)
+	if _, err := f.svc.Refresh(first.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken for expired token, got %v", err)
+	}
+	if _, err := f.svc.Refresh("garbage"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken for unknown token, got %v", err)
+	}
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestAuthService_Authenticate_TokenHasID(t *testing.T) {
+	f, first := loginForRefresh(t)
+	claims := parseTestToken(t, first.AccessToken)
+	if claims.ID == "" || claims.IssuedAt == nil {
+		t.Errorf("expected jti and iat claims, got %+v", claims)
+	}
+
+	f.mock.ExpectBegin()
+	f.mock.ExpectCommit()
+	second, err := f.svc.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if second.AccessToken == first.AccessToken {
+		t.Errorf("expected distinct access tokens")
+	}
+}
+
+func TestAuthService_Logout(t *testing.T) {
+	f, first := loginForRefresh(t)
+	expectTxs(f.mock, true, false)
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := f.svc.Logout(1, "jti-1", expiresAt, first.RefreshToken); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.revocations.revokedTokens["jti-1"] != 1 {
+		t.Errorf("expected access token to be revoked, got %v", f.revocations.revokedTokens)
+	}
+	if !f.tokenDB.tokens[hashToken(first.RefreshToken)].RevokedAt.Valid {
+		t.Errorf("expected refresh token family to be revoked")
+	}
+	if _, err := f.svc.Refresh(first.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken after logout, got %v", err)
+	}
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestAuthService_Logout_ForeignRefreshToken(t *testing.T) {
+	f, first := loginForRefresh(t)
+	expectTxs(f.mock, false)
+
+	err := f.svc.Logout(2, "jti-2", time.Now().Add(time.Hour), first.RefreshToken)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+	if f.tokenDB.tokens[hashToken(first.RefreshToken)].RevokedAt.Valid {
+		t.Errorf("another user's refresh token must not be revoked")
+	}
+	if len(f.revocations.revokedTokens) != 0 {
+		t.Errorf("expected no revocation on failed logout, got %v", f.revocations.revokedTokens)
+	}
+}
+
+func TestAuthService_RevokeAllSessions(t *testing.T) {
+	f := newTestAuthService(t, &mockAuthDB{}, testTokenConfig)
+	if err := f.svc.RevokeAllSessions(42); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.revocations.revokedUsers) != 1 || f.revocations.revokedUsers[0] != 42 {
+		t.Errorf("expected user 42 to be revoked, got %v", f.revocations.revokedUsers)
+	}
+}
+
+func TestAuthService_SetRole(t *testing.T) {
+	var setRole string
+	f := newTestAuthService(t, &mockAuthDB{
+		SetUserRoleFunc: func(userID int, role string) error {
+			if userID != 42 {
+				return fmt.Errorf("no user: %w", sql.ErrNoRows)
+			}
+			setRole = role
+			return nil
+		},
+	}, testTokenConfig)
+
+	if err := f.svc.SetRole(42, "superuser"); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("expected ErrInvalidRole, got %v", err)
+	}
+	if err := f.svc.SetRole(7, token.RoleAdmin); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	if len(f.revocations.revokedUsers) != 0 {
+		t.Fatalf("expected no sessions revoked on failure, got %v", f.revocations.revokedUsers)
+	}
+
+	if err := f.svc.SetRole(42, token.RoleAuditor); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if setRole != token.RoleAuditor {
+		t.Errorf("expected role %q to be stored, got %q", token.RoleAuditor, setRole)
+	}
+	if len(f.revocations.revokedUsers) != 1 || f.revocations.revokedUsers[0] != 42 {
+		t.Errorf("expected sessions of user 42 to be revoked, got %v", f.revocations.revokedUsers)
+	}
+}
+
+func TestAuthService_GetUser_NotFound(t *testing.T) {
+	f := newTestAuthService(t, &mockAuthDB{
+		GetUserFunc: func(userID int) (db.User, error) {
+			return db.User{}, fmt.Errorf("failed to get user: %w", sql.ErrNoRows)
+		},
+	}, testTokenConfig)
+	if _, err := f.svc.GetUser(1); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
 	}
 }
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"avito-shop/pkg"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrCartEmpty      = errors.New("cart is empty")
+	ErrNotInCart      = errors.New("item is not in the cart")
+	ErrCheckoutFailed = errors.New("cart cannot be checked out")
+)
+
+// Reasons a cart line cannot be checked out.
+const (
+	ProblemPriceChanged = "price_changed"
+	ProblemOutOfStock   = "out_of_stock"
+	ProblemUnavailable  = "unavailable"
+	ProblemLimitReached = "limit_reached"
+)
+
+type CartLine struct {
+	Item     string
+	Name     string
+	Quantity int
+	// Price is the current price, CartPrice the one when the line was added.
+	Price     int
+	CartPrice int
+	Available bool
+}
+
+type Cart struct {
+	Lines []CartLine
+	// Total is priced at the current prices.
+	Total int
+}
+
+// LineProblem tells why a cart line cannot be checked out.
+type LineProblem struct {
+	Item      string
+	Reason    string
+	CartPrice int
+	// Price is nil for ProblemUnavailable.
+	Price *int
+	// Stock is only set for ProblemOutOfStock.
+	Stock *int
+}
+
+// CheckoutError lists every line of the cart that cannot be checked out. It
+// matches ErrCheckoutFailed.
+type CheckoutError struct {
+	Problems []LineProblem
+}
+
+func (e *CheckoutError) Error() string {
+	return fmt.Sprintf("%s: %d line(s) failed", ErrCheckoutFailed, len(e.Problems))
+}
+
+func (e *CheckoutError) Is(target error) bool {
+	return target == ErrCheckoutFailed
+}
+
+// CartService keeps a cart per user. Adding or changing a line remembers the
+// current price of the item; checkout refuses lines whose price has changed
+// since.
+type CartService interface {
+	GetCart(userID int) (Cart, error)
+	AddItem(userID int, item string, quantity int) (Cart, error)
+	SetQuantity(userID int, item string, quantity int) (Cart, error)
+	RemoveItem(userID int, item string) (Cart, error)
+	// Checkout buys the whole cart in one transaction and empties it,
+	// redeeming promoCode unless it is empty. It returns a *CheckoutError if
+	// any line cannot be bought as it was added.
+	Checkout(userID int, promoCode string) (Receipt, error)
+}
+
+type cartService struct {
+	shop  *shopService
+	carts db.CartDB
+	log   pkg.Logger
+}
+
+func NewCartService(dbProv db.CoinInventoryDB, catalog db.CatalogDB, orders db.OrderDB, carts db.CartDB, promos db.PromoDB, log pkg.Logger) CartService {
+	return &cartService{
+		shop: &shopService{
+			dbProv:  dbProv,
+			catalog: catalog,
+			orders:  orders,
+			promos:  promos,
+			log:     log,
+			now:     time.Now,
+		},
+		carts: carts,
+		log:   log,
+	}
+}
+
+func (s *cartService) GetCart(userID int) (Cart, error) {
+	lines, err := s.carts.GetCart(userID)
+	if err != nil {
+		s.log.Error("failed to get cart", zap.Int("userID", userID), zap.Error(err))
+		return Cart{}, err
+	}
+
+	cart := Cart{Lines: make([]CartLine, 0, len(lines))}
+	for _, l := range lines {
+		it := l.Item
+		cart.Lines = append(cart.Lines, CartLine{
+			Item:      it.Slug,
+			Name:      it.Name,
+			Quantity:  l.Quantity,
+			Price:     it.Price,
+			CartPrice: l.Price,
+			Available: it.Active && (!it.Stock.Valid || it.Stock.Int64 >= int64(l.Quantity)),
+		})
+		cart.Total += it.Price * l.Quantity
+	}
+	return cart, nil
+}
+
+// activeItem returns the item to put into a cart.
+func (s *cartService) activeItem(slug string) (db.Item, error) {
+	it, err := s.shop.catalog.GetItem(slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Item{}, ErrItemNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get item", zap.String("item", slug), zap.Error(err))
+		return db.Item{}, err
+	}
+	if !it.Active {
+		return db.Item{}, ErrItemNotFound
+	}
+	return it, nil
+}
+
+func (s *cartService) AddItem(userID int, item string, quantity int) (Cart, error) {
+	if quantity < 1 || quantity > maxLineQuantity {
+		return Cart{}, fmt.Errorf("%w: quantity must be 1 to %d", ErrInvalidQuantity, maxLineQuantity)
+	}
+	it, err := s.activeItem(item)
+	if err != nil {
+		return Cart{}, err
+	}
+
+	_, err = s.carts.AddCartLine(userID, it.ID, quantity, it.Price, maxLineQuantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Cart{}, fmt.Errorf("%w: at most %d of %s fit in the cart", ErrInvalidQuantity, maxLineQuantity, item)
+	}
+	if err != nil {
+		s.log.Error("failed to add cart line", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
+		return Cart{}, err
+	}
+	return s.GetCart(userID)
+}
+
+func (s *cartService) SetQuantity(userID int, item string, quantity int) (Cart, error) {
+	if quantity < 1 || quantity > maxLineQuantity {
+		return Cart{}, fmt.Errorf("%w: quantity must be 1 to %d", ErrInvalidQuantity, maxLineQuantity)
+	}
+	it, err := s.activeItem(item)
+	if err != nil {
+		return Cart{}, err
+	}
+
+	if err := s.carts.SetCartLine(userID, it.ID, quantity, it.Price); err != nil {
+		s.log.Error("failed to set cart line", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
+		return Cart{}, err
+	}
+	return s.GetCart(userID)
+}
+
+func (s *cartService) RemoveItem(userID int, item string) (Cart, error) {
+	// deactivated items can still be removed
+	it, err := s.shop.catalog.GetItem(item)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Cart{}, ErrNotInCart
+	}
+	if err != nil {
+		s.log.Error("failed to get item", zap.String("item", item), zap.Error(err))
+		return Cart{}, err
+	}
+
+	err = s.carts.DeleteCartLine(userID, it.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Cart{}, ErrNotInCart
+	}
+	if err != nil {
+		s.log.Error("failed to delete cart line", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
+		return Cart{}, err
+	}
+	return s.GetCart(userID)
+}
+
+func (s *cartService) Checkout(userID int, promoCode string) (Receipt, error) {
+	tx, err := s.shop.dbProv.BeginTx()
+	if err != nil {
+		return Receipt{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	coins, err := s.shop.dbProv.GetCoinsForUpdate(tx, userID)
+	if err != nil {
+		s.log.Error("failed to get user coins for update", zap.Int("userID", userID), zap.Error(err))
+		return Receipt{}, err
+	}
+	cart, err := s.carts.GetCartForUpdate(tx, userID)
+	if err != nil {
+		s.log.Error("failed to get cart for update", zap.Int("userID", userID), zap.Error(err))
+		return Receipt{}, err
+	}
+	if len(cart) == 0 {
+		return Receipt{}, ErrCartEmpty
+	}
+
+	// the cart comes sorted by item, so items are locked in the same order as
+	// in ShopService.Buy
+	lines := make([]PurchaseLine, 0, len(cart))
+	items := make([]db.Item, 0, len(cart))
+	var problems []LineProblem
+	for _, cl := range cart {
+		l := PurchaseLine{Item: cl.Item.Slug, Quantity: cl.Quantity}
+		it, err := s.shop.lockItemFor(tx, userID, l)
+		problem := LineProblem{Item: l.Item, CartPrice: cl.Price}
+		switch {
+		case errors.Is(err, ErrItemNotFound):
+			problem.Reason = ProblemUnavailable
+		case errors.Is(err, ErrOutOfStock):
+			problem.Reason = ProblemOutOfStock
+			problem.Price = ptrInt(it.Price)
+			problem.Stock = fromNullInt(it.Stock)
+		case errors.Is(err, ErrPurchaseLimitReached):
+			problem.Reason = ProblemLimitReached
+			problem.Price = ptrInt(it.Price)
+		case err != nil:
+			return Receipt{}, err
+		case it.Price != cl.Price:
+			problem.Reason = ProblemPriceChanged
+			problem.Price = ptrInt(it.Price)
+		}
+		if problem.Reason != "" {
+			problems = append(problems, problem)
+			continue
+		}
+		lines = append(lines, l)
+		items = append(items, it)
+	}
+	if len(problems) > 0 {
+		s.log.Warn("checkout refused", zap.Int("userID", userID), zap.Int("problems", len(problems)))
+		return Receipt{}, &CheckoutError{Problems: problems}
+	}
+
+	receipt, err := s.shop.fulfil(tx, userID, userID, coins, lines, items, promoCode)
+	if err != nil {
+		return Receipt{}, err
+	}
+	if err := s.carts.ClearCart(tx, userID); err != nil {
+		s.log.Error("failed to clear cart", zap.Int("userID", userID), zap.Error(err))
+		return Receipt{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit checkout", zap.Int("userID", userID), zap.Error(err))
+		return Receipt{}, err
+	}
+	s.log.Info("Cart checked out",
+		zap.Int("userID", userID),
+		zap.Int("orderID", receipt.OrderID),
+		zap.Int("lines", len(lines)),
+		zap.Int("total", receipt.Total))
+	return receipt, nil
+}
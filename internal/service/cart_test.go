@@ -0,0 +1,190 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var cartRowColumns = append([]string{"quantity", "cart_price", "added_at"}, itemRowColumns...)
+
+func newTestCartService(t *testing.T) (*cartService, sqlmock.Sqlmock) {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	svc := NewCartService(&coinInventorySQLMock{db: dbConn}, db.NewCatalogDB(dbConn), db.NewOrderDB(dbConn), db.NewCartDB(dbConn), db.NewPromoDB(dbConn), &mockLogger{})
+	return svc.(*cartService), mock
+}
+
+func expectCheckoutStart(mock sqlmock.Sqlmock, coins int, cart *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(coins))
+	mock.ExpectQuery("SELECT (.+) FROM cart_lines cl JOIN items i ON i.id = cl.item_id WHERE cl.user_id=\\$1 ORDER BY i.slug FOR UPDATE OF cl").
+		WithArgs(1).
+		WillReturnRows(cart)
+}
+
+func TestCartService_Checkout(t *testing.T) {
+	svc, mock := newTestCartService(t)
+	now := time.Now()
+
+	expectCheckoutStart(mock, 100, sqlmock.NewRows(cartRowColumns).
+		AddRow(2, 20, now, 1, "cup", "Cup", 20, nil, nil, true, now, now).
+		AddRow(3, 10, now, 2, "socks", "Socks", 10, nil, nil, true, now, now))
+	expectItem(mock, "cup", 20)
+	expectItem(mock, "socks", 10)
+	for _, l := range []struct {
+		item     string
+		quantity int
+	}{{"cup", 2}, {"socks", 3}} {
+		mock.ExpectExec("UPDATE items SET stock = stock - \\$1").
+			WithArgs(l.quantity, 1).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectIncreaseItem(mock, 1, l.item, l.quantity)
+	}
+	expectOrder(mock, 1, 4, 70,
+		db.OrderLine{ItemID: 1, Item: "cup", Name: "cup", Quantity: 2, Price: 20, Total: 40},
+		db.OrderLine{ItemID: 1, Item: "socks", Name: "socks", Quantity: 3, Price: 10, Total: 30})
+	mock.ExpectExec("DELETE FROM cart_lines WHERE user_id=\\$1").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	receipt, err := svc.Checkout(1, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if receipt.OrderID != 4 || receipt.Total != 70 || receipt.Balance != 30 || len(receipt.Lines) != 2 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestCartService_Checkout_ReportsEveryProblem(t *testing.T) {
+	svc, mock := newTestCartService(t)
+	now := time.Now()
+
+	expectCheckoutStart(mock, 1000, sqlmock.NewRows(cartRowColumns).
+		AddRow(1, 300, now, 1, "hoody", "Hoody", 300, nil, nil, true, now, now).
+		AddRow(1, 20, now, 2, "mug", "Mug", 20, nil, nil, false, now, now).
+		AddRow(3, 10, now, 3, "pen", "Pen", 10, 5, nil, true, now, now).
+		AddRow(2, 200, now, 4, "umbrella", "Umbrella", 200, 5, nil, true, now, now))
+	lockItem := func(id int, slug string, price int, stock any) {
+		mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 AND active FOR UPDATE").
+			WithArgs(slug).
+			WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(id, slug, slug, price, stock, nil, true, now, now))
+	}
+	// hoody got cheaper, mug was deactivated, umbrella sold out since being added
+	lockItem(1, "hoody", 250, nil)
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 AND active FOR UPDATE").
+		WithArgs("mug").
+		WillReturnError(sql.ErrNoRows)
+	lockItem(3, "pen", 10, 5)
+	lockItem(4, "umbrella", 200, 1)
+	mock.ExpectRollback()
+
+	_, err := svc.Checkout(1, "")
+	var checkoutErr *CheckoutError
+	if !errors.As(err, &checkoutErr) || !errors.Is(err, ErrCheckoutFailed) {
+		t.Fatalf("expected a CheckoutError, got %v", err)
+	}
+	want := map[string]string{
+		"hoody":    ProblemPriceChanged,
+		"mug":      ProblemUnavailable,
+		"umbrella": ProblemOutOfStock,
+	}
+	if len(checkoutErr.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %+v", len(want), checkoutErr.Problems)
+	}
+	for _, p := range checkoutErr.Problems {
+		if want[p.Item] != p.Reason {
+			t.Errorf("%s: expected reason %q, got %q", p.Item, want[p.Item], p.Reason)
+		}
+		switch p.Item {
+		case "hoody":
+			if p.CartPrice != 300 || p.Price == nil || *p.Price != 250 {
+				t.Errorf("expected prices 300 -> 250, got %+v", p)
+			}
+		case "umbrella":
+			if p.Stock == nil || *p.Stock != 1 {
+				t.Errorf("expected stock 1, got %+v", p)
+			}
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestCartService_Checkout_Empty(t *testing.T) {
+	svc, mock := newTestCartService(t)
+
+	expectCheckoutStart(mock, 100, sqlmock.NewRows(cartRowColumns))
+	mock.ExpectRollback()
+
+	if _, err := svc.Checkout(1, ""); !errors.Is(err, ErrCartEmpty) {
+		t.Fatalf("expected ErrCartEmpty, got %v", err)
+	}
+}
+
+func TestCartService_AddItem(t *testing.T) {
+	svc, mock := newTestCartService(t)
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1").
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(1, "cup", "Cup", 20, nil, nil, true, now, now))
+	mock.ExpectQuery("INSERT INTO cart_lines").
+		WithArgs(1, 1, 2, 20, maxLineQuantity, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"quantity"}).AddRow(3))
+	mock.ExpectQuery("SELECT (.+) FROM cart_lines cl").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(cartRowColumns).
+			AddRow(3, 20, now, 1, "cup", "Cup", 20, nil, nil, true, now, now))
+
+	cart, err := svc.AddItem(1, "cup", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cart.Lines) != 1 || cart.Lines[0].Quantity != 3 || cart.Total != 60 || !cart.Lines[0].Available {
+		t.Errorf("unexpected cart: %+v", cart)
+	}
+
+	// the line would grow over the limit
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1").
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(1, "cup", "Cup", 20, nil, nil, true, now, now))
+	mock.ExpectQuery("INSERT INTO cart_lines").
+		WithArgs(1, 1, maxLineQuantity, 20, maxLineQuantity, sqlmock.AnyArg()).
+		WillReturnError(sql.ErrNoRows)
+	if _, err := svc.AddItem(1, "cup", maxLineQuantity); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("expected ErrInvalidQuantity, got %v", err)
+	}
+
+	// deactivated items cannot be added
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1").
+		WithArgs("mug").
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "mug", "Mug", 20, nil, nil, false, now, now))
+	if _, err := svc.AddItem(1, "mug", 1); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("expected ErrItemNotFound, got %v", err)
+	}
+
+	if _, err := svc.AddItem(1, "cup", 0); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("expected ErrInvalidQuantity, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"avito-shop/pkg"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrItemExists  = errors.New("item already exists")
+	ErrInvalidItem = errors.New("invalid item")
+)
+
+// Actions recorded in the price history.
+const (
+	PriceActionCreated     = "created"
+	PriceActionUpdated     = "updated"
+	PriceActionDeactivated = "deactivated"
+	PriceActionRestored    = "restored"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const (
+	maxSlugLength     = 50
+	maxItemNameLength = 100
+)
+
+// Item is an entry of the catalog. Stock and MaxPerUser are nil for unlimited
+// items.
+type Item struct {
+	Slug       string
+	Name       string
+	Price      int
+	Stock      *int
+	MaxPerUser *int
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// NewItem is an item to add to the catalog; nil limits mean unlimited.
+type NewItem struct {
+	Slug       string
+	Name       string
+	Price      int
+	Stock      *int
+	MaxPerUser *int
+}
+
+type PriceChange struct {
+	Action string
+	// OldPrice is nil for PriceActionCreated.
+	OldPrice  *int
+	NewPrice  int
+	ChangedBy *int
+	ChangedAt time.Time
+}
+
+// ItemUpdate changes the fields that are not nil. ClearStock and
+// ClearMaxPerUser make the item unlimited again, and cannot be combined with a
+// new Stock or MaxPerUser.
+type ItemUpdate struct {
+	Name            *string
+	Price           *int
+	Stock           *int
+	MaxPerUser      *int
+	ClearStock      bool
+	ClearMaxPerUser bool
+}
+
+// CatalogService manages the items on sale. Every change is made by an admin,
+// whose ID is kept in the price history.
+type CatalogService interface {
+	ListItems() ([]Item, error)
+	CreateItem(adminID int, item NewItem) (Item, error)
+	UpdateItem(adminID int, slug string, update ItemUpdate) (Item, error)
+	// DeactivateItem stops the sales of the item; bought items stay in the
+	// inventories.
+	DeactivateItem(adminID int, slug string) (Item, error)
+	RestoreItem(adminID int, slug string) (Item, error)
+	GetPriceHistory(slug string) ([]PriceChange, error)
+}
+
+type catalogService struct {
+	catalog db.CatalogDB
+	log     pkg.Logger
+	now     func() time.Time
+}
+
+func NewCatalogService(catalog db.CatalogDB, logger pkg.Logger) CatalogService {
+	return &catalogService{
+		catalog: catalog,
+		log:     logger,
+		now:     time.Now,
+	}
+}
+
+func toItem(it db.Item) Item {
+	return Item{
+		Slug:       it.Slug,
+		Name:       it.Name,
+		Price:      it.Price,
+		Stock:      fromNullInt(it.Stock),
+		MaxPerUser: fromNullInt(it.MaxPerUser),
+		Active:     it.Active,
+		CreatedAt:  it.CreatedAt,
+		UpdatedAt:  it.UpdatedAt,
+	}
+}
+
+func toNullInt(v *int) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*v), Valid: true}
+}
+
+func fromNullInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	return ptrInt(int(v.Int64))
+}
+
+func validateItem(it db.Item) error {
+	if it.Name == "" || utf8.RuneCountInString(it.Name) > maxItemNameLength {
+		return fmt.Errorf("%w: name must be 1 to %d characters long", ErrInvalidItem, maxItemNameLength)
+	}
+	if it.Price <= 0 {
+		return fmt.Errorf("%w: price must be positive", ErrInvalidItem)
+	}
+	if it.Stock.Valid && it.Stock.Int64 < 0 {
+		return fmt.Errorf("%w: stock must not be negative", ErrInvalidItem)
+	}
+	if it.MaxPerUser.Valid && it.MaxPerUser.Int64 <= 0 {
+		return fmt.Errorf("%w: per user limit must be positive", ErrInvalidItem)
+	}
+	return nil
+}
+
+func (s *catalogService) ListItems() ([]Item, error) {
+	items, err := s.catalog.ListItems()
+	if err != nil {
+		s.log.Error("failed to list items", zap.Error(err))
+		return nil, err
+	}
+	out := make([]Item, 0, len(items))
+	for _, it := range items {
+		out = append(out, toItem(it))
+	}
+	return out, nil
+}
+
+func (s *catalogService) CreateItem(adminID int, item NewItem) (Item, error) {
+	if len(item.Slug) > maxSlugLength || !slugPattern.MatchString(item.Slug) {
+		return Item{}, fmt.Errorf("%w: slug must be lowercase letters, digits and dashes", ErrInvalidItem)
+	}
+	dbItem := db.Item{
+		Slug:       item.Slug,
+		Name:       item.Name,
+		Price:      item.Price,
+		Stock:      toNullInt(item.Stock),
+		MaxPerUser: toNullInt(item.MaxPerUser),
+	}
+	if err := validateItem(dbItem); err != nil {
+		return Item{}, err
+	}
+
+	tx, err := s.catalog.BeginTx()
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	it, err := s.catalog.InsertItem(tx, dbItem)
+	if errors.Is(err, db.ErrItemExists) {
+		return Item{}, ErrItemExists
+	}
+	if err != nil {
+		s.log.Error("failed to insert item", zap.String("item", item.Slug), zap.Error(err))
+		return Item{}, err
+	}
+	if err := s.recordChange(tx, adminID, it, PriceActionCreated, nil); err != nil {
+		return Item{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit create item", zap.String("item", item.Slug), zap.Error(err))
+		return Item{}, err
+	}
+	s.log.Info("Item created", zap.Int("adminID", adminID), zap.String("item", item.Slug), zap.Int("price", item.Price))
+	return toItem(it), nil
+}
+
+func (s *catalogService) UpdateItem(adminID int, slug string, update ItemUpdate) (Item, error) {
+	if update.Name == nil && update.Price == nil && update.Stock == nil && update.MaxPerUser == nil &&
+		!update.ClearStock && !update.ClearMaxPerUser {
+		return Item{}, fmt.Errorf("%w: nothing to update", ErrInvalidItem)
+	}
+	if update.ClearStock && update.Stock != nil {
+		return Item{}, fmt.Errorf("%w: stock cannot be set and cleared at once", ErrInvalidItem)
+	}
+	if update.ClearMaxPerUser && update.MaxPerUser != nil {
+		return Item{}, fmt.Errorf("%w: maxPerUser cannot be set and cleared at once", ErrInvalidItem)
+	}
+	return s.change(adminID, slug, PriceActionUpdated, func(it *db.Item) error {
+		if update.Name != nil {
+			it.Name = *update.Name
+		}
+		if update.Price != nil {
+			it.Price = *update.Price
+		}
+		if update.Stock != nil || update.ClearStock {
+			it.Stock = toNullInt(update.Stock)
+		}
+		if update.MaxPerUser != nil || update.ClearMaxPerUser {
+			it.MaxPerUser = toNullInt(update.MaxPerUser)
+		}
+		return validateItem(*it)
+	})
+}
+
+func (s *catalogService) DeactivateItem(adminID int, slug string) (Item, error) {
+	return s.change(adminID, slug, PriceActionDeactivated, func(it *db.Item) error {
+		it.Active = false
+		return nil
+	})
+}
+
+func (s *catalogService) RestoreItem(adminID int, slug string) (Item, error) {
+	return s.change(adminID, slug, PriceActionRestored, func(it *db.Item) error {
+		it.Active = true
+		return nil
+	})
+}
+
+// change applies modify to the locked item and records it. The lock waits for
+// purchases of the item in flight, so they all pay the price they have seen.
+// Changes that modify nothing are not recorded.
+func (s *catalogService) change(adminID int, slug, action string, modify func(*db.Item) error) (Item, error) {
+	tx, err := s.catalog.BeginTx()
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	old, err := s.catalog.GetItemForUpdate(tx, slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, ErrItemNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get item for update", zap.String("item", slug), zap.Error(err))
+		return Item{}, err
+	}
+
+	it := old
+	if err := modify(&it); err != nil {
+		return Item{}, err
+	}
+	if it == old {
+		return toItem(old), nil
+	}
+
+	it, err = s.catalog.UpdateItem(tx, it)
+	if err != nil {
+		s.log.Error("failed to update item", zap.String("item", slug), zap.Error(err))
+		return Item{}, err
+	}
+	if err := s.recordChange(tx, adminID, it, action, &old.Price); err != nil {
+		return Item{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit item change", zap.String("item", slug), zap.String("action", action), zap.Error(err))
+		return Item{}, err
+	}
+	s.log.Info("Item changed",
+		zap.Int("adminID", adminID),
+		zap.String("item", slug),
+		zap.String("action", action),
+		zap.Int("oldPrice", old.Price),
+		zap.Int("newPrice", it.Price))
+	return toItem(it), nil
+}
+
+func (s *catalogService) recordChange(tx *sql.Tx, adminID int, it db.Item, action string, oldPrice *int) error {
+	change := db.PriceChange{
+		ItemID:    it.ID,
+		Action:    action,
+		NewPrice:  it.Price,
+		OldPrice:  toNullInt(oldPrice),
+		ChangedBy: toNullInt(&adminID),
+		ChangedAt: s.now(),
+	}
+	if err := s.catalog.InsertPriceChange(tx, change); err != nil {
+		s.log.Error("failed to record price change", zap.String("item", it.Slug), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *catalogService) GetPriceHistory(slug string) ([]PriceChange, error) {
+	it, err := s.catalog.GetItem(slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get item", zap.String("item", slug), zap.Error(err))
+		return nil, err
+	}
+	history, err := s.catalog.GetPriceHistory(it.ID)
+	if err != nil {
+		s.log.Error("failed to get price history", zap.String("item", slug), zap.Error(err))
+		return nil, err
+	}
+
+	out := make([]PriceChange, 0, len(history))
+	for _, pc := range history {
+		out = append(out, PriceChange{
+			Action:    pc.Action,
+			OldPrice:  fromNullInt(pc.OldPrice),
+			NewPrice:  pc.NewPrice,
+			ChangedBy: fromNullInt(pc.ChangedBy),
+			ChangedAt: pc.ChangedAt,
+		})
+	}
+	return out, nil
+}
+
+func ptrInt(v int) *int {
+	return &v
+}
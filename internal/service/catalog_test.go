@@ -0,0 +1,256 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var itemRowColumns = []string{"id", "slug", "name", "price", "stock", "max_per_user", "active", "created_at", "updated_at"}
+
+func newTestCatalogService(t *testing.T) (*catalogService, sqlmock.Sqlmock) {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	now := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	return &catalogService{
+		catalog: db.NewCatalogDB(dbConn),
+		log:     &mockLogger{},
+		now:     func() time.Time { return now },
+	}, mock
+}
+
+func TestCatalogService_CreateItem(t *testing.T) {
+	svc, mock := newTestCatalogService(t)
+	now := svc.now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO items").
+		WithArgs("mug", "Mug", 30, sql.NullInt64{}, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(11, "mug", "Mug", 30, nil, nil, true, now, now))
+	mock.ExpectExec("INSERT INTO price_history").
+		WithArgs(11, PriceActionCreated, sql.NullInt64{}, 30, sql.NullInt64{Int64: 7, Valid: true}, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	it, err := svc.CreateItem(7, NewItem{Slug: "mug", Name: "Mug", Price: 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if it.Slug != "mug" || it.Price != 30 || !it.Active {
+		t.Errorf("unexpected item: %+v", it)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestCatalogService_CreateItem_Exists(t *testing.T) {
+	svc, mock := newTestCatalogService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO items").
+		WithArgs("cup", "Cup", 20, sql.NullInt64{}, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows(itemRowColumns))
+	mock.ExpectRollback()
+
+	if _, err := svc.CreateItem(7, NewItem{Slug: "cup", Name: "Cup", Price: 20}); !errors.Is(err, ErrItemExists) {
+		t.Fatalf("expected ErrItemExists, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestCatalogService_CreateItem_Invalid(t *testing.T) {
+	svc, _ := newTestCatalogService(t)
+
+	zero, negative := 0, -1
+	cases := map[string]NewItem{
+		"empty slug":     {Slug: "", Name: "Mug", Price: 30},
+		"uppercase slug": {Slug: "Mug", Name: "Mug", Price: 30},
+		"trailing dash":  {Slug: "mug-", Name: "Mug", Price: 30},
+		"empty name":     {Slug: "mug", Name: "", Price: 30},
+		"long name":      {Slug: "mug", Name: strings.Repeat("к", maxItemNameLength+1), Price: 30},
+		"zero price":     {Slug: "mug", Name: "Mug", Price: 0},
+		"negative price": {Slug: "mug", Name: "Mug", Price: -5},
+		"negative stock": {Slug: "mug", Name: "Mug", Price: 30, Stock: &negative},
+		"zero limit":     {Slug: "mug", Name: "Mug", Price: 30, MaxPerUser: &zero},
+	}
+	for name, item := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := svc.CreateItem(7, item); !errors.Is(err, ErrInvalidItem) {
+				t.Errorf("expected ErrInvalidItem, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateItem_NameInCharacters(t *testing.T) {
+	// the column counts characters, not bytes
+	name := strings.Repeat("к", maxItemNameLength)
+	if err := validateItem(db.Item{Slug: "mug", Name: name, Price: 30}); err != nil {
+		t.Errorf("expected a name of %d characters to be valid, got %v", maxItemNameLength, err)
+	}
+}
+
+func TestCatalogService_UpdateItem_RecordsOldPrice(t *testing.T) {
+	svc, mock := newTestCatalogService(t)
+	now := svc.now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 FOR UPDATE").
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 20, nil, nil, true, now, now))
+	mock.ExpectQuery("UPDATE items SET name=\\$2, price=\\$3").
+		WithArgs(2, "Cup", 25, sql.NullInt64{}, sql.NullInt64{}, true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 25, nil, nil, true, now, now))
+	mock.ExpectExec("INSERT INTO price_history").
+		WithArgs(2, PriceActionUpdated, sql.NullInt64{Int64: 20, Valid: true}, 25, sql.NullInt64{Int64: 7, Valid: true}, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	price := 25
+	it, err := svc.UpdateItem(7, "cup", ItemUpdate{Price: &price})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if it.Price != 25 {
+		t.Errorf("expected price 25, got %d", it.Price)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestCatalogService_UpdateItem_ClearsLimits(t *testing.T) {
+	svc, mock := newTestCatalogService(t)
+	now := svc.now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 FOR UPDATE").
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 20, 5, 1, true, now, now))
+	mock.ExpectQuery("UPDATE items").
+		WithArgs(2, "Cup", 20, sql.NullInt64{}, sql.NullInt64{}, true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 20, nil, nil, true, now, now))
+	mock.ExpectExec("INSERT INTO price_history").
+		WithArgs(2, PriceActionUpdated, sql.NullInt64{Int64: 20, Valid: true}, 20, sql.NullInt64{Int64: 7, Valid: true}, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	it, err := svc.UpdateItem(7, "cup", ItemUpdate{ClearStock: true, ClearMaxPerUser: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if it.Stock != nil || it.MaxPerUser != nil {
+		t.Errorf("expected an unlimited item, got %+v", it)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+
+	stock := 3
+	if _, err := svc.UpdateItem(7, "cup", ItemUpdate{Stock: &stock, ClearStock: true}); !errors.Is(err, ErrInvalidItem) {
+		t.Errorf("expected ErrInvalidItem, got %v", err)
+	}
+}
+
+func TestCatalogService_DeactivateItem(t *testing.T) {
+	t.Run("active", func(t *testing.T) {
+		svc, mock := newTestCatalogService(t)
+		now := svc.now()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 FOR UPDATE").
+			WithArgs("cup").
+			WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 20, nil, nil, true, now, now))
+		mock.ExpectQuery("UPDATE items").
+			WithArgs(2, "Cup", 20, sql.NullInt64{}, sql.NullInt64{}, false, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 20, nil, nil, false, now, now))
+		mock.ExpectExec("INSERT INTO price_history").
+			WithArgs(2, PriceActionDeactivated, sql.NullInt64{Int64: 20, Valid: true}, 20, sql.NullInt64{Int64: 7, Valid: true}, now).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		it, err := svc.DeactivateItem(7, "cup")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if it.Active {
+			t.Error("expected the item to be deactivated")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+	})
+
+	t.Run("already inactive", func(t *testing.T) {
+		svc, mock := newTestCatalogService(t)
+		now := svc.now()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 FOR UPDATE").
+			WithArgs("cup").
+			WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 20, nil, nil, false, now, now))
+		mock.ExpectRollback()
+
+		if _, err := svc.DeactivateItem(7, "cup"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		svc, mock := newTestCatalogService(t)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 FOR UPDATE").
+			WithArgs("nope").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		if _, err := svc.DeactivateItem(7, "nope"); !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("expected ErrItemNotFound, got %v", err)
+		}
+	})
+}
+
+func TestCatalogService_GetPriceHistory(t *testing.T) {
+	svc, mock := newTestCatalogService(t)
+	now := svc.now()
+
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1").
+		WithArgs("cup").
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).AddRow(2, "cup", "Cup", 25, nil, nil, true, now, now))
+	mock.ExpectQuery("SELECT (.+) FROM price_history WHERE item_id=\\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "action", "old_price", "new_price", "changed_by", "changed_at"}).
+			AddRow(1, 2, PriceActionCreated, nil, 20, nil, now).
+			AddRow(2, 2, PriceActionUpdated, 20, 25, 7, now))
+
+	history, err := svc.GetPriceHistory("cup")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(history))
+	}
+	if history[0].OldPrice != nil || history[0].ChangedBy != nil {
+		t.Errorf("expected no old price and author for the seeded item, got %+v", history[0])
+	}
+	if history[1].OldPrice == nil || *history[1].OldPrice != 20 || history[1].ChangedBy == nil || *history[1].ChangedBy != 7 {
+		t.Errorf("unexpected change: %+v", history[1])
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidGift    = errors.New("invalid gift")
+	ErrNotEnoughItems = errors.New("not enough items")
+)
+
+const maxGiftMessageLength = 200
+
+// GiftRequest gives Quantity of Item to the user named To. The item is bought
+// for them unless FromInventory is set, in which case it is taken from the
+// inventory of the sender.
+type GiftRequest struct {
+	To            string
+	Item          string
+	Quantity      int
+	Message       string
+	FromInventory bool
+}
+
+type Gift struct {
+	ID       int
+	FromUser string
+	ToUser   string
+	Item     string
+	Quantity int
+	Message  string
+	// OrderID is nil and Total 0 for gifts taken from the inventory.
+	OrderID   *int
+	Total     int
+	CreatedAt time.Time
+}
+
+type GiftHistory struct {
+	Received []Gift
+	Sent     []Gift
+}
+
+func toGift(g db.Gift) Gift {
+	return Gift{
+		ID:        g.ID,
+		FromUser:  g.FromUser,
+		ToUser:    g.ToUser,
+		Item:      g.Item,
+		Quantity:  g.Quantity,
+		Message:   g.Message.String,
+		OrderID:   fromNullInt(g.OrderID),
+		CreatedAt: g.CreatedAt,
+	}
+}
+
+func (s *shopService) Gift(fromUserID int, req GiftRequest) (Gift, error) {
+	if req.Quantity < 1 || req.Quantity > maxLineQuantity {
+		return Gift{}, fmt.Errorf("%w: quantity must be 1 to %d", ErrInvalidQuantity, maxLineQuantity)
+	}
+	req.Message = sanitizeText(req.Message)
+	if utf8.RuneCountInString(req.Message) > maxGiftMessageLength {
+		return Gift{}, fmt.Errorf("%w: message must be at most %d characters long", ErrInvalidGift, maxGiftMessageLength)
+	}
+
+	tx, err := s.dbProv.BeginTx()
+	if err != nil {
+		return Gift{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	toUserID, err := s.dbProv.GetUserIDByUsername(tx, req.To)
+	if err != nil {
+		s.log.Warn("recipient not found", zap.String("toUsername", req.To), zap.Error(err))
+		return Gift{}, ErrUserNotFound
+	}
+	if toUserID == fromUserID {
+		return Gift{}, fmt.Errorf("%w: cannot gift to yourself", ErrInvalidGift)
+	}
+	// two users gifting each other at the same time cannot deadlock
+	coins, err := lockCoins(tx, s.dbProv, fromUserID, toUserID)
+	if err != nil {
+		s.log.Error("failed to get user coins for update", zap.Int("fromUserID", fromUserID), zap.Int("toUserID", toUserID), zap.Error(err))
+		return Gift{}, err
+	}
+
+	gift := db.Gift{
+		FromUserID: fromUserID,
+		ToUserID:   toUserID,
+		Item:       req.Item,
+		Quantity:   req.Quantity,
+		Message:    sql.NullString{String: req.Message, Valid: req.Message != ""},
+	}
+	var receipt Receipt
+	if req.FromInventory {
+		err := s.dbProv.DecreaseItem(tx, fromUserID, req.Item, req.Quantity)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Gift{}, fmt.Errorf("%w: %s", ErrNotEnoughItems, req.Item)
+		}
+		if err != nil {
+			s.log.Error("failed to decrease item", zap.Int("userID", fromUserID), zap.String("item", req.Item), zap.Error(err))
+			return Gift{}, err
+		}
+		// items passed along count towards the limit of the recipient as if
+		// they bought them
+		err = checkOwnLimit(tx, s.catalog, s.dbProv, toUserID, req.Item, req.Quantity)
+		if errors.Is(err, ErrPurchaseLimitReached) {
+			return Gift{}, err
+		}
+		if err != nil {
+			s.log.Error("failed to check item limit", zap.Int("userID", toUserID), zap.String("item", req.Item), zap.Error(err))
+			return Gift{}, err
+		}
+		if err := s.dbProv.IncreaseItem(tx, toUserID, req.Item, req.Quantity); err != nil {
+			s.log.Error("failed to increase item", zap.Int("userID", toUserID), zap.String("item", req.Item), zap.Error(err))
+			return Gift{}, err
+		}
+	} else {
+		// the purchase limits apply to the recipient, who ends up owning the item
+		line := PurchaseLine{Item: req.Item, Quantity: req.Quantity}
+		it, err := s.lockItemFor(tx, toUserID, line)
+		if err != nil {
+			return Gift{}, err
+		}
+		receipt, err = s.fulfil(tx, fromUserID, toUserID, coins[fromUserID], []PurchaseLine{line}, []db.Item{it}, "")
+		if err != nil {
+			return Gift{}, err
+		}
+		gift.OrderID = toNullInt(&receipt.OrderID)
+	}
+
+	gift, err = s.gifts.InsertGift(tx, gift)
+	if err != nil {
+		s.log.Error("failed to insert gift", zap.Int("fromUserID", fromUserID), zap.Int("toUserID", toUserID), zap.Error(err))
+		return Gift{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit gift", zap.Error(err))
+		return Gift{}, err
+	}
+	s.log.Info("Gift sent successfully",
+		zap.Int("fromUserID", fromUserID),
+		zap.String("toUsername", req.To),
+		zap.String("item", req.Item),
+		zap.Int("quantity", req.Quantity),
+		zap.Bool("fromInventory", req.FromInventory))
+
+	out := toGift(gift)
+	out.ToUser = req.To
+	out.Total = receipt.Total
+	return out, nil
+}
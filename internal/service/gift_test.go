@@ -0,0 +1,257 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newTestGiftService(t *testing.T) (*shopService, sqlmock.Sqlmock) {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	return &shopService{
+		dbProv:  &coinInventorySQLMock{db: dbConn},
+		catalog: db.NewCatalogDB(dbConn),
+		orders:  db.NewOrderDB(dbConn),
+		gifts:   db.NewGiftDB(dbConn),
+		log:     &mockLogger{},
+	}, mock
+}
+
+// expectGiftStart expects user 1 to gift to bob with the given ID, who is
+// locked after the sender.
+func expectGiftStart(mock sqlmock.Sqlmock, coins, toUserID int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE username=\\$1$").
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(toUserID))
+	if toUserID == 1 {
+		return
+	}
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(coins))
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(toUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(0))
+}
+
+func TestShopService_Gift_Bought(t *testing.T) {
+	svc, mock := newTestGiftService(t)
+
+	expectGiftStart(mock, 100, 2)
+	expectItem(mock, "cup", 20)
+	mock.ExpectExec("UPDATE items SET stock = stock - \\$1").
+		WithArgs(2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// the item goes to the recipient, the order stays with the buyer
+	expectIncreaseItem(mock, 2, "cup", 2)
+	mock.ExpectQuery("INSERT INTO orders").
+		WithArgs(1, sql.NullInt64{Int64: 2, Valid: true}, sql.NullString{}, 0, 40, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO order_lines").
+		WithArgs(5, 1, "cup", "cup", 2, 20, 0, 40).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEntry(mock, db.EntryPurchase,
+		db.Posting{Account: db.UserAccount(1), Amount: -40},
+		db.Posting{Account: db.RevenueAccount, Amount: 40})
+	mock.ExpectQuery("INSERT INTO gifts").
+		WithArgs(1, 2, "cup", 2, sql.NullString{String: "Happy birthday!", Valid: true}, sql.NullInt64{Int64: 5, Valid: true}, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	gift, err := svc.Gift(1, GiftRequest{To: "bob", Item: "cup", Quantity: 2, Message: " Happy\u202e\n birthday!\x00 "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gift.ID != 3 || gift.ToUser != "bob" || gift.Total != 40 || gift.OrderID == nil || *gift.OrderID != 5 {
+		t.Errorf("unexpected gift: %+v", gift)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Gift_FromInventory(t *testing.T) {
+	svc, mock := newTestGiftService(t)
+
+	expectGiftStart(mock, 0, 2)
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(1, 1, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM inventories").
+		WithArgs(1, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectItemLimit(mock, "cup", nil)
+	expectIncreaseItem(mock, 2, "cup", 1)
+	mock.ExpectQuery("INSERT INTO gifts").
+		WithArgs(1, 2, "cup", 1, sql.NullString{}, sql.NullInt64{}, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectCommit()
+
+	gift, err := svc.Gift(1, GiftRequest{To: "bob", Item: "cup", Quantity: 1, FromInventory: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gift.ID != 4 || gift.OrderID != nil || gift.Total != 0 {
+		t.Errorf("unexpected gift: %+v", gift)
+	}
+
+	// the sender does not own enough
+	expectGiftStart(mock, 0, 2)
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(3, 1, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if _, err := svc.Gift(1, GiftRequest{To: "bob", Item: "cup", Quantity: 3, FromInventory: true}); !errors.Is(err, ErrNotEnoughItems) {
+		t.Errorf("expected ErrNotEnoughItems, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Gift_FromInventory_Limit(t *testing.T) {
+	svc, mock := newTestGiftService(t)
+
+	// bob owns 1 cup out of 2 allowed, and is given 2 more
+	expectGiftStart(mock, 0, 2)
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(2, 1, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM inventories").
+		WithArgs(1, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectItemLimit(mock, "cup", 2)
+	mock.ExpectQuery("SELECT quantity FROM inventories WHERE user_id=\\$1 AND item_type=\\$2").
+		WithArgs(2, "cup").
+		WillReturnRows(sqlmock.NewRows([]string{"quantity"}).AddRow(1))
+	mock.ExpectRollback()
+
+	if _, err := svc.Gift(1, GiftRequest{To: "bob", Item: "cup", Quantity: 2, FromInventory: true}); !errors.Is(err, ErrPurchaseLimitReached) {
+		t.Errorf("expected ErrPurchaseLimitReached, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Gift_LocksUsersInOrder(t *testing.T) {
+	svc, mock := newTestGiftService(t)
+
+	// bob gifts user 1, who has the lower ID and is locked first
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE username=\\$1$").
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(0))
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(0))
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(1, 2, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := svc.Gift(2, GiftRequest{To: "alice", Item: "cup", Quantity: 1, FromInventory: true}); !errors.Is(err, ErrNotEnoughItems) {
+		t.Errorf("expected ErrNotEnoughItems, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Gift_Refused(t *testing.T) {
+	svc, mock := newTestGiftService(t)
+
+	if _, err := svc.Gift(1, GiftRequest{To: "bob", Item: "cup", Quantity: 0}); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("expected ErrInvalidQuantity, got %v", err)
+	}
+	long := strings.Repeat("ё", maxGiftMessageLength+1)
+	if _, err := svc.Gift(1, GiftRequest{To: "bob", Item: "cup", Quantity: 1, Message: long}); !errors.Is(err, ErrInvalidGift) {
+		t.Errorf("expected ErrInvalidGift, got %v", err)
+	}
+
+	expectGiftStart(mock, 100, 1)
+	mock.ExpectRollback()
+	if _, err := svc.Gift(1, GiftRequest{To: "bob", Item: "cup", Quantity: 1}); !errors.Is(err, ErrInvalidGift) {
+		t.Errorf("expected ErrInvalidGift for a gift to yourself, got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE username=\\$1$").
+		WithArgs("nobody").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	if _, err := svc.Gift(1, GiftRequest{To: "nobody", Item: "cup", Quantity: 1}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Refund_Gift(t *testing.T) {
+	svc, mock := newTestRefundService(t)
+	boughtAt := svc.now().Add(-time.Hour)
+	expectGiftOrder := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM orders o JOIN order_lines ol ON ol.order_id = o.id WHERE o.id=\\$1 ORDER BY ol.item FOR UPDATE OF o").
+			WithArgs(9).
+			WillReturnRows(sqlmock.NewRows(orderRowColumns).
+				AddRow(9, 1, 2, nil, 0, 20, boughtAt, 1, "cup", "Cup", 1, 20, 0, 20, 0))
+	}
+
+	// the buyer cannot take a gift back
+	expectGiftOrder()
+	mock.ExpectRollback()
+	if _, err := svc.Refund(1, 9, nil); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("expected ErrNotRefundable, got %v", err)
+	}
+
+	// an admin takes it from the recipient and credits the buyer
+	expectGiftOrder()
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(0))
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(1, 2, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM inventories").
+		WithArgs(2, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE items SET stock = stock \\+ \\$1").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO refunds").
+		WithArgs(9, 7, 20, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("UPDATE order_lines SET refunded = refunded \\+ \\$3").
+		WithArgs(9, 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refund_lines").
+		WithArgs(1, 1, "cup", "Cup", 1, 20, 0, 20).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEntry(mock, db.EntryRefund,
+		db.Posting{Account: db.RevenueAccount, Amount: -20},
+		db.Posting{Account: db.UserAccount(1), Amount: 20})
+	mock.ExpectCommit()
+	if _, err := svc.AdminRefund(7, 9, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
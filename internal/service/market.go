@@ -0,0 +1,290 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"avito-shop/pkg"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrListingNotFound = errors.New("listing not found")
+	ErrListingClosed   = errors.New("listing is no longer open")
+	ErrOwnListing      = errors.New("cannot buy your own listing")
+	ErrInvalidPrice    = errors.New("invalid price")
+)
+
+// maxListingPrice keeps prices far from overflowing the coin balances.
+const maxListingPrice = 1_000_000
+
+type Listing struct {
+	ID       int
+	Seller   string
+	Item     string
+	Quantity int
+	Price    int
+	Status   string
+	// Fee is the part of Price kept by the marketplace, set once sold.
+	Fee       int
+	CreatedAt time.Time
+	ClosedAt  *time.Time
+}
+
+// MarketService lets users resell merch to each other. Listed items are held
+// in escrow by the listing, out of the seller's inventory, until the listing
+// is bought or cancelled.
+type MarketService interface {
+	// ListListings returns the open listings, newest first, optionally only
+	// those of one item.
+	ListListings(item string, page Page) ([]Listing, error)
+	CreateListing(userID int, item string, quantity, price int) (Listing, error)
+	// BuyListing pays the price to the seller, less the fee, and gives the
+	// items to the buyer in one transaction. A listing is sold at most once,
+	// and not to buyers it would take over the limit per user of the item.
+	BuyListing(userID, listingID int) (Listing, error)
+	// CancelListing gives the items back to the seller.
+	CancelListing(userID, listingID int) (Listing, error)
+}
+
+type MarketConfig struct {
+	// FeePercent of the price of every sale is kept by the marketplace,
+	// rounded down.
+	FeePercent int
+}
+
+type marketService struct {
+	dbProv     db.CoinInventoryDB
+	catalog    db.CatalogDB
+	listings   db.ListingDB
+	log        pkg.Logger
+	feePercent int
+}
+
+func NewMarketService(dbProv db.CoinInventoryDB, catalog db.CatalogDB, listings db.ListingDB, log pkg.Logger, cfg MarketConfig) MarketService {
+	return &marketService{
+		dbProv:     dbProv,
+		catalog:    catalog,
+		listings:   listings,
+		log:        log,
+		feePercent: cfg.FeePercent,
+	}
+}
+
+func toListing(l db.Listing) Listing {
+	listing := Listing{
+		ID:        l.ID,
+		Seller:    l.Seller,
+		Item:      l.Item,
+		Quantity:  l.Quantity,
+		Price:     l.Price,
+		Status:    l.Status,
+		Fee:       l.Fee,
+		CreatedAt: l.CreatedAt,
+	}
+	if l.ClosedAt.Valid {
+		listing.ClosedAt = &l.ClosedAt.Time
+	}
+	return listing
+}
+
+func (s *marketService) ListListings(item string, page Page) ([]Listing, error) {
+	page, err := page.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	dbListings, err := s.listings.ListOpenListings(item, page.Limit, page.Offset)
+	if err != nil {
+		s.log.Error("failed to list listings", zap.String("item", item), zap.Error(err))
+		return nil, err
+	}
+	listings := make([]Listing, 0, len(dbListings))
+	for _, l := range dbListings {
+		listings = append(listings, toListing(l))
+	}
+	return listings, nil
+}
+
+func (s *marketService) CreateListing(userID int, item string, quantity, price int) (Listing, error) {
+	if quantity < 1 || quantity > maxLineQuantity {
+		return Listing{}, fmt.Errorf("%w: quantity must be 1 to %d", ErrInvalidQuantity, maxLineQuantity)
+	}
+	if price < 1 || price > maxListingPrice {
+		return Listing{}, fmt.Errorf("%w: price must be 1 to %d", ErrInvalidPrice, maxListingPrice)
+	}
+
+	tx, err := s.dbProv.BeginTx()
+	if err != nil {
+		return Listing{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the items go into escrow
+	err = s.dbProv.DecreaseItem(tx, userID, item, quantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Listing{}, fmt.Errorf("%w: %s", ErrNotEnoughItems, item)
+	}
+	if err != nil {
+		s.log.Error("failed to decrease item", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
+		return Listing{}, err
+	}
+	listing, err := s.listings.InsertListing(tx, db.Listing{SellerID: userID, Item: item, Quantity: quantity, Price: price})
+	if err != nil {
+		s.log.Error("failed to insert listing", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
+		return Listing{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit listing", zap.Error(err))
+		return Listing{}, err
+	}
+	s.log.Info("Item listed",
+		zap.Int("userID", userID),
+		zap.Int("listingID", listing.ID),
+		zap.String("item", item),
+		zap.Int("quantity", quantity),
+		zap.Int("price", price))
+	return toListing(listing), nil
+}
+
+// lockOpenListing locks the listing and checks that it can still be closed.
+func (s *marketService) lockOpenListing(tx *sql.Tx, listingID int) (db.Listing, error) {
+	listing, err := s.listings.GetListingForUpdate(tx, listingID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Listing{}, ErrListingNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get listing", zap.Int("listingID", listingID), zap.Error(err))
+		return db.Listing{}, err
+	}
+	if listing.Status != db.ListingOpen {
+		return db.Listing{}, fmt.Errorf("%w: listing %d is %s", ErrListingClosed, listingID, listing.Status)
+	}
+	return listing, nil
+}
+
+// closeListing closes the locked listing. The status is guarded again in the
+// update, so a listing closed behind our back is never closed twice.
+func (s *marketService) closeListing(tx *sql.Tx, listing *db.Listing, status string, buyerID sql.NullInt64, fee int) error {
+	err := s.listings.CloseListing(tx, listing.ID, status, buyerID, fee)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: listing %d", ErrListingClosed, listing.ID)
+	}
+	if err != nil {
+		s.log.Error("failed to close listing", zap.Int("listingID", listing.ID), zap.String("status", status), zap.Error(err))
+		return err
+	}
+	listing.Status = status
+	listing.BuyerID = buyerID
+	listing.Fee = fee
+	listing.ClosedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	return nil
+}
+
+func (s *marketService) BuyListing(userID, listingID int) (Listing, error) {
+	tx, err := s.dbProv.BeginTx()
+	if err != nil {
+		return Listing{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	listing, err := s.lockOpenListing(tx, listingID)
+	if err != nil {
+		return Listing{}, err
+	}
+	if listing.SellerID == userID {
+		return Listing{}, ErrOwnListing
+	}
+
+	// two users buying from each other at the same time cannot deadlock
+	coins, err := lockCoins(tx, s.dbProv, userID, listing.SellerID)
+	if err != nil {
+		s.log.Error("failed to get user coins for update", zap.Int("userID", userID), zap.Int("sellerID", listing.SellerID), zap.Error(err))
+		return Listing{}, err
+	}
+	if coins[userID] < listing.Price {
+		return Listing{}, ErrNotEnoughCoins
+	}
+	// items bought on the market count towards the limit per user too
+	err = checkOwnLimit(tx, s.catalog, s.dbProv, userID, listing.Item, listing.Quantity)
+	if errors.Is(err, ErrPurchaseLimitReached) {
+		return Listing{}, err
+	}
+	if err != nil {
+		s.log.Error("failed to check item limit", zap.Int("userID", userID), zap.String("item", listing.Item), zap.Error(err))
+		return Listing{}, err
+	}
+
+	// the fee is revenue of the shop
+	fee := listing.Price * s.feePercent / 100
+	entry := db.JournalEntry{
+		Kind:      db.EntryMarketSale,
+		ListingID: toNullInt(&listing.ID),
+		Postings:  []db.Posting{{Account: db.UserAccount(userID), Amount: -listing.Price}},
+	}
+	if fee < listing.Price {
+		entry.Postings = append(entry.Postings, db.Posting{Account: db.UserAccount(listing.SellerID), Amount: listing.Price - fee})
+	}
+	if fee > 0 {
+		entry.Postings = append(entry.Postings, db.Posting{Account: db.RevenueAccount, Amount: fee})
+	}
+	if _, err := s.dbProv.PostEntry(tx, entry); err != nil {
+		s.log.Error("failed to post listing sale", zap.Int("userID", userID), zap.Int("listingID", listingID), zap.Error(err))
+		return Listing{}, domainError(err)
+	}
+	if err := s.dbProv.IncreaseItem(tx, userID, listing.Item, listing.Quantity); err != nil {
+		s.log.Error("failed to increase item", zap.Int("userID", userID), zap.String("item", listing.Item), zap.Error(err))
+		return Listing{}, err
+	}
+	if err := s.closeListing(tx, &listing, db.ListingSold, toNullInt(&userID), fee); err != nil {
+		return Listing{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit listing purchase", zap.Error(err))
+		return Listing{}, err
+	}
+	s.log.Info("Listing sold",
+		zap.Int("listingID", listingID),
+		zap.Int("buyerID", userID),
+		zap.Int("sellerID", listing.SellerID),
+		zap.Int("price", listing.Price),
+		zap.Int("fee", fee))
+	return toListing(listing), nil
+}
+
+func (s *marketService) CancelListing(userID, listingID int) (Listing, error) {
+	tx, err := s.dbProv.BeginTx()
+	if err != nil {
+		return Listing{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	listing, err := s.lockOpenListing(tx, listingID)
+	if err != nil {
+		return Listing{}, err
+	}
+	// listings of other users cannot be told apart from missing ones
+	if listing.SellerID != userID {
+		return Listing{}, ErrListingNotFound
+	}
+
+	if err := s.dbProv.IncreaseItem(tx, userID, listing.Item, listing.Quantity); err != nil {
+		s.log.Error("failed to increase item", zap.Int("userID", userID), zap.String("item", listing.Item), zap.Error(err))
+		return Listing{}, err
+	}
+	if err := s.closeListing(tx, &listing, db.ListingCancelled, sql.NullInt64{}, 0); err != nil {
+		return Listing{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit listing cancellation", zap.Error(err))
+		return Listing{}, err
+	}
+	s.log.Info("Listing cancelled", zap.Int("listingID", listingID), zap.Int("userID", userID))
+	return toListing(listing), nil
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var listingRowColumns = []string{"id", "seller_id", "username", "item", "quantity", "price", "status", "buyer_id", "fee", "created_at", "closed_at"}
+
+func newTestMarketService(t *testing.T) (*marketService, sqlmock.Sqlmock) {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	svc := NewMarketService(&coinInventorySQLMock{db: dbConn}, db.NewCatalogDB(dbConn), db.NewListingDB(dbConn), &mockLogger{}, MarketConfig{FeePercent: 5})
+	return svc.(*marketService), mock
+}
+
+// expectListingLocked expects listing 9 of user 3, 2 cups for 150 coins.
+func expectListingLocked(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM listings l JOIN users u ON u.id = l.seller_id WHERE l.id=\\$1 FOR UPDATE OF l").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(listingRowColumns).
+			AddRow(9, 3, "seller", "cup", 2, 150, status, nil, 0, time.Now(), nil))
+}
+
+func expectCoinsLocked(mock sqlmock.Sqlmock, userID, coins int) {
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(coins))
+}
+
+func TestMarketService_CreateListing(t *testing.T) {
+	svc, mock := newTestMarketService(t)
+
+	// the items leave the inventory of the seller
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(2, 3, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM inventories").
+		WithArgs(3, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO listings").
+		WithArgs(3, "cup", 2, 150, db.ListingOpen, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectCommit()
+
+	listing, err := svc.CreateListing(3, "cup", 2, 150)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listing.ID != 9 || listing.Status != db.ListingOpen || listing.Price != 150 {
+		t.Errorf("unexpected listing: %+v", listing)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(5, 3, "cup").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if _, err := svc.CreateListing(3, "cup", 5, 150); !errors.Is(err, ErrNotEnoughItems) {
+		t.Errorf("expected ErrNotEnoughItems, got %v", err)
+	}
+
+	if _, err := svc.CreateListing(3, "cup", 1, 0); !errors.Is(err, ErrInvalidPrice) {
+		t.Errorf("expected ErrInvalidPrice, got %v", err)
+	}
+	if _, err := svc.CreateListing(3, "cup", 0, 10); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("expected ErrInvalidQuantity, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMarketService_BuyListing(t *testing.T) {
+	svc, mock := newTestMarketService(t)
+
+	expectListingLocked(mock, db.ListingOpen)
+	// the seller has the lower ID, so is locked first
+	expectCoinsLocked(mock, 3, 0)
+	expectCoinsLocked(mock, 5, 200)
+	expectItemLimit(mock, "cup", nil)
+	// 5% of 150 is kept by the shop, rounded down
+	expectEntry(mock, db.EntryMarketSale,
+		db.Posting{Account: db.UserAccount(5), Amount: -150},
+		db.Posting{Account: db.UserAccount(3), Amount: 143},
+		db.Posting{Account: db.RevenueAccount, Amount: 7})
+	expectIncreaseItem(mock, 5, "cup", 2)
+	mock.ExpectExec("UPDATE listings SET status=\\$2, buyer_id=\\$3, fee=\\$4, closed_at=\\$5 WHERE id=\\$1 AND status='open'").
+		WithArgs(9, db.ListingSold, sql.NullInt64{Int64: 5, Valid: true}, 7, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	listing, err := svc.BuyListing(5, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listing.Status != db.ListingSold || listing.Fee != 7 || listing.ClosedAt == nil {
+		t.Errorf("unexpected listing: %+v", listing)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMarketService_BuyListing_Refused(t *testing.T) {
+	cases := map[string]struct {
+		buyerID int
+		status  string
+		coins   int
+		want    error
+	}{
+		"sold":             {buyerID: 5, status: db.ListingSold, want: ErrListingClosed},
+		"cancelled":        {buyerID: 5, status: db.ListingCancelled, want: ErrListingClosed},
+		"own listing":      {buyerID: 3, status: db.ListingOpen, want: ErrOwnListing},
+		"not enough coins": {buyerID: 5, status: db.ListingOpen, coins: 149, want: ErrNotEnoughCoins},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			svc, mock := newTestMarketService(t)
+			expectListingLocked(mock, tc.status)
+			if tc.coins > 0 {
+				expectCoinsLocked(mock, 3, 0)
+				expectCoinsLocked(mock, tc.buyerID, tc.coins)
+			}
+			mock.ExpectRollback()
+
+			if _, err := svc.BuyListing(tc.buyerID, 9); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarketService_BuyListing_Limit(t *testing.T) {
+	svc, mock := newTestMarketService(t)
+
+	// the buyer owns 2 cups out of 3 allowed, and the listing is of 2 more
+	expectListingLocked(mock, db.ListingOpen)
+	expectCoinsLocked(mock, 3, 0)
+	expectCoinsLocked(mock, 5, 200)
+	expectItemLimit(mock, "cup", 3)
+	mock.ExpectQuery("SELECT quantity FROM inventories WHERE user_id=\\$1 AND item_type=\\$2").
+		WithArgs(5, "cup").
+		WillReturnRows(sqlmock.NewRows([]string{"quantity"}).AddRow(2))
+	mock.ExpectRollback()
+
+	if _, err := svc.BuyListing(5, 9); !errors.Is(err, ErrPurchaseLimitReached) {
+		t.Fatalf("expected ErrPurchaseLimitReached, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMarketService_BuyListing_ClosedConcurrently(t *testing.T) {
+	svc, mock := newTestMarketService(t)
+
+	// the guarded update is the last line of defence against selling twice
+	expectListingLocked(mock, db.ListingOpen)
+	expectCoinsLocked(mock, 3, 0)
+	expectCoinsLocked(mock, 5, 200)
+	expectItemLimit(mock, "cup", nil)
+	expectEntry(mock, db.EntryMarketSale,
+		db.Posting{Account: db.UserAccount(5), Amount: -150},
+		db.Posting{Account: db.UserAccount(3), Amount: 143},
+		db.Posting{Account: db.RevenueAccount, Amount: 7})
+	expectIncreaseItem(mock, 5, "cup", 2)
+	mock.ExpectExec("UPDATE listings SET status").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := svc.BuyListing(5, 9); !errors.Is(err, ErrListingClosed) {
+		t.Fatalf("expected ErrListingClosed, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMarketService_CancelListing(t *testing.T) {
+	svc, mock := newTestMarketService(t)
+
+	// listings of other users look missing
+	expectListingLocked(mock, db.ListingOpen)
+	mock.ExpectRollback()
+	if _, err := svc.CancelListing(5, 9); !errors.Is(err, ErrListingNotFound) {
+		t.Fatalf("expected ErrListingNotFound, got %v", err)
+	}
+
+	expectListingLocked(mock, db.ListingOpen)
+	expectIncreaseItem(mock, 3, "cup", 2)
+	mock.ExpectExec("UPDATE listings SET status").
+		WithArgs(9, db.ListingCancelled, sql.NullInt64{}, 0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	listing, err := svc.CancelListing(3, 9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listing.Status != db.ListingCancelled {
+		t.Errorf("unexpected listing: %+v", listing)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
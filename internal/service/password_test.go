@@ -0,0 +1,81 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArgon2idHasher_HashAndVerify(t *testing.T) {
+	hash, err := testHasher.Hash("p@ssw0rd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+	if match, needsRehash := testHasher.Verify(hash, "p@ssw0rd"); !match || needsRehash {
+		t.Errorf("expected match without rehash, got match=%v needsRehash=%v", match, needsRehash)
+	}
+	if match, _ := testHasher.Verify(hash, "p@ssw0rd!"); match {
+		t.Errorf("expected mismatch for wrong password")
+	}
+
+	other, err := testHasher.Hash("p@ssw0rd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other == hash {
+		t.Errorf("expected distinct salts for repeated hashes")
+	}
+}
+
+func TestArgon2idHasher_VerifyPlaintext(t *testing.T) {
+	if match, needsRehash := testHasher.Verify("plain", "plain"); !match || !needsRehash {
+		t.Errorf("expected plaintext match with rehash, got match=%v needsRehash=%v", match, needsRehash)
+	}
+	if match, _ := testHasher.Verify("plain", "plain2"); match {
+		t.Errorf("expected plaintext mismatch")
+	}
+}
+
+func TestArgon2idHasher_RehashOnParamsChange(t *testing.T) {
+	hash, err := testHasher.Hash("secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stronger := NewArgon2idHasher(Argon2idParams{Time: 2, MemoryKiB: 64, Threads: 1})
+	if match, needsRehash := stronger.Verify(hash, "secret"); !match || !needsRehash {
+		t.Errorf("expected match with rehash, got match=%v needsRehash=%v", match, needsRehash)
+	}
+}
+
+func TestArgon2idHasher_VerifyMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$salt",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+	} {
+		if match, _ := testHasher.Verify(hash, "secret"); match {
+			t.Errorf("expected mismatch for malformed hash %q", hash)
+		}
+	}
+}
+
+func TestDecodeArgon2idHash_InvalidParams(t *testing.T) {
+	for _, params := range []string{
+		"m=64,t=0,p=1",
+		"m=64,t=1,p=0",
+		"m=0,t=0,p=0",
+		"m=7,t=1,p=1",
+		"m=64,t=1,p=256",
+	} {
+		hash := "$argon2id$v=19$" + params + "$c2FsdA$a2V5"
+		if _, _, _, err := decodeArgon2idHash(hash); err == nil || !strings.Contains(err.Error(), "malformed argon2id parameters") {
+			t.Errorf("%s: expected malformed parameters, got %v", params, err)
+		}
+		if match, _ := testHasher.Verify(hash, "secret"); match {
+			t.Errorf("%s: expected mismatch", params)
+		}
+	}
+}
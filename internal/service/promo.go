@@ -0,0 +1,279 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"avito-shop/pkg"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrPromoNotFound = errors.New("promo code not found")
+	// ErrPromoExpired refuses codes outside of their validity window.
+	ErrPromoExpired = errors.New("promo code is not valid now")
+	// ErrPromoNotApplicable refuses codes for none of the items bought.
+	ErrPromoNotApplicable = errors.New("promo code does not apply to these items")
+	// ErrPromoUsedUp refuses codes redeemed as often as they may be, overall
+	// or by the user.
+	ErrPromoUsedUp  = errors.New("promo code is used up")
+	ErrInvalidPromo = errors.New("invalid promo code")
+	ErrPromoExists  = errors.New("promo code already exists")
+)
+
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9-]{3,50}$`)
+
+// PromoCode takes Value percent (db.PromoPercent) or Value coins (db.PromoFixed)
+// off the items it applies to. Nil limits and bounds are not enforced.
+type PromoCode struct {
+	Code  string
+	Kind  string
+	Value int
+	// Items restricts the code to these items; empty for all of them.
+	Items          []string
+	MaxRedemptions *int
+	MaxPerUser     *int
+	ValidFrom      *time.Time
+	ValidUntil     *time.Time
+	// Redemptions and CreatedAt are ignored by CreatePromoCode.
+	Redemptions int
+	CreatedAt   time.Time
+}
+
+// PromoService lets admins manage promo codes. Codes are redeemed by Buy and
+// Checkout.
+type PromoService interface {
+	CreatePromoCode(adminID int, code PromoCode) (PromoCode, error)
+	ListPromoCodes() ([]PromoCode, error)
+}
+
+type promoService struct {
+	promos db.PromoDB
+	log    pkg.Logger
+}
+
+func NewPromoService(promos db.PromoDB, log pkg.Logger) PromoService {
+	return &promoService{
+		promos: promos,
+		log:    log,
+	}
+}
+
+// normalizePromoCode makes codes case-insensitive.
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func validatePromoCode(c PromoCode) error {
+	if !promoCodePattern.MatchString(c.Code) {
+		return fmt.Errorf("%w: code must be 3 to 50 letters, digits and dashes", ErrInvalidPromo)
+	}
+	switch c.Kind {
+	case db.PromoPercent:
+		if c.Value < 1 || c.Value > 100 {
+			return fmt.Errorf("%w: percent must be 1 to 100", ErrInvalidPromo)
+		}
+	case db.PromoFixed:
+		if c.Value < 1 {
+			return fmt.Errorf("%w: value must be positive", ErrInvalidPromo)
+		}
+	default:
+		return fmt.Errorf("%w: kind must be %s or %s", ErrInvalidPromo, db.PromoPercent, db.PromoFixed)
+	}
+	if c.MaxRedemptions != nil && *c.MaxRedemptions < 1 {
+		return fmt.Errorf("%w: maxRedemptions must be positive", ErrInvalidPromo)
+	}
+	if c.MaxPerUser != nil && *c.MaxPerUser < 1 {
+		return fmt.Errorf("%w: maxPerUser must be positive", ErrInvalidPromo)
+	}
+	if c.ValidFrom != nil && c.ValidUntil != nil && !c.ValidUntil.After(*c.ValidFrom) {
+		return fmt.Errorf("%w: validUntil must be after validFrom", ErrInvalidPromo)
+	}
+	return nil
+}
+
+func toNullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func fromNullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func toPromoCode(c db.PromoCode) PromoCode {
+	return PromoCode{
+		Code:           c.Code,
+		Kind:           c.Kind,
+		Value:          c.Value,
+		Items:          c.Items,
+		MaxRedemptions: fromNullInt(c.MaxRedemptions),
+		MaxPerUser:     fromNullInt(c.MaxPerUser),
+		ValidFrom:      fromNullTime(c.ValidFrom),
+		ValidUntil:     fromNullTime(c.ValidUntil),
+		Redemptions:    c.Redemptions,
+		CreatedAt:      c.CreatedAt,
+	}
+}
+
+func (s *promoService) CreatePromoCode(adminID int, code PromoCode) (PromoCode, error) {
+	code.Code = normalizePromoCode(code.Code)
+	if err := validatePromoCode(code); err != nil {
+		return PromoCode{}, err
+	}
+
+	tx, err := s.promos.BeginTx()
+	if err != nil {
+		return PromoCode{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	c, err := s.promos.InsertPromoCode(tx, db.PromoCode{
+		Code:           code.Code,
+		Kind:           code.Kind,
+		Value:          code.Value,
+		Items:          code.Items,
+		MaxRedemptions: toNullInt(code.MaxRedemptions),
+		MaxPerUser:     toNullInt(code.MaxPerUser),
+		ValidFrom:      toNullTime(code.ValidFrom),
+		ValidUntil:     toNullTime(code.ValidUntil),
+		CreatedBy:      adminID,
+	})
+	switch {
+	case errors.Is(err, db.ErrPromoCodeExists):
+		return PromoCode{}, ErrPromoExists
+	case errors.Is(err, db.ErrPromoItemNotFound):
+		return PromoCode{}, fmt.Errorf("%w: %v", ErrItemNotFound, err)
+	case err != nil:
+		s.log.Error("failed to insert promo code", zap.String("code", code.Code), zap.Error(err))
+		return PromoCode{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit promo code", zap.String("code", code.Code), zap.Error(err))
+		return PromoCode{}, err
+	}
+	s.log.Info("Promo code created", zap.Int("adminID", adminID), zap.String("code", c.Code), zap.String("kind", c.Kind), zap.Int("value", c.Value))
+	return toPromoCode(c), nil
+}
+
+func (s *promoService) ListPromoCodes() ([]PromoCode, error) {
+	codes, err := s.promos.ListPromoCodes()
+	if err != nil {
+		s.log.Error("failed to list promo codes", zap.Error(err))
+		return nil, err
+	}
+	out := make([]PromoCode, 0, len(codes))
+	for _, c := range codes {
+		out = append(out, toPromoCode(c))
+	}
+	return out, nil
+}
+
+// applyPromo locks the promo code, checks that the user may redeem it and
+// takes its discount off the lines it applies to. The code stays locked until
+// the purchase commits, so its limits cannot be exceeded concurrently.
+func (s *shopService) applyPromo(tx *sql.Tx, userID int, code string, lines []db.OrderLine) (db.PromoCode, error) {
+	promo, err := s.promos.GetPromoCodeForUpdate(tx, normalizePromoCode(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.PromoCode{}, ErrPromoNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get promo code", zap.String("code", code), zap.Error(err))
+		return db.PromoCode{}, err
+	}
+
+	now := s.now()
+	if promo.ValidFrom.Valid && now.Before(promo.ValidFrom.Time) || promo.ValidUntil.Valid && !now.Before(promo.ValidUntil.Time) {
+		return db.PromoCode{}, ErrPromoExpired
+	}
+	if promo.MaxRedemptions.Valid && int64(promo.Redemptions) >= promo.MaxRedemptions.Int64 {
+		return db.PromoCode{}, ErrPromoUsedUp
+	}
+	if promo.MaxPerUser.Valid {
+		used, err := s.promos.CountRedemptions(tx, promo.ID, userID)
+		if err != nil {
+			s.log.Error("failed to count redemptions", zap.String("code", promo.Code), zap.Int("userID", userID), zap.Error(err))
+			return db.PromoCode{}, err
+		}
+		if int64(used) >= promo.MaxPerUser.Int64 {
+			return db.PromoCode{}, fmt.Errorf("%w: redeemed %d time(s) already", ErrPromoUsedUp, used)
+		}
+	}
+
+	if !discountLines(promo, lines) {
+		return db.PromoCode{}, ErrPromoNotApplicable
+	}
+	return promo, nil
+}
+
+// discountLines spreads the discount of the code over the lines it applies to
+// in proportion to their totals, and reports whether there were any.
+func discountLines(promo db.PromoCode, lines []db.OrderLine) bool {
+	applies := func(l db.OrderLine) bool {
+		if len(promo.Items) == 0 {
+			return true
+		}
+		for _, item := range promo.Items {
+			if item == l.Item {
+				return true
+			}
+		}
+		return false
+	}
+	subtotal := 0
+	for _, l := range lines {
+		if applies(l) {
+			subtotal += l.Total
+		}
+	}
+	if subtotal == 0 {
+		return false
+	}
+
+	discount := promo.Value
+	if promo.Kind == db.PromoPercent {
+		discount = subtotal * promo.Value / 100
+	}
+	discount = min(discount, subtotal)
+
+	// rounding down leaves less than a coin per line, given to the first lines
+	left := discount
+	for i := range lines {
+		if applies(lines[i]) {
+			lines[i].Discount = lines[i].Total * discount / subtotal
+			left -= lines[i].Discount
+		}
+	}
+	for i := range lines {
+		if left > 0 && applies(lines[i]) && lines[i].Discount < lines[i].Total {
+			lines[i].Discount++
+			left--
+		}
+		lines[i].Total -= lines[i].Discount
+	}
+	return true
+}
+
+// redeemPromo records the use of the code applied by applyPromo to the order.
+func (s *shopService) redeemPromo(tx *sql.Tx, promo db.PromoCode, userID int, order db.Order) error {
+	err := s.promos.InsertRedemption(tx, promo.ID, userID, order.ID, order.Discount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPromoUsedUp
+	}
+	if err != nil {
+		s.log.Error("failed to redeem promo code", zap.String("code", promo.Code), zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	return nil
+}
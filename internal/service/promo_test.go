@@ -0,0 +1,180 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var promoRowColumns = []string{"id", "code", "kind", "value", "max_redemptions", "max_per_user", "redemptions", "valid_from", "valid_until", "created_by", "created_at"}
+
+// expectPromo expects the promo code to be locked; it applies to the items
+// given, or to all of them.
+func expectPromo(mock sqlmock.Sqlmock, code, kind string, value int, maxRedemptions, maxPerUser any, redemptions int, validUntil any, items ...string) {
+	mock.ExpectQuery("SELECT (.+) FROM promo_codes p WHERE p.code=\\$1 FOR UPDATE").
+		WithArgs(code).
+		WillReturnRows(sqlmock.NewRows(promoRowColumns).
+			AddRow(4, code, kind, value, maxRedemptions, maxPerUser, redemptions, nil, validUntil, 7, time.Now()))
+	rows := sqlmock.NewRows([]string{"slug"})
+	for _, item := range items {
+		rows.AddRow(item)
+	}
+	mock.ExpectQuery("SELECT i.slug FROM promo_code_items").WithArgs(4).WillReturnRows(rows)
+}
+
+func newPromoShopService(dbConn *sql.DB, now time.Time) *shopService {
+	return &shopService{
+		dbProv:  &coinInventorySQLMock{db: dbConn},
+		catalog: db.NewCatalogDB(dbConn),
+		orders:  db.NewOrderDB(dbConn),
+		promos:  db.NewPromoDB(dbConn),
+		log:     &mockLogger{},
+		now:     func() time.Time { return now },
+	}
+}
+
+func TestShopService_Buy_PromoCode(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer dbConn.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+	expectItem(mock, "cup", 20)
+	expectItem(mock, "socks", 10)
+	// the code is case-insensitive and only takes 10% off the cups
+	expectPromo(mock, "SPRING", db.PromoPercent, 10, 5, 1, 2, nil, "cup")
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM promo_redemptions WHERE promo_code_id=\\$1 AND user_id=\\$2").
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	for _, l := range []struct {
+		item     string
+		quantity int
+	}{{"cup", 2}, {"socks", 3}} {
+		mock.ExpectExec("UPDATE items SET stock = stock - \\$1").
+			WithArgs(l.quantity, 1).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectIncreaseItem(mock, 1, l.item, l.quantity)
+	}
+	mock.ExpectQuery("INSERT INTO orders").
+		WithArgs(1, sql.NullInt64{}, sql.NullString{String: "SPRING", Valid: true}, 4, 66, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("INSERT INTO order_lines").
+		WithArgs(9, 1, "cup", "cup", 2, 20, 4, 36).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_lines").
+		WithArgs(9, 1, "socks", "socks", 3, 10, 0, 30).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEntry(mock, db.EntryPurchase,
+		db.Posting{Account: db.UserAccount(1), Amount: -66},
+		db.Posting{Account: db.RevenueAccount, Amount: 66})
+	mock.ExpectExec("UPDATE promo_codes SET redemptions = redemptions \\+ 1").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO promo_redemptions").
+		WithArgs(4, 1, 9, 4, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	svc := newPromoShopService(dbConn, time.Now())
+	receipt, err := svc.Buy(1, []PurchaseLine{{"socks", 3}, {"cup", 2}}, "spring")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if receipt.PromoCode != "SPRING" || receipt.Discount != 4 || receipt.Total != 66 || receipt.Balance != 34 || receipt.Lines[0].Discount != 4 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Buy_PromoRefused(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		expect func(sqlmock.Sqlmock)
+		want   error
+	}{
+		{"unknown", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT (.+) FROM promo_codes p").WillReturnError(sql.ErrNoRows)
+		}, ErrPromoNotFound},
+		{"expired", func(mock sqlmock.Sqlmock) {
+			expectPromo(mock, "SPRING", db.PromoFixed, 5, nil, nil, 0, now.Add(-time.Hour))
+		}, ErrPromoExpired},
+		{"other items", func(mock sqlmock.Sqlmock) {
+			expectPromo(mock, "SPRING", db.PromoFixed, 5, nil, nil, 0, nil, "hoody")
+		}, ErrPromoNotApplicable},
+		{"used up", func(mock sqlmock.Sqlmock) {
+			expectPromo(mock, "SPRING", db.PromoFixed, 5, 10, nil, 10, nil)
+		}, ErrPromoUsedUp},
+		{"used up by the user", func(mock sqlmock.Sqlmock) {
+			expectPromo(mock, "SPRING", db.PromoFixed, 5, nil, 1, 3, nil)
+			mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM promo_redemptions").
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		}, ErrPromoUsedUp},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbConn, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer dbConn.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+			expectItem(mock, "cup", 20)
+			tc.expect(mock)
+			mock.ExpectRollback()
+
+			svc := newPromoShopService(dbConn, now)
+			if _, err := svc.Buy(1, []PurchaseLine{{"cup", 1}}, "SPRING"); !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestDiscountLines(t *testing.T) {
+	cases := []struct {
+		name  string
+		promo db.PromoCode
+		want  []int
+	}{
+		// 10 coins over 30 and 40 coins leave a coin to the first line
+		{"fixed", db.PromoCode{Kind: db.PromoFixed, Value: 10}, []int{5, 5}},
+		{"fixed over total", db.PromoCode{Kind: db.PromoFixed, Value: 500}, []int{30, 40}},
+		{"percent", db.PromoCode{Kind: db.PromoPercent, Value: 20, Items: []string{"cup", "socks"}}, []int{6, 8}},
+		{"percent of one item", db.PromoCode{Kind: db.PromoPercent, Value: 50, Items: []string{"socks"}}, []int{0, 20}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lines := []db.OrderLine{
+				{Item: "cup", Quantity: 3, Price: 10, Total: 30},
+				{Item: "socks", Quantity: 4, Price: 10, Total: 40},
+			}
+			if !discountLines(tc.promo, lines) {
+				t.Fatal("expected the code to apply")
+			}
+			for i, l := range lines {
+				if l.Discount != tc.want[i] || l.Total != l.Price*l.Quantity-l.Discount {
+					t.Errorf("line %s: expected discount %d, got %+v", l.Item, tc.want[i], l)
+				}
+			}
+		})
+	}
+}
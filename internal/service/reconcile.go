@@ -0,0 +1,139 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"avito-shop/pkg"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Discrepancy is a user whose coins differ from the balance recomputed from
+// the ledger.
+type Discrepancy struct {
+	UserID   int
+	Username string
+	Coins    int
+	Expected int
+	// NoAccount is set for users without a ledger account. They have no
+	// expected balance and are never repaired.
+	NoAccount bool
+	// Repaired is set once the coins of the user agree with the ledger.
+	Repaired bool
+}
+
+type ReconcileReport struct {
+	CheckedAt time.Time
+	// Users is the number of users checked.
+	Users         int
+	Discrepancies []Discrepancy
+	// UnbalancedEntries are the IDs of journal entries that do not sum up to
+	// zero. The ledger cannot be trusted then, so nothing is repaired.
+	UnbalancedEntries []int
+	Repair            bool
+}
+
+// Unresolved returns the discrepancies left unrepaired.
+func (r ReconcileReport) Unresolved() []Discrepancy {
+	var left []Discrepancy
+	for _, d := range r.Discrepancies {
+		if !d.Repaired {
+			left = append(left, d)
+		}
+	}
+	return left
+}
+
+// ReconcileService checks the coins cached in users.coins against the ledger,
+// which is the record of every balance change.
+type ReconcileService interface {
+	// Reconcile reports the users whose coins differ from their ledger
+	// balance. Nothing is changed unless repair is set, in which case their
+	// coins are set to the ledger balance.
+	Reconcile(repair bool) (ReconcileReport, error)
+}
+
+type reconcileService struct {
+	ledger db.LedgerDB
+	log    pkg.Logger
+	now    func() time.Time
+}
+
+func NewReconcileService(ledger db.LedgerDB, log pkg.Logger) ReconcileService {
+	return &reconcileService{
+		ledger: ledger,
+		log:    log,
+		now:    time.Now,
+	}
+}
+
+func (s *reconcileService) Reconcile(repair bool) (ReconcileReport, error) {
+	report := ReconcileReport{CheckedAt: s.now().UTC(), Repair: repair}
+
+	unbalanced, err := s.ledger.ListUnbalancedEntries()
+	if err != nil {
+		s.log.Error("failed to list unbalanced entries", zap.Error(err))
+		return ReconcileReport{}, err
+	}
+	report.UnbalancedEntries = unbalanced
+
+	balances, err := s.ledger.ListBalances()
+	if err != nil {
+		s.log.Error("failed to list balances", zap.Error(err))
+		return ReconcileReport{}, err
+	}
+	report.Users = len(balances)
+	for _, b := range balances {
+		if b.HasAccount && b.Coins == b.Ledger {
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{
+			UserID:    b.UserID,
+			Username:  b.Username,
+			Coins:     b.Coins,
+			Expected:  b.Ledger,
+			NoAccount: !b.HasAccount,
+		})
+	}
+
+	if !repair {
+		return report, nil
+	}
+	if len(unbalanced) > 0 {
+		s.log.Warn("not repairing coins, the ledger is unbalanced", zap.Ints("entryIDs", unbalanced))
+		return report, nil
+	}
+	for i := range report.Discrepancies {
+		d := &report.Discrepancies[i]
+		if !d.NoAccount {
+			d.Repaired = s.repair(d.UserID)
+		}
+	}
+	return report, nil
+}
+
+// repair sets the coins of the user to their ledger balance, each user in a
+// transaction of their own. It returns false if the user could not be
+// repaired.
+func (s *reconcileService) repair(userID int) bool {
+	tx, err := s.ledger.BeginTx()
+	if err != nil {
+		s.log.Error("failed to begin transaction", zap.Error(err))
+		return false
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	b, err := s.ledger.RepairCoins(tx, userID)
+	if err != nil {
+		s.log.Error("failed to repair coins", zap.Int("userID", userID), zap.Error(err))
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit repair", zap.Int("userID", userID), zap.Error(err))
+		return false
+	}
+	if b.Coins != b.Ledger {
+		s.log.Info("repaired coins", zap.Int("userID", userID), zap.Int("coins", b.Coins), zap.Int("ledger", b.Ledger))
+	}
+	return true
+}
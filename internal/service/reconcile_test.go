@@ -0,0 +1,116 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var balanceRowColumns = []string{"id", "username", "coins", "ledger", "has_account"}
+
+func newTestReconcileService(t *testing.T) (*reconcileService, sqlmock.Sqlmock) {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	svc := NewReconcileService(db.NewLedgerDB(dbConn), &mockLogger{})
+	return svc.(*reconcileService), mock
+}
+
+// expectBalances expects alice to agree with the ledger, bob to have 50 coins
+// too many and carol to have no account.
+func expectBalances(mock sqlmock.Sqlmock, unbalanced ...int) {
+	entries := sqlmock.NewRows([]string{"id"})
+	for _, id := range unbalanced {
+		entries.AddRow(id)
+	}
+	mock.ExpectQuery("SELECT e.id FROM journal_entries e").WillReturnRows(entries)
+	mock.ExpectQuery("SELECT u.id, u.username, u.coins, (.+) GROUP BY u.id, a.id ORDER BY u.id").
+		WillReturnRows(sqlmock.NewRows(balanceRowColumns).
+			AddRow(1, "alice", 1000, 1000, true).
+			AddRow(2, "bob", 1050, 1000, true).
+			AddRow(3, "carol", 1000, 0, false))
+}
+
+func TestReconcileService_DryRun(t *testing.T) {
+	svc, mock := newTestReconcileService(t)
+	expectBalances(mock)
+
+	report, err := svc.Reconcile(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Discrepancy{
+		{UserID: 2, Username: "bob", Coins: 1050, Expected: 1000},
+		{UserID: 3, Username: "carol", Coins: 1000, NoAccount: true},
+	}
+	if report.Users != 3 || len(report.Discrepancies) != len(want) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for i, d := range report.Discrepancies {
+		if d != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], d)
+		}
+	}
+	if len(report.Unresolved()) != 2 {
+		t.Errorf("expected both discrepancies to be unresolved, got %+v", report.Unresolved())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestReconcileService_Repair(t *testing.T) {
+	svc, mock := newTestReconcileService(t)
+	expectBalances(mock)
+	// only bob is repaired, carol has nothing to be repaired to
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT id FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT u.id, u.username, u.coins, (.+) WHERE u.id=\\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(balanceRowColumns).AddRow(2, "bob", 1050, 1000, true))
+	mock.ExpectExec("UPDATE users SET coins=\\$2 WHERE id=\\$1").
+		WithArgs(2, 1000).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	svc.now = func() time.Time { return time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) }
+	report, err := svc.Reconcile(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	left := report.Unresolved()
+	if !report.Discrepancies[0].Repaired || len(left) != 1 || left[0].UserID != 3 {
+		t.Errorf("expected only carol to be left, got %+v", report.Discrepancies)
+	}
+	if !report.CheckedAt.Equal(svc.now()) {
+		t.Errorf("unexpected check time %v", report.CheckedAt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestReconcileService_Repair_UnbalancedLedger(t *testing.T) {
+	svc, mock := newTestReconcileService(t)
+	// a broken ledger is no ground to change any coins
+	expectBalances(mock, 7)
+
+	report, err := svc.Reconcile(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.UnbalancedEntries) != 1 || report.UnbalancedEntries[0] != 7 || len(report.Unresolved()) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrRefundWindowClosed = errors.New("refund window has closed")
+	// ErrNotRefundable refuses lines that were refunded already or whose
+	// items the user no longer owns.
+	ErrNotRefundable = errors.New("cannot be refunded")
+)
+
+// Refund is money returned for lines of an order, at the prices paid.
+type Refund struct {
+	ID        int
+	OrderID   int
+	Lines     []ReceiptLine
+	Total     int
+	CreatedAt time.Time
+}
+
+func toRefund(r db.Refund) Refund {
+	return Refund{
+		ID:        r.ID,
+		OrderID:   r.OrderID,
+		Lines:     toReceiptLines(r.Lines),
+		Total:     r.Total,
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+func (s *shopService) Refund(userID, orderID int, lines []PurchaseLine) (Refund, error) {
+	return s.refund(userID, orderID, lines, false)
+}
+
+func (s *shopService) AdminRefund(adminID, orderID int, lines []PurchaseLine) (Refund, error) {
+	return s.refund(adminID, orderID, lines, true)
+}
+
+// refund returns the lines of the order on behalf of byUserID, who must own
+// the order unless admin is set.
+func (s *shopService) refund(byUserID, orderID int, lines []PurchaseLine, admin bool) (Refund, error) {
+	if lines != nil {
+		var err error
+		if lines, err = mergeLines(lines); err != nil {
+			return Refund{}, err
+		}
+	}
+
+	tx, err := s.dbProv.BeginTx()
+	if err != nil {
+		return Refund{}, fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	order, err := s.orders.GetOrderForUpdate(tx, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Refund{}, ErrOrderNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get order for update", zap.Int("orderID", orderID), zap.Error(err))
+		return Refund{}, err
+	}
+	if !admin {
+		if order.UserID != byUserID {
+			return Refund{}, ErrOrderNotFound
+		}
+		if s.now().Sub(order.CreatedAt) > s.refundWindow {
+			return Refund{}, ErrRefundWindowClosed
+		}
+		if order.RecipientID.Valid {
+			return Refund{}, fmt.Errorf("%w: gifts can only be refunded by an admin", ErrNotRefundable)
+		}
+	}
+	// gifts are taken back from the recipient, the coins go to the buyer
+	ownerID := order.UserID
+	if order.RecipientID.Valid {
+		ownerID = int(order.RecipientID.Int64)
+	}
+
+	refund, err := refundLines(order, lines)
+	if err != nil {
+		return Refund{}, err
+	}
+	refund.RefundedBy = byUserID
+
+	if _, err := s.dbProv.GetCoinsForUpdate(tx, order.UserID); err != nil {
+		s.log.Error("failed to get user coins for update", zap.Int("userID", order.UserID), zap.Error(err))
+		return Refund{}, err
+	}
+	for _, l := range refund.Lines {
+		err := s.dbProv.DecreaseItem(tx, ownerID, l.Item, l.Quantity)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Refund{}, fmt.Errorf("%w: %s is no longer owned", ErrNotRefundable, l.Item)
+		}
+		if err != nil {
+			s.log.Error("failed to decrease item", zap.Int("userID", ownerID), zap.String("item", l.Item), zap.Error(err))
+			return Refund{}, err
+		}
+		if err := s.catalog.IncreaseStock(tx, l.ItemID, l.Quantity); err != nil {
+			s.log.Error("failed to increase stock", zap.String("item", l.Item), zap.Error(err))
+			return Refund{}, err
+		}
+	}
+	refund, err = s.orders.InsertRefund(tx, refund)
+	if err != nil {
+		s.log.Error("failed to insert refund", zap.Int("orderID", orderID), zap.Error(err))
+		return Refund{}, err
+	}
+	// the shop pays back the buyer, also for gifts
+	if refund.Total > 0 {
+		entry := moveCoins(db.EntryRefund, db.RevenueAccount, db.UserAccount(order.UserID), refund.Total)
+		entry.OrderID = toNullInt(&order.ID)
+		entry.RefundID = toNullInt(&refund.ID)
+		if _, err := s.dbProv.PostEntry(tx, entry); err != nil {
+			s.log.Error("failed to post refund", zap.Int("userID", order.UserID), zap.Int("orderID", orderID), zap.Error(err))
+			return Refund{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit refund", zap.Int("orderID", orderID), zap.Error(err))
+		return Refund{}, err
+	}
+	s.log.Info("Order refunded",
+		zap.Int("orderID", orderID),
+		zap.Int("userID", order.UserID),
+		zap.Int("refundedBy", byUserID),
+		zap.Bool("admin", admin),
+		zap.Int("total", refund.Total))
+	return toRefund(refund), nil
+}
+
+// refundLines prices the lines to refund at what was paid for them in the
+// order. Nil lines refund everything that was not refunded yet.
+func refundLines(order db.Order, lines []PurchaseLine) (db.Refund, error) {
+	refund := db.Refund{OrderID: order.ID}
+	add := func(ol db.OrderLine, quantity int) {
+		// discounted lines are refunded pro rata, rounding down until the
+		// last unit, so a line never returns more than was paid for it
+		paid := func(units int) int { return ol.Total * units / ol.Quantity }
+		ol.Total = paid(ol.Refunded+quantity) - paid(ol.Refunded)
+		ol.Discount = ol.Price*quantity - ol.Total
+		ol.Quantity = quantity
+		ol.Refunded = 0
+		refund.Lines = append(refund.Lines, ol)
+		refund.Total += ol.Total
+	}
+
+	if lines == nil {
+		for _, ol := range order.Lines {
+			if left := ol.Quantity - ol.Refunded; left > 0 {
+				add(ol, left)
+			}
+		}
+		if len(refund.Lines) == 0 {
+			return db.Refund{}, fmt.Errorf("%w: order %d was refunded already", ErrNotRefundable, order.ID)
+		}
+		return refund, nil
+	}
+
+	for _, l := range lines {
+		i := 0
+		for i < len(order.Lines) && order.Lines[i].Item != l.Item {
+			i++
+		}
+		if i == len(order.Lines) {
+			return db.Refund{}, fmt.Errorf("%w: %s is not in order %d", ErrInvalidQuantity, l.Item, order.ID)
+		}
+		ol := order.Lines[i]
+		if left := ol.Quantity - ol.Refunded; l.Quantity > left {
+			return db.Refund{}, fmt.Errorf("%w: only %d of %s are left to refund", ErrNotRefundable, left, l.Item)
+		}
+		add(ol, l.Quantity)
+	}
+	return refund, nil
+}
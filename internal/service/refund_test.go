@@ -0,0 +1,167 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newTestRefundService(t *testing.T) (*shopService, sqlmock.Sqlmock) {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	now := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	return &shopService{
+		dbProv:       &coinInventorySQLMock{db: dbConn},
+		catalog:      db.NewCatalogDB(dbConn),
+		orders:       db.NewOrderDB(dbConn),
+		log:          &mockLogger{},
+		refundWindow: 7 * 24 * time.Hour,
+		now:          func() time.Time { return now },
+	}, mock
+}
+
+// expectOrderLocked expects order 9 of user 1 bought at the given time: 2 cups
+// at 20, refunded of them already, and 3 pairs of socks at 10.
+func expectOrderLocked(mock sqlmock.Sqlmock, boughtAt time.Time, refunded int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM orders o JOIN order_lines ol ON ol.order_id = o.id WHERE o.id=\\$1 ORDER BY ol.item FOR UPDATE OF o").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).
+			AddRow(9, 1, nil, nil, 0, 70, boughtAt, 1, "cup", "Cup", 2, 20, 0, 40, refunded).
+			AddRow(9, 1, nil, nil, 0, 70, boughtAt, 2, "socks", "Socks", 3, 10, 0, 30, 0))
+}
+
+func TestShopService_Refund(t *testing.T) {
+	svc, mock := newTestRefundService(t)
+
+	expectOrderLocked(mock, svc.now().Add(-24*time.Hour), 1)
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(30))
+	// without lines, whatever was not refunded yet is: the second cup and the socks
+	left := []db.OrderLine{
+		{ItemID: 1, Item: "cup", Name: "Cup", Quantity: 1, Price: 20, Total: 20},
+		{ItemID: 2, Item: "socks", Name: "Socks", Quantity: 3, Price: 10, Total: 30},
+	}
+	for _, l := range left {
+		mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+			WithArgs(l.Quantity, 1, l.Item).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM inventories").
+			WithArgs(1, l.Item).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE items SET stock = stock \\+ \\$1").
+			WithArgs(l.Quantity, l.ItemID).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectQuery("INSERT INTO refunds").
+		WithArgs(9, 1, 50, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	for _, l := range left {
+		mock.ExpectExec("UPDATE order_lines SET refunded = refunded \\+ \\$3").
+			WithArgs(9, l.ItemID, l.Quantity).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO refund_lines").
+			WithArgs(3, l.ItemID, l.Item, l.Name, l.Quantity, l.Price, 0, l.Total).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	// the lines are credited at the prices of the order, whatever they cost now
+	expectEntry(mock, db.EntryRefund,
+		db.Posting{Account: db.RevenueAccount, Amount: -50},
+		db.Posting{Account: db.UserAccount(1), Amount: 50})
+	mock.ExpectCommit()
+
+	refund, err := svc.Refund(1, 9, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refund.ID != 3 || refund.Total != 50 || len(refund.Lines) != 2 || refund.Lines[0].Quantity != 1 {
+		t.Errorf("unexpected refund: %+v", refund)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Refund_Refused(t *testing.T) {
+	cases := map[string]struct {
+		userID   int
+		age      time.Duration
+		refunded int
+		lines    []PurchaseLine
+		want     error
+	}{
+		"another user":     {userID: 2, age: time.Hour, want: ErrOrderNotFound},
+		"window closed":    {userID: 1, age: 8 * 24 * time.Hour, want: ErrRefundWindowClosed},
+		"already refunded": {userID: 1, age: time.Hour, refunded: 2, lines: []PurchaseLine{{"cup", 1}}, want: ErrNotRefundable},
+		"not in the order": {userID: 1, age: time.Hour, lines: []PurchaseLine{{"pen", 1}}, want: ErrInvalidQuantity},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			svc, mock := newTestRefundService(t)
+			expectOrderLocked(mock, svc.now().Add(-tc.age), tc.refunded)
+			mock.ExpectRollback()
+
+			if _, err := svc.Refund(tc.userID, 9, tc.lines); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestShopService_AdminRefund_NoLongerOwned(t *testing.T) {
+	svc, mock := newTestRefundService(t)
+
+	// admins are not bound by the window, but the items must still be there
+	expectOrderLocked(mock, svc.now().Add(-365*24*time.Hour), 0)
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(30))
+	mock.ExpectExec("UPDATE inventories SET quantity = quantity - \\$1").
+		WithArgs(3, 1, "socks").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if _, err := svc.AdminRefund(7, 9, []PurchaseLine{{"socks", 3}}); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("expected ErrNotRefundable, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestRefundLines_Discounted(t *testing.T) {
+	// 3 pairs of socks at 10 bought for 25 with a promo code
+	order := db.Order{ID: 9, Lines: []db.OrderLine{
+		{ItemID: 2, Item: "socks", Name: "Socks", Quantity: 3, Price: 10, Discount: 5, Total: 25},
+	}}
+
+	refund, err := refundLines(order, []PurchaseLine{{"socks", 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refund.Total != 8 || refund.Lines[0].Total != 8 || refund.Lines[0].Discount != 2 {
+		t.Errorf("unexpected refund of the first pair: %+v", refund)
+	}
+
+	// the rest returns what is left of what was paid
+	order.Lines[0].Refunded = 1
+	refund, err = refundLines(order, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if refund.Total != 17 || refund.Lines[0].Quantity != 2 || refund.Lines[0].Discount != 3 {
+		t.Errorf("unexpected refund of the rest: %+v", refund)
+	}
+}
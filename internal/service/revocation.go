@@ -0,0 +1,214 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"avito-shop/pkg"
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxCachedRevocations bounds the token and the user cache. Once either is
+// full, an arbitrary entry makes room for a new one; lookups of dropped
+// entries fall back to the database.
+const maxCachedRevocations = 10000
+
+type RevocationStore interface {
+	// RevokeToken rejects the access token with the given ID until it expires.
+	RevokeToken(tokenID string, userID int, expiresAt time.Time) error
+
+	// RevokeUser rejects every token issued to the user so far, including
+	// refresh tokens.
+	RevokeUser(userID int) error
+
+	IsRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error)
+}
+
+type cachedToken struct {
+	revoked bool
+	until   time.Time
+}
+
+type cachedUser struct {
+	revokedAt sql.NullTime
+	until     time.Time
+}
+
+// revocationStore keeps revocations in Postgres and caches lookups in process.
+// Revocations made by this instance are visible immediately, revocations made
+// by other instances after at most cacheTTL.
+type revocationStore struct {
+	revDB    db.RevocationDB
+	tokenDB  db.RefreshTokenDB
+	log      pkg.Logger
+	cacheTTL time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	users  map[int]cachedUser
+}
+
+func NewRevocationStore(revDB db.RevocationDB, tokenDB db.RefreshTokenDB, logger pkg.Logger, cacheTTL time.Duration) RevocationStore {
+	return &revocationStore{
+		revDB:    revDB,
+		tokenDB:  tokenDB,
+		log:      logger,
+		cacheTTL: cacheTTL,
+		now:      time.Now,
+		tokens:   map[string]cachedToken{},
+		users:    map[int]cachedUser{},
+	}
+}
+
+func (r *revocationStore) RevokeToken(tokenID string, userID int, expiresAt time.Time) error {
+	if err := r.revDB.InsertRevokedToken(tokenID, userID, expiresAt); err != nil {
+		r.log.Error("failed to revoke token", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	r.mu.Lock()
+	r.cacheToken(tokenID, cachedToken{revoked: true, until: expiresAt})
+	r.mu.Unlock()
+	r.log.Info("Token revoked", zap.Int("userID", userID), zap.String("tokenID", tokenID))
+	return nil
+}
+
+func (r *revocationStore) RevokeUser(userID int) error {
+	revokedAt := r.now()
+
+	tx, err := r.revDB.BeginTx()
+	if err != nil {
+		return fmt.Errorf("failed to begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := r.revDB.SetSessionsRevokedAt(tx, userID, revokedAt); err != nil {
+		r.log.Error("failed to revoke user sessions", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	if err := r.tokenDB.RevokeUserRefreshTokens(tx, userID); err != nil {
+		r.log.Error("failed to revoke user refresh tokens", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		r.log.Error("failed to commit sessions revocation", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+
+	r.mu.Lock()
+	r.cacheUser(userID, cachedUser{
+		revokedAt: sql.NullTime{Time: revokedAt, Valid: true},
+		until:     revokedAt.Add(r.cacheTTL),
+	})
+	r.mu.Unlock()
+	r.log.Info("All sessions revoked", zap.Int("userID", userID))
+	return nil
+}
+
+func (r *revocationStore) IsRevoked(tokenID string, userID int, issuedAt time.Time) (bool, error) {
+	revokedAt, err := r.sessionsRevokedAt(userID)
+	if err != nil {
+		return false, err
+	}
+	// iat has second precision, so a token issued in the same second as the
+	// revocation is treated as revoked
+	if revokedAt.Valid && !issuedAt.After(revokedAt.Time.Truncate(time.Second)) {
+		return true, nil
+	}
+	return r.tokenRevoked(tokenID)
+}
+
+func (r *revocationStore) sessionsRevokedAt(userID int) (sql.NullTime, error) {
+	now := r.now()
+	r.mu.Lock()
+	cached, ok := r.users[userID]
+	r.mu.Unlock()
+	if ok && now.Before(cached.until) {
+		return cached.revokedAt, nil
+	}
+
+	revokedAt, err := r.revDB.GetSessionsRevokedAt(userID)
+	if err != nil {
+		r.log.Error("failed to get sessions revocation", zap.Int("userID", userID), zap.Error(err))
+		return sql.NullTime{}, err
+	}
+	r.mu.Lock()
+	r.cacheUser(userID, cachedUser{revokedAt: revokedAt, until: now.Add(r.cacheTTL)})
+	r.mu.Unlock()
+	return revokedAt, nil
+}
+
+func (r *revocationStore) tokenRevoked(tokenID string) (bool, error) {
+	now := r.now()
+	r.mu.Lock()
+	cached, ok := r.tokens[tokenID]
+	r.mu.Unlock()
+	if ok && now.Before(cached.until) {
+		return cached.revoked, nil
+	}
+
+	revoked, err := r.revDB.IsTokenRevoked(tokenID)
+	if err != nil {
+		r.log.Error("failed to check token revocation", zap.String("tokenID", tokenID), zap.Error(err))
+		return false, err
+	}
+	r.mu.Lock()
+	r.cacheToken(tokenID, cachedToken{revoked: revoked, until: now.Add(r.cacheTTL)})
+	r.mu.Unlock()
+	return revoked, nil
+}
+
+// PruneRevokedTokens deletes the revocations of expired tokens every interval
+// until ctx is done. Expired tokens are refused anyway, so their revocations
+// only take up space.
+func PruneRevokedTokens(ctx context.Context, revDB db.RevocationDB, logger pkg.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			pruneRevokedTokens(revDB, logger, now)
+		}
+	}
+}
+
+func pruneRevokedTokens(revDB db.RevocationDB, logger pkg.Logger, now time.Time) {
+	n, err := revDB.DeleteExpiredRevokedTokens(now)
+	if err != nil {
+		logger.Warn("failed to delete expired revoked tokens", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		logger.Info("Expired revoked tokens deleted", zap.Int64("count", n))
+	}
+}
+
+// cacheToken must be called with mu held.
+func (r *revocationStore) cacheToken(tokenID string, entry cachedToken) {
+	makeRoom(r.tokens, tokenID)
+	r.tokens[tokenID] = entry
+}
+
+// cacheUser must be called with mu held.
+func (r *revocationStore) cacheUser(userID int, entry cachedUser) {
+	makeRoom(r.users, userID)
+	r.users[userID] = entry
+}
+
+// makeRoom drops an arbitrary entry of a full cache unless key is already in
+// it, so that the cache stays within maxCachedRevocations.
+func makeRoom[K comparable, V any](cache map[K]V, key K) {
+	if _, ok := cache[key]; ok || len(cache) < maxCachedRevocations {
+		return
+	}
+	for k := range cache {
+		delete(cache, k)
+		return
+	}
+}
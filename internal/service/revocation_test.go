@@ -0,0 +1,183 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type mockRevocationDB struct {
+	db             *sql.DB
+	revoked        map[string]bool
+	expiresAt      map[string]time.Time
+	sessionsRevoke map[int]time.Time
+	tokenLookups   int
+	userLookups    int
+}
+
+func newMockRevocationDB(dbConn *sql.DB) *mockRevocationDB {
+	return &mockRevocationDB{db: dbConn, revoked: map[string]bool{}, expiresAt: map[string]time.Time{}, sessionsRevoke: map[int]time.Time{}}
+}
+
+func (m *mockRevocationDB) BeginTx() (*sql.Tx, error) {
+	return m.db.Begin()
+}
+
+func (m *mockRevocationDB) InsertRevokedToken(jti string, userID int, expiresAt time.Time) error {
+	m.revoked[jti] = true
+	m.expiresAt[jti] = expiresAt
+	return nil
+}
+
+func (m *mockRevocationDB) DeleteExpiredRevokedTokens(before time.Time) (int64, error) {
+	var n int64
+	for jti, at := range m.expiresAt {
+		if at.Before(before) {
+			delete(m.revoked, jti)
+			delete(m.expiresAt, jti)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *mockRevocationDB) IsTokenRevoked(jti string) (bool, error) {
+	m.tokenLookups++
+	return m.revoked[jti], nil
+}
+
+func (m *mockRevocationDB) SetSessionsRevokedAt(tx *sql.Tx, userID int, revokedAt time.Time) error {
+	m.sessionsRevoke[userID] = revokedAt
+	return nil
+}
+
+func (m *mockRevocationDB) GetSessionsRevokedAt(userID int) (sql.NullTime, error) {
+	m.userLookups++
+	at, ok := m.sessionsRevoke[userID]
+	return sql.NullTime{Time: at, Valid: ok}, nil
+}
+
+func newTestRevocationStore(t *testing.T) (*revocationStore, *mockRevocationDB, *mockRefreshTokenDB, sqlmock.Sqlmock) {
+	t.Helper()
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	revDB := newMockRevocationDB(dbConn)
+	tokenDB := newMockRefreshTokenDB(dbConn)
+	store := NewRevocationStore(revDB, tokenDB, &mockLogger{}, time.Minute).(*revocationStore)
+	return store, revDB, tokenDB, mock
+}
+
+func TestRevocationStore_RevokeToken(t *testing.T) {
+	store, revDB, _, _ := newTestRevocationStore(t)
+	issuedAt := time.Now().Add(-time.Minute)
+
+	if revoked, err := store.IsRevoked("a", 1, issuedAt); err != nil || revoked {
+		t.Fatalf("expected token not revoked, got %v, %v", revoked, err)
+	}
+	if err := store.RevokeToken("a", 1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if revoked, err := store.IsRevoked("a", 1, issuedAt); err != nil || !revoked {
+		t.Errorf("expected token revoked immediately, got %v, %v", revoked, err)
+	}
+	if revDB.tokenLookups != 1 {
+		t.Errorf("expected revoked token to be served from cache, lookups=%d", revDB.tokenLookups)
+	}
+}
+
+func TestPruneRevokedTokens(t *testing.T) {
+	store, revDB, _, _ := newTestRevocationStore(t)
+	now := time.Now()
+
+	if err := store.RevokeToken("old", 1, now.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.RevokeToken("new", 1, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !revDB.revoked["old"] {
+		t.Fatalf("expected revoking to leave other revocations alone")
+	}
+
+	pruneRevokedTokens(revDB, &mockLogger{}, now.Add(time.Hour))
+	if revDB.revoked["old"] || !revDB.revoked["new"] {
+		t.Errorf("expected only the expired revocation to be deleted, got %v", revDB.revoked)
+	}
+}
+
+func TestRevocationStore_CacheIsBounded(t *testing.T) {
+	store, revDB, _, _ := newTestRevocationStore(t)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	revDB.revoked["hot"] = true
+
+	for id := 0; id < maxCachedRevocations+100; id++ {
+		_, _ = store.IsRevoked(fmt.Sprintf("t%d", id), id, now)
+	}
+	if len(store.users) != maxCachedRevocations || len(store.tokens) != maxCachedRevocations {
+		t.Errorf("expected the caches to stay at %d, got %d users and %d tokens", maxCachedRevocations, len(store.users), len(store.tokens))
+	}
+
+	// an entry dropped from the cache is looked up again
+	if revoked, err := store.IsRevoked("hot", 1, now); err != nil || !revoked {
+		t.Errorf("expected the token revoked, got %v, %v", revoked, err)
+	}
+	if len(store.tokens) != maxCachedRevocations {
+		t.Errorf("expected the token cache to stay at %d, got %d", maxCachedRevocations, len(store.tokens))
+	}
+}
+
+func TestRevocationStore_CachesRemoteRevocations(t *testing.T) {
+	store, revDB, _, _ := newTestRevocationStore(t)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	issuedAt := now.Add(-time.Minute)
+
+	if revoked, _ := store.IsRevoked("b", 1, issuedAt); revoked {
+		t.Fatalf("expected token not revoked")
+	}
+	// another instance revokes the token
+	revDB.revoked["b"] = true
+	if revoked, _ := store.IsRevoked("b", 1, issuedAt); revoked {
+		t.Errorf("expected cached answer within TTL")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if revoked, _ := store.IsRevoked("b", 1, issuedAt); !revoked {
+		t.Errorf("expected revocation to be picked up after TTL")
+	}
+}
+
+func TestRevocationStore_RevokeUser(t *testing.T) {
+	store, _, tokenDB, mock := newTestRevocationStore(t)
+	tokenDB.tokens["h"] = &db.RefreshToken{ID: 1, UserID: 1, FamilyID: "f"}
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	before := time.Now().Add(-time.Hour)
+	if err := store.RevokeUser(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if revoked, _ := store.IsRevoked("c", 1, before); !revoked {
+		t.Errorf("expected tokens issued before revocation to be revoked")
+	}
+	if revoked, _ := store.IsRevoked("c", 2, before); revoked {
+		t.Errorf("expected other users to be unaffected")
+	}
+	if revoked, _ := store.IsRevoked("c", 1, time.Now().Add(2*time.Second)); revoked {
+		t.Errorf("expected tokens issued after revocation to be valid")
+	}
+	if !tokenDB.tokens["h"].RevokedAt.Valid {
+		t.Errorf("expected refresh tokens of the user to be revoked")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -3,8 +3,17 @@ package service
 import (
 	"avito-shop/internal/db"
 	"avito-shop/pkg"
+	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"go.uber.org/zap"
 )
@@ -13,12 +22,120 @@ var (
 	ErrNotEnoughCoins = errors.New("not enough coins")
 	ErrItemNotFound   = errors.New("item not found")
 	ErrUserNotFound   = errors.New("user not found")
+	ErrInvalidSort    = errors.New("invalid sort order")
+	// ErrOutOfStock and ErrPurchaseLimitReached refuse limited items.
+	ErrOutOfStock           = errors.New("item is out of stock")
+	ErrPurchaseLimitReached = errors.New("purchase limit reached")
+	ErrInvalidQuantity      = errors.New("invalid quantity")
+	ErrOrderNotFound        = errors.New("order not found")
+	ErrInvalidPage          = errors.New("invalid page")
+	ErrInvalidAmount        = errors.New("invalid amount")
+	ErrInvalidNote          = errors.New("invalid note")
+	ErrInvalidFilter        = errors.New("invalid filter")
 )
 
+const (
+	maxPurchaseLines = 50
+	maxLineQuantity  = 1000
+	maxNoteLength    = 200
+
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+	// infoHistoryLimit bounds the coin history of GetUserInfo, which grows
+	// for as long as the user is around.
+	infoHistoryLimit = maxPageLimit
+)
+
+type ItemSort string
+
+const (
+	SortByName      ItemSort = "name"
+	SortByPrice     ItemSort = "price"
+	SortByPriceDesc ItemSort = "-price"
+)
+
+type ItemFilter struct {
+	// MaxPrice hides items costing more; nil shows all of them.
+	MaxPrice *int
+	// Sort defaults to SortByName.
+	Sort ItemSort
+}
+
+type CatalogItem struct {
+	Slug  string
+	Name  string
+	Price int
+	// Stock is nil for unlimited items.
+	Stock     *int
+	Available bool
+	CanAfford bool
+}
+
+type PurchaseLine struct {
+	Item     string
+	Quantity int
+}
+
+type ReceiptLine struct {
+	Item     string
+	Name     string
+	Quantity int
+	Price    int
+	// Discount is taken off Price times Quantity to make up Total.
+	Discount int
+	Total    int
+}
+
+// Receipt lists what was bought, what it cost and the balance left.
+type Receipt struct {
+	OrderID int
+	Lines   []ReceiptLine
+	// PromoCode is set when Discount was taken off the lines by a promo code.
+	PromoCode string
+	Discount  int
+	Total     int
+	Balance   int
+	CreatedAt time.Time
+}
+
+// Order is a past purchase, priced as it was paid.
+type Order struct {
+	ID        int
+	Lines     []ReceiptLine
+	PromoCode string
+	Discount  int
+	Total     int
+	CreatedAt time.Time
+	// Refunds are only loaded by GetOrder.
+	Refunds []Refund
+}
+
+// Page selects Limit entries after skipping Offset ones. A zero Limit means
+// the default one.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// normalize fills in the default limit and checks the bounds.
+func (p Page) normalize() (Page, error) {
+	if p.Limit == 0 {
+		p.Limit = defaultPageLimit
+	}
+	if p.Limit < 1 || p.Limit > maxPageLimit {
+		return Page{}, fmt.Errorf("%w: limit must be 1 to %d", ErrInvalidPage, maxPageLimit)
+	}
+	if p.Offset < 0 {
+		return Page{}, fmt.Errorf("%w: offset must not be negative", ErrInvalidPage)
+	}
+	return p, nil
+}
+
 type Info struct {
 	Coins       int
 	Inventory   []InventoryItem
 	CoinHistory CoinHistory
+	GiftHistory GiftHistory
 }
 
 type InventoryItem struct {
@@ -26,6 +143,8 @@ type InventoryItem struct {
 	Quantity int
 }
 
+// CoinHistory holds the latest infoHistoryLimit transactions of each
+// direction, oldest first. GetCoinHistory pages through all of them.
 type CoinHistory struct {
 	Received []Transaction
 	Sent     []Transaction
@@ -37,80 +156,360 @@ type Transaction struct {
 	Amount   int
 }
 
+// CoinTransaction is an entry of the coin history of a user. Type is
+// db.TransactionSent or db.TransactionReceived.
+type CoinTransaction struct {
+	ID           int
+	Type         string
+	Counterparty string
+	Amount       int
+	Note         string
+	CreatedAt    time.Time
+}
+
+// HistoryFilter selects the transactions shown by GetCoinHistory. Zero fields
+// match all transactions.
+type HistoryFilter struct {
+	// Direction is db.TransactionSent or db.TransactionReceived.
+	Direction    string
+	Counterparty string
+	// MinAmount and MaxAmount are both inclusive.
+	MinAmount *int
+	MaxAmount *int
+	// From is inclusive and To exclusive.
+	From *time.Time
+	To   *time.Time
+}
+
+// HistoryPage is a page of the coin history. NextCursor continues it with the
+// same filter, and is empty on the last page.
+type HistoryPage struct {
+	Transactions []CoinTransaction
+	NextCursor   string
+}
+
 type ShopService interface {
 	BuyItem(userID int, item string) error
 
-	SendCoins(fromUserID int, toUsername string, amount int) error
+	// Buy purchases all lines in one transaction, or none of them. A non-empty
+	// promoCode is redeemed in the same transaction.
+	Buy(userID int, lines []PurchaseLine, promoCode string) (Receipt, error)
+
+	// SendCoins transfers coins to another user. The note is sanitized and
+	// kept in the history of both of them.
+	SendCoins(fromUserID int, toUsername string, amount int, note string) error
+
+	// Gift gives an item to another user in one transaction.
+	Gift(fromUserID int, req GiftRequest) (Gift, error)
 
 	GetCoins(userID int) (int, error)
 
 	GetUserInfo(userID int) (Info, error)
+
+	// GetCoinHistory returns a page of up to limit coins sent and received
+	// by the user, newest first. An empty cursor starts with the newest
+	// transaction; a zero limit means the default one.
+	GetCoinHistory(userID int, filter HistoryFilter, limit int, cursor string) (HistoryPage, error)
+
+	// ListItems returns the catalog as seen by the user.
+	ListItems(userID int, filter ItemFilter) ([]CatalogItem, error)
+
+	// ListOrders returns the orders of the user, newest first.
+	ListOrders(userID int, page Page) ([]Order, error)
+
+	// GetOrder returns ErrOrderNotFound for orders of other users.
+	GetOrder(userID, orderID int) (Order, error)
+
+	// Refund returns lines of an order of the user within the refund window,
+	// crediting the prices paid. Nil lines return all that is left.
+	Refund(userID, orderID int, lines []PurchaseLine) (Refund, error)
+
+	// AdminRefund is Refund for an order of any user, at any time.
+	AdminRefund(adminID, orderID int, lines []PurchaseLine) (Refund, error)
+}
+
+type ShopConfig struct {
+	// RefundWindow is how long after a purchase users may refund it; admins
+	// may refund at any time.
+	RefundWindow time.Duration
 }
 
 type shopService struct {
-	dbProv     db.CoinInventoryDB
-	log        pkg.Logger
-	itemPrices map[string]int
+	dbProv       db.CoinInventoryDB
+	catalog      db.CatalogDB
+	orders       db.OrderDB
+	gifts        db.GiftDB
+	promos       db.PromoDB
+	log          pkg.Logger
+	refundWindow time.Duration
+	now          func() time.Time
 }
 
-func NewShopService(dbProv db.CoinInventoryDB, log pkg.Logger) ShopService {
+func NewShopService(dbProv db.CoinInventoryDB, catalog db.CatalogDB, orders db.OrderDB, gifts db.GiftDB, promos db.PromoDB, log pkg.Logger, cfg ShopConfig) ShopService {
 	return &shopService{
-		dbProv: dbProv,
-		log:    log,
-		itemPrices: map[string]int{
-			"t-shirt":    80,
-			"cup":        20,
-			"book":       50,
-			"pen":        10,
-			"powerbank":  200,
-			"hoody":      300,
-			"umbrella":   200,
-			"socks":      10,
-			"wallet":     50,
-			"pink-hoody": 500,
-		},
+		dbProv:       dbProv,
+		catalog:      catalog,
+		orders:       orders,
+		gifts:        gifts,
+		promos:       promos,
+		log:          log,
+		refundWindow: cfg.RefundWindow,
+		now:          time.Now,
 	}
 }
 
 func (s *shopService) BuyItem(userID int, item string) error {
+	_, err := s.Buy(userID, []PurchaseLine{{Item: item, Quantity: 1}}, "")
+	return err
+}
+
+func (s *shopService) Buy(userID int, lines []PurchaseLine, promoCode string) (Receipt, error) {
+	lines, err := mergeLines(lines)
+	if err != nil {
+		return Receipt{}, err
+	}
+
 	tx, err := s.dbProv.BeginTx()
 	if err != nil {
-		return fmt.Errorf("failed to begin tx: %w", err)
+		return Receipt{}, fmt.Errorf("failed to begin tx: %w", err)
 	}
 	defer func() { _ = tx.Rollback() }()
 
 	coins, err := s.dbProv.GetCoinsForUpdate(tx, userID)
 	if err != nil {
 		s.log.Error("failed to get user coins for update", zap.Int("userID", userID), zap.Error(err))
-		return err
+		return Receipt{}, err
 	}
-	cost, ok := s.itemPrices[item]
-	if !ok {
-		return ErrItemNotFound
+
+	// lines are sorted by item, so concurrent purchases lock items in the same
+	// order; the items stay locked until commit, so they cannot be oversold
+	items := make([]db.Item, 0, len(lines))
+	for _, l := range lines {
+		it, err := s.lockItemFor(tx, userID, l)
+		if err != nil {
+			return Receipt{}, err
+		}
+		items = append(items, it)
 	}
-	if coins < cost {
-		return ErrNotEnoughCoins
+	receipt, err := s.fulfil(tx, userID, userID, coins, lines, items, promoCode)
+	if err != nil {
+		return Receipt{}, err
 	}
 
-	if err := s.dbProv.DecreaseCoins(tx, userID, cost); err != nil {
-		s.log.Error("failed to decrease user coins", zap.Int("userID", userID), zap.Error(err))
-		return err
+	if err := tx.Commit(); err != nil {
+		s.log.Error("failed to commit purchase", zap.Int("userID", userID), zap.Error(err))
+		return Receipt{}, err
 	}
+	s.log.Info("Items purchased successfully",
+		zap.Int("userID", userID),
+		zap.Int("orderID", receipt.OrderID),
+		zap.Int("lines", len(lines)),
+		zap.Int("total", receipt.Total))
+	return receipt, nil
+}
 
-	if err := s.dbProv.IncreaseItem(tx, userID, item, 1); err != nil {
-		s.log.Error("failed to increase item", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
-		return err
+// fulfil charges the user for the lines, less the discount of the promo code
+// if one is given, hands out the items to the recipient and records the order.
+// The items must be locked by lockItemFor for the recipient, in the order of
+// the lines.
+func (s *shopService) fulfil(tx *sql.Tx, userID, recipientID, coins int, lines []PurchaseLine, items []db.Item, promoCode string) (Receipt, error) {
+	order := db.Order{UserID: userID, Lines: make([]db.OrderLine, 0, len(lines))}
+	if recipientID != userID {
+		order.RecipientID = toNullInt(&recipientID)
+	}
+	for i, l := range lines {
+		order.Lines = append(order.Lines, db.OrderLine{
+			ItemID:   items[i].ID,
+			Item:     l.Item,
+			Name:     items[i].Name,
+			Quantity: l.Quantity,
+			Price:    items[i].Price,
+			Total:    items[i].Price * l.Quantity,
+		})
+	}
+	var promo db.PromoCode
+	if promoCode != "" {
+		var err error
+		if promo, err = s.applyPromo(tx, userID, promoCode, order.Lines); err != nil {
+			return Receipt{}, err
+		}
+		order.PromoCode = sql.NullString{String: promo.Code, Valid: true}
+	}
+	for _, l := range order.Lines {
+		order.Discount += l.Discount
+		order.Total += l.Total
+	}
+	if coins < order.Total {
+		return Receipt{}, ErrNotEnoughCoins
 	}
 
-	if err := tx.Commit(); err != nil {
-		s.log.Error("failed to commit buy item", zap.Int("userID", userID), zap.String("item", item), zap.Error(err))
+	for i, l := range lines {
+		if err := s.catalog.DecreaseStock(tx, items[i].ID, l.Quantity); err != nil {
+			s.log.Error("failed to decrease stock", zap.String("item", l.Item), zap.Error(err))
+			return Receipt{}, err
+		}
+		if err := s.dbProv.IncreaseItem(tx, recipientID, l.Item, l.Quantity); err != nil {
+			s.log.Error("failed to increase item", zap.Int("userID", recipientID), zap.String("item", l.Item), zap.Error(err))
+			return Receipt{}, err
+		}
+	}
+
+	order, err := s.orders.InsertOrder(tx, order)
+	if err != nil {
+		s.log.Error("failed to insert order", zap.Int("userID", userID), zap.Error(err))
+		return Receipt{}, err
+	}
+	// orders fully paid by a promo code move no coins
+	if order.Total > 0 {
+		entry := moveCoins(db.EntryPurchase, db.UserAccount(userID), db.RevenueAccount, order.Total)
+		entry.OrderID = toNullInt(&order.ID)
+		if _, err := s.dbProv.PostEntry(tx, entry); err != nil {
+			s.log.Error("failed to post purchase", zap.Int("userID", userID), zap.Int("orderID", order.ID), zap.Error(err))
+			return Receipt{}, domainError(err)
+		}
+	}
+	if promoCode != "" {
+		if err := s.redeemPromo(tx, promo, userID, order); err != nil {
+			return Receipt{}, err
+		}
+	}
+
+	return Receipt{
+		OrderID:   order.ID,
+		Lines:     toReceiptLines(order.Lines),
+		PromoCode: order.PromoCode.String,
+		Discount:  order.Discount,
+		Total:     order.Total,
+		Balance:   coins - order.Total,
+		CreatedAt: order.CreatedAt,
+	}, nil
+}
+
+// domainError maps writes refused by the constraints of the database to the
+// errors of the service. The checks made before writing leave nothing to
+// refuse, so this only catches what slips past them.
+func domainError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrNegativeCoins):
+		return fmt.Errorf("%w: %w", ErrNotEnoughCoins, err)
+	case errors.Is(err, db.ErrNegativeQuantity):
+		return fmt.Errorf("%w: %w", ErrNotEnoughItems, err)
+	case errors.Is(err, db.ErrInvalidAmount):
+		return fmt.Errorf("%w: %w", ErrInvalidAmount, err)
+	}
+	return err
+}
+
+// moveCoins returns an entry moving amount coins from one account to another.
+func moveCoins(kind string, from, to db.Account, amount int) db.JournalEntry {
+	return db.JournalEntry{Kind: kind, Postings: []db.Posting{
+		{Account: from, Amount: -amount},
+		{Account: to, Amount: amount},
+	}}
+}
+
+// mergeLines validates the lines and sums up the ones for the same item. The
+// result is sorted by item.
+func mergeLines(lines []PurchaseLine) ([]PurchaseLine, error) {
+	if len(lines) == 0 || len(lines) > maxPurchaseLines {
+		return nil, fmt.Errorf("%w: expected 1 to %d lines", ErrInvalidQuantity, maxPurchaseLines)
+	}
+	quantities := make(map[string]int, len(lines))
+	for _, l := range lines {
+		if l.Quantity < 1 || l.Quantity > maxLineQuantity {
+			return nil, fmt.Errorf("%w: quantity of %s must be 1 to %d", ErrInvalidQuantity, l.Item, maxLineQuantity)
+		}
+		quantities[l.Item] += l.Quantity
+	}
+	merged := make([]PurchaseLine, 0, len(quantities))
+	for item, q := range quantities {
+		if q > maxLineQuantity {
+			return nil, fmt.Errorf("%w: quantity of %s must be 1 to %d", ErrInvalidQuantity, item, maxLineQuantity)
+		}
+		merged = append(merged, PurchaseLine{Item: item, Quantity: q})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Item < merged[j].Item })
+	return merged, nil
+}
+
+// lockItemFor locks the item of the line and checks that the user may buy that
+// many of it. The item is returned along with ErrOutOfStock and
+// ErrPurchaseLimitReached.
+func (s *shopService) lockItemFor(tx *sql.Tx, userID int, l PurchaseLine) (db.Item, error) {
+	it, err := s.catalog.GetActiveItemForUpdate(tx, l.Item)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Item{}, fmt.Errorf("%w: %s", ErrItemNotFound, l.Item)
+	}
+	if err != nil {
+		s.log.Error("failed to get item", zap.String("item", l.Item), zap.Error(err))
+		return db.Item{}, err
+	}
+	if it.Stock.Valid && it.Stock.Int64 < int64(l.Quantity) {
+		return it, fmt.Errorf("%w: %s", ErrOutOfStock, l.Item)
+	}
+	if it.MaxPerUser.Valid {
+		owned, err := s.dbProv.GetItemQuantity(tx, userID, l.Item)
+		if err != nil {
+			s.log.Error("failed to get owned quantity", zap.Int("userID", userID), zap.String("item", l.Item), zap.Error(err))
+			return db.Item{}, err
+		}
+		if int64(owned+l.Quantity) > it.MaxPerUser.Int64 {
+			return it, fmt.Errorf("%w: %s", ErrPurchaseLimitReached, l.Item)
+		}
+	}
+	return it, nil
+}
+
+// checkOwnLimit returns ErrPurchaseLimitReached if the user would own more of
+// the item than it allows per user once given quantity more, however they get
+// it. The item is locked, so purchases and transfers of it cannot race the
+// check. Items no longer in the catalog have no limit.
+func checkOwnLimit(tx *sql.Tx, catalog db.CatalogDB, inventory db.CoinInventoryDB, userID int, item string, quantity int) error {
+	it, err := catalog.GetItemForUpdate(tx, item)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !it.MaxPerUser.Valid {
+		return nil
+	}
+	owned, err := inventory.GetItemQuantity(tx, userID, item)
+	if err != nil {
 		return err
 	}
-	s.log.Info("Item purchased successfully", zap.Int("userID", userID), zap.String("item", item))
+	if int64(owned+quantity) > it.MaxPerUser.Int64 {
+		return fmt.Errorf("%w: %s", ErrPurchaseLimitReached, item)
+	}
 	return nil
 }
 
-func (s *shopService) SendCoins(fromUserID int, toUsername string, amount int) error {
+// lockCoins locks the users in the order of their IDs and returns their coins,
+// so that two transactions locking the same users cannot deadlock.
+func lockCoins(tx *sql.Tx, coinDB db.CoinInventoryDB, userIDs ...int) (map[int]int, error) {
+	userIDs = slices.Clone(userIDs)
+	slices.Sort(userIDs)
+	coins := make(map[int]int, len(userIDs))
+	for _, id := range userIDs {
+		c, err := coinDB.GetCoinsForUpdate(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		coins[id] = c
+	}
+	return coins, nil
+}
+
+func (s *shopService) SendCoins(fromUserID int, toUsername string, amount int, note string) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	note, err := sanitizeNote(note)
+	if err != nil {
+		return err
+	}
+
 	tx, err := s.dbProv.BeginTx()
 	if err != nil {
 		return fmt.Errorf("failed to begin tx: %w", err)
@@ -126,30 +525,26 @@ func (s *shopService) SendCoins(fromUserID int, toUsername string, amount int) e
 		return ErrNotEnoughCoins
 	}
 
-	if err := s.dbProv.DecreaseCoins(tx, fromUserID, amount); err != nil {
-		s.log.Error("failed to decrease sender coins", zap.Int("fromUserID", fromUserID), zap.Error(err))
-		return err
-	}
-
 	toUserID, err := s.dbProv.GetUserIDByUsernameForUpdate(tx, toUsername)
 	if err != nil {
 		s.log.Warn("recipient not found", zap.String("toUsername", toUsername), zap.Error(err))
 		return ErrUserNotFound
 	}
 
-	if err := s.dbProv.IncreaseCoins(tx, toUserID, amount); err != nil {
-		s.log.Error("failed to increase recipient coins", zap.Int("toUserID", toUserID), zap.Error(err))
-		return err
+	entry := moveCoins(db.EntryTransfer, db.UserAccount(fromUserID), db.UserAccount(toUserID), amount)
+	if _, err := s.dbProv.PostEntry(tx, entry); err != nil {
+		s.log.Error("failed to post transfer", zap.Int("fromUserID", fromUserID), zap.Int("toUserID", toUserID), zap.Error(err))
+		return domainError(err)
 	}
 
-	if err := s.dbProv.InsertTransaction(tx, fromUserID, "sent", toUsername, amount); err != nil {
+	if err := s.dbProv.InsertTransaction(tx, fromUserID, db.TransactionSent, toUsername, amount, note); err != nil {
 		s.log.Error("failed to insert sent transaction", zap.Error(err))
-		return err
+		return domainError(err)
 	}
 
-	if err := s.dbProv.InsertReceivedTransaction(tx, toUserID, fromUserID, amount); err != nil {
+	if err := s.dbProv.InsertReceivedTransaction(tx, toUserID, fromUserID, amount, note); err != nil {
 		s.log.Error("failed to insert received transaction", zap.Error(err))
-		return err
+		return domainError(err)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -163,6 +558,132 @@ func (s *shopService) SendCoins(fromUserID int, toUsername string, amount int) e
 	return nil
 }
 
+// sanitizeNote sanitizes the note and returns ErrInvalidNote if it is still
+// too long.
+func sanitizeNote(note string) (string, error) {
+	note = sanitizeText(note)
+	if utf8.RuneCountInString(note) > maxNoteLength {
+		return "", fmt.Errorf("%w: note must be at most %d characters long", ErrInvalidNote, maxNoteLength)
+	}
+	return note, nil
+}
+
+// sanitizeText drops invalid UTF-8, control and formatting characters, such
+// as bidirectional overrides, and collapses whitespace, so free text users
+// leave for each other shows as the single line it looks like.
+func sanitizeText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), r == utf8.RuneError:
+			return -1
+		}
+		return r
+	}, text)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func (s *shopService) GetCoinHistory(userID int, filter HistoryFilter, limit int, cursor string) (HistoryPage, error) {
+	page, err := Page{Limit: limit}.normalize()
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	after, err := decodeHistoryCursor(cursor)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	f, err := filter.query()
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	// one more than asked tells whether there is a next page
+	trans, err := s.dbProv.GetCoinHistory(userID, f, after, page.Limit+1)
+	if err != nil {
+		s.log.Error("failed to get coin history", zap.Int("userID", userID), zap.Error(err))
+		return HistoryPage{}, err
+	}
+	var history HistoryPage
+	if len(trans) > page.Limit {
+		trans = trans[:page.Limit]
+		last := trans[len(trans)-1]
+		history.NextCursor = encodeHistoryCursor(db.HistoryCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	history.Transactions = make([]CoinTransaction, 0, len(trans))
+	for _, t := range trans {
+		history.Transactions = append(history.Transactions, CoinTransaction{
+			ID:           t.ID,
+			Type:         t.Type,
+			Counterparty: t.Counterparty,
+			Amount:       t.Amount,
+			Note:         t.Note.String,
+			CreatedAt:    t.CreatedAt,
+		})
+	}
+	return history, nil
+}
+
+// query checks the filter and converts it for the database.
+func (f HistoryFilter) query() (db.HistoryFilter, error) {
+	q := db.HistoryFilter{Type: f.Direction, Counterparty: f.Counterparty}
+	switch f.Direction {
+	case "", db.TransactionSent, db.TransactionReceived:
+	default:
+		return db.HistoryFilter{}, fmt.Errorf("%w: direction must be %s or %s", ErrInvalidFilter, db.TransactionSent, db.TransactionReceived)
+	}
+	if f.MinAmount != nil {
+		q.MinAmount = sql.NullInt64{Int64: int64(*f.MinAmount), Valid: true}
+	}
+	if f.MaxAmount != nil {
+		q.MaxAmount = sql.NullInt64{Int64: int64(*f.MaxAmount), Valid: true}
+	}
+	if q.MinAmount.Valid && q.MaxAmount.Valid && q.MinAmount.Int64 > q.MaxAmount.Int64 {
+		return db.HistoryFilter{}, fmt.Errorf("%w: minAmount must not exceed maxAmount", ErrInvalidFilter)
+	}
+	if f.From != nil {
+		q.From = sql.NullTime{Time: f.From.UTC(), Valid: true}
+	}
+	if f.To != nil {
+		q.To = sql.NullTime{Time: f.To.UTC(), Valid: true}
+	}
+	if q.From.Valid && q.To.Valid && !q.From.Time.Before(q.To.Time) {
+		return db.HistoryFilter{}, fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+	}
+	return q, nil
+}
+
+// encodeHistoryCursor makes an opaque cursor of the creation time, in the
+// microseconds the database keeps, and the ID of a transaction.
+func encodeHistoryCursor(c db.HistoryCursor) string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + "." + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(cursor string) (db.HistoryCursor, error) {
+	if cursor == "" {
+		return db.HistoryCursor{}, nil
+	}
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidPage)
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return db.HistoryCursor{}, invalid
+	}
+	micros, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return db.HistoryCursor{}, invalid
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return db.HistoryCursor{}, invalid
+	}
+	c := db.HistoryCursor{CreatedAt: time.UnixMicro(us).UTC()}
+	if c.ID, err = strconv.Atoi(id); err != nil || c.ID < 1 {
+		return db.HistoryCursor{}, invalid
+	}
+	return c, nil
+}
+
 func (s *shopService) GetCoins(userID int) (int, error) {
 	coins, err := s.dbProv.GetUserCoins(userID)
 	if err != nil {
@@ -172,6 +693,17 @@ func (s *shopService) GetCoins(userID int) (int, error) {
 	return coins, nil
 }
 
+// latestTransactions returns the latest infoHistoryLimit transactions of the
+// type, oldest first.
+func (s *shopService) latestTransactions(userID int, transactionType string) ([]db.Transaction, error) {
+	trans, err := s.dbProv.GetCoinHistory(userID, db.HistoryFilter{Type: transactionType}, db.HistoryCursor{}, infoHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(trans)
+	return trans, nil
+}
+
 func (s *shopService) GetUserInfo(userID int) (Info, error) {
 	var info Info
 
@@ -196,7 +728,7 @@ func (s *shopService) GetUserInfo(userID int) (Info, error) {
 	}
 	info.Inventory = inventory
 
-	receivedDB, err := s.dbProv.GetTransactions(userID, "received")
+	receivedDB, err := s.latestTransactions(userID, db.TransactionReceived)
 	if err != nil {
 		s.log.Error("failed to get received transactions", zap.Int("userID", userID), zap.Error(err))
 		return Info{}, err
@@ -209,7 +741,7 @@ func (s *shopService) GetUserInfo(userID int) (Info, error) {
 		})
 	}
 
-	sentDB, err := s.dbProv.GetTransactions(userID, "sent")
+	sentDB, err := s.latestTransactions(userID, db.TransactionSent)
 	if err != nil {
 		s.log.Error("failed to get sent transactions", zap.Int("userID", userID), zap.Error(err))
 		return Info{}, err
@@ -226,5 +758,129 @@ func (s *shopService) GetUserInfo(userID int) (Info, error) {
 		Sent:     sent,
 	}
 
+	gifts, err := s.gifts.GetGifts(userID)
+	if err != nil {
+		s.log.Error("failed to get gifts", zap.Int("userID", userID), zap.Error(err))
+		return Info{}, err
+	}
+	for _, g := range gifts {
+		if g.ToUserID == userID {
+			info.GiftHistory.Received = append(info.GiftHistory.Received, toGift(g))
+		} else {
+			info.GiftHistory.Sent = append(info.GiftHistory.Sent, toGift(g))
+		}
+	}
+
 	return info, nil
 }
+
+func (s *shopService) ListItems(userID int, filter ItemFilter) ([]CatalogItem, error) {
+	var less func(a, b CatalogItem) bool
+	switch filter.Sort {
+	case "", SortByName:
+		less = func(a, b CatalogItem) bool { return a.Name < b.Name }
+	case SortByPrice:
+		less = func(a, b CatalogItem) bool { return a.Price < b.Price }
+	case SortByPriceDesc:
+		less = func(a, b CatalogItem) bool { return a.Price > b.Price }
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSort, filter.Sort)
+	}
+
+	coins, err := s.dbProv.GetUserCoins(userID)
+	if err != nil {
+		s.log.Error("failed to get user coins", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	dbItems, err := s.catalog.ListActiveItems(filter.MaxPrice)
+	if err != nil {
+		s.log.Error("failed to list items", zap.Error(err))
+		return nil, err
+	}
+
+	items := make([]CatalogItem, 0, len(dbItems))
+	for _, it := range dbItems {
+		ci := CatalogItem{
+			Slug:      it.Slug,
+			Name:      it.Name,
+			Price:     it.Price,
+			Available: it.Active,
+			CanAfford: coins >= it.Price,
+		}
+		if it.Stock.Valid {
+			ci.Stock = ptrInt(int(it.Stock.Int64))
+			ci.Available = ci.Available && it.Stock.Int64 > 0
+		}
+		items = append(items, ci)
+	}
+	// items come ordered by slug, which keeps ties in a stable order
+	sort.SliceStable(items, func(i, j int) bool { return less(items[i], items[j]) })
+	return items, nil
+}
+
+func (s *shopService) ListOrders(userID int, page Page) ([]Order, error) {
+	page, err := page.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	dbOrders, err := s.orders.ListOrders(userID, page.Limit, page.Offset)
+	if err != nil {
+		s.log.Error("failed to list orders", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	orders := make([]Order, 0, len(dbOrders))
+	for _, o := range dbOrders {
+		orders = append(orders, toOrder(o))
+	}
+	return orders, nil
+}
+
+func (s *shopService) GetOrder(userID, orderID int) (Order, error) {
+	o, err := s.orders.GetOrder(userID, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Order{}, ErrOrderNotFound
+	}
+	if err != nil {
+		s.log.Error("failed to get order", zap.Int("userID", userID), zap.Int("orderID", orderID), zap.Error(err))
+		return Order{}, err
+	}
+	refunds, err := s.orders.GetRefunds(orderID)
+	if err != nil {
+		s.log.Error("failed to get refunds", zap.Int("orderID", orderID), zap.Error(err))
+		return Order{}, err
+	}
+
+	order := toOrder(o)
+	order.Refunds = make([]Refund, 0, len(refunds))
+	for _, r := range refunds {
+		order.Refunds = append(order.Refunds, toRefund(r))
+	}
+	return order, nil
+}
+
+func toOrder(o db.Order) Order {
+	return Order{
+		ID:        o.ID,
+		Lines:     toReceiptLines(o.Lines),
+		PromoCode: o.PromoCode.String,
+		Discount:  o.Discount,
+		Total:     o.Total,
+		CreatedAt: o.CreatedAt,
+	}
+}
+
+func toReceiptLines(lines []db.OrderLine) []ReceiptLine {
+	out := make([]ReceiptLine, 0, len(lines))
+	for _, l := range lines {
+		out = append(out, ReceiptLine{
+			Item:     l.Item,
+			Name:     l.Name,
+			Quantity: l.Quantity,
+			Price:    l.Price,
+			Discount: l.Discount,
+			Total:    l.Total,
+		})
+	}
+	return out
+}
@@ -4,9 +4,12 @@ import (
 	"avito-shop/internal/db"
 	"database/sql"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -18,9 +21,9 @@ func (m *mockLogger) Error(msg string, fields ...zap.Field) {}
 func (m *mockLogger) Sync() error                           { return nil }
 
 type mockCoinDB struct {
-	GetUserCoinsFunc    func(int) (int, error)
-	GetInventoryFunc    func(int) ([]db.InventoryItem, error)
-	GetTransactionsFunc func(int, string) ([]db.Transaction, error)
+	GetUserCoinsFunc   func(int) (int, error)
+	GetInventoryFunc   func(int) ([]db.InventoryItem, error)
+	GetCoinHistoryFunc func(int, db.HistoryFilter, db.HistoryCursor, int) ([]db.Transaction, error)
 }
 
 func (m *mockCoinDB) BeginTx() (*sql.Tx, error) {
@@ -33,32 +36,42 @@ func (m *mockCoinDB) GetCoinsForUpdate(tx *sql.Tx, userID int) (int, error) {
 	panic("implement me")
 }
 
-func (m *mockCoinDB) IncreaseCoins(tx *sql.Tx, userID, amount int) error {
+func (m *mockCoinDB) PostEntry(tx *sql.Tx, entry db.JournalEntry) (db.JournalEntry, error) {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *mockCoinDB) DecreaseCoins(tx *sql.Tx, userID, amount int) error {
+func (m *mockCoinDB) IncreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *mockCoinDB) IncreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
+func (m *mockCoinDB) InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int, note string) error {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *mockCoinDB) InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int) error {
+func (m *mockCoinDB) InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int, note string) error {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *mockCoinDB) InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int) error {
+func (m *mockCoinDB) GetUserIDByUsernameForUpdate(tx *sql.Tx, username string) (int, error) {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *mockCoinDB) GetUserIDByUsernameForUpdate(tx *sql.Tx, username string) (int, error) {
+func (m *mockCoinDB) GetUserIDByUsername(tx *sql.Tx, username string) (int, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *mockCoinDB) DecreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *mockCoinDB) GetItemQuantity(tx *sql.Tx, userID int, item string) (int, error) {
 	//TODO implement me
 	panic("implement me")
 }
@@ -71,8 +84,8 @@ func (m *mockCoinDB) GetInventory(userID int) ([]db.InventoryItem, error) {
 	return m.GetInventoryFunc(userID)
 }
 
-func (m *mockCoinDB) GetTransactions(userID int, ttype string) ([]db.Transaction, error) {
-	return m.GetTransactionsFunc(userID, ttype)
+func (m *mockCoinDB) GetCoinHistory(userID int, filter db.HistoryFilter, cursor db.HistoryCursor, limit int) ([]db.Transaction, error) {
+	return m.GetCoinHistoryFunc(userID, filter, cursor, limit)
 }
 
 type coinInventorySQLMock struct {
@@ -89,44 +102,20 @@ func (c *coinInventorySQLMock) GetCoinsForUpdate(tx *sql.Tx, userID int) (int, e
 	return coins, err
 }
 
-func (c *coinInventorySQLMock) IncreaseCoins(tx *sql.Tx, userID, amount int) error {
-	_, err := tx.Exec("UPDATE users SET coins = coins + $1 WHERE id=$2", amount, userID)
-	return err
-}
-
-func (c *coinInventorySQLMock) DecreaseCoins(tx *sql.Tx, userID, amount int) error {
-	_, err := tx.Exec("UPDATE users SET coins = coins - $1 WHERE id=$2", amount, userID)
-	return err
+func (c *coinInventorySQLMock) PostEntry(tx *sql.Tx, entry db.JournalEntry) (db.JournalEntry, error) {
+	return db.NewCoinInventoryDB(c.db).PostEntry(tx, entry)
 }
 
 func (c *coinInventorySQLMock) IncreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
-	row := tx.QueryRow("SELECT quantity FROM inventories WHERE user_id=$1 AND item_type=$2 FOR UPDATE", userID, item)
-	var q int
-	err := row.Scan(&q)
-	if err == sql.ErrNoRows {
-		_, e2 := tx.Exec("INSERT INTO inventories (user_id, item_type, quantity) VALUES ($1, $2, $3)",
-			userID, item, delta)
-		return e2
-	} else if err != nil {
-		return err
-	}
-	_, e3 := tx.Exec("UPDATE inventories SET quantity = quantity + $1 WHERE user_id=$2 AND item_type=$3",
-		delta, userID, item)
-	return e3
+	return db.NewCoinInventoryDB(c.db).IncreaseItem(tx, userID, item, delta)
 }
 
-func (c *coinInventorySQLMock) InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int) error {
-	_, err := tx.Exec("INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount) VALUES ($1, $2, $3, $4)",
-		userID, transactionType, counterparty, amount)
-	return err
+func (c *coinInventorySQLMock) InsertTransaction(tx *sql.Tx, userID int, transactionType, counterparty string, amount int, note string) error {
+	return db.NewCoinInventoryDB(c.db).InsertTransaction(tx, userID, transactionType, counterparty, amount, note)
 }
 
-func (c *coinInventorySQLMock) InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int) error {
-	_, err := tx.Exec(`
-    INSERT INTO coin_transactions (user_id, transaction_type, counterparty, amount)
-    VALUES ($1, 'received', (SELECT username FROM users WHERE id=$2), $3)`,
-		toUserID, fromUserID, amount)
-	return err
+func (c *coinInventorySQLMock) InsertReceivedTransaction(tx *sql.Tx, toUserID, fromUserID, amount int, note string) error {
+	return db.NewCoinInventoryDB(c.db).InsertReceivedTransaction(tx, toUserID, fromUserID, amount, note)
 }
 
 func (c *coinInventorySQLMock) GetUserIDByUsernameForUpdate(tx *sql.Tx, username string) (int, error) {
@@ -135,6 +124,32 @@ func (c *coinInventorySQLMock) GetUserIDByUsernameForUpdate(tx *sql.Tx, username
 	return uid, err
 }
 
+func (c *coinInventorySQLMock) GetUserIDByUsername(tx *sql.Tx, username string) (int, error) {
+	return db.NewCoinInventoryDB(c.db).GetUserIDByUsername(tx, username)
+}
+
+func (c *coinInventorySQLMock) DecreaseItem(tx *sql.Tx, userID int, item string, delta int) error {
+	res, err := tx.Exec("UPDATE inventories SET quantity = quantity - $1 WHERE user_id=$2 AND item_type=$3 AND quantity >= $1",
+		delta, userID, item)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = tx.Exec("DELETE FROM inventories WHERE user_id=$1 AND item_type=$2 AND quantity = 0", userID, item)
+	return err
+}
+
+func (c *coinInventorySQLMock) GetItemQuantity(tx *sql.Tx, userID int, item string) (int, error) {
+	var q int
+	err := tx.QueryRow("SELECT quantity FROM inventories WHERE user_id=$1 AND item_type=$2", userID, item).Scan(&q)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return q, err
+}
+
 func (c *coinInventorySQLMock) GetUserCoins(userID int) (int, error) {
 	var coins int
 	err := c.db.QueryRow("SELECT coins FROM users WHERE id=$1", userID).Scan(&coins)
@@ -157,21 +172,72 @@ func (c *coinInventorySQLMock) GetInventory(userID int) ([]db.InventoryItem, err
 	return items, nil
 }
 
-func (c *coinInventorySQLMock) GetTransactions(userID int, ttype string) ([]db.Transaction, error) {
-	rows, err := c.db.Query("SELECT counterparty, amount FROM coin_transactions WHERE user_id=$1 AND transaction_type=$2",
-		userID, ttype)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var trans []db.Transaction
-	for rows.Next() {
-		var t db.Transaction
-		if e2 := rows.Scan(&t.Counterparty, &t.Amount); e2 == nil {
-			trans = append(trans, t)
+func (c *coinInventorySQLMock) GetCoinHistory(userID int, filter db.HistoryFilter, cursor db.HistoryCursor, limit int) ([]db.Transaction, error) {
+	return db.NewCoinInventoryDB(c.db).GetCoinHistory(userID, filter, cursor, limit)
+}
+
+func expectItem(mock sqlmock.Sqlmock, slug string, price int) {
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 AND active FOR UPDATE").
+		WithArgs(slug).
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).
+			AddRow(1, slug, slug, price, nil, nil, true, now, now))
+}
+
+// expectItemLimit expects the item to be locked, whether active or not, with
+// the given limit per user or none.
+func expectItemLimit(mock sqlmock.Sqlmock, slug string, maxPerUser any) {
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 FOR UPDATE").
+		WithArgs(slug).
+		WillReturnRows(sqlmock.NewRows(itemRowColumns).
+			AddRow(1, slug, slug, 20, nil, maxPerUser, true, now, now))
+}
+
+// expectIncreaseItem expects delta of the item to be added to the inventory
+// of the user.
+func expectIncreaseItem(mock sqlmock.Sqlmock, userID int, item string, delta int) {
+	mock.ExpectExec("INSERT INTO inventories (.+) ON CONFLICT \\(user_id, item_type\\) DO UPDATE").
+		WithArgs(userID, item, delta).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectOrder expects the purchase of the lines by the user to be recorded
+// as orderID and paid to the shop.
+func expectOrder(mock sqlmock.Sqlmock, userID, orderID, total int, lines ...db.OrderLine) {
+	mock.ExpectQuery("INSERT INTO orders").
+		WithArgs(userID, sql.NullInt64{}, sql.NullString{}, 0, total, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderID))
+	for _, l := range lines {
+		mock.ExpectExec("INSERT INTO order_lines").
+			WithArgs(orderID, l.ItemID, l.Item, l.Name, l.Quantity, l.Price, l.Discount, l.Total).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	expectEntry(mock, db.EntryPurchase,
+		db.Posting{Account: db.UserAccount(userID), Amount: -total},
+		db.Posting{Account: db.RevenueAccount, Amount: total})
+}
+
+// expectEntry expects a journal entry with the postings to be posted, and the
+// postings to users to be applied to their coins.
+func expectEntry(mock sqlmock.Sqlmock, kind string, postings ...db.Posting) {
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(kind, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	for _, p := range postings {
+		if p.Account.Kind != db.AccountUser {
+			mock.ExpectExec("INSERT INTO journal_postings (.+) WHERE kind=\\$2 AND user_id IS NULL").
+				WithArgs(1, p.Account.Kind, p.Amount).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			continue
 		}
+		mock.ExpectExec("INSERT INTO journal_postings (.+) WHERE user_id=\\$2").
+			WithArgs(1, p.Account.UserID, p.Amount).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE users SET coins = coins \\+ \\$1 WHERE id=\\$2").
+			WithArgs(p.Amount, p.Account.UserID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
 	}
-	return trans, nil
 }
 
 func TestShopService_BuyItem_Success(t *testing.T) {
@@ -185,27 +251,22 @@ func TestShopService_BuyItem_Success(t *testing.T) {
 	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+	expectItem(mock, "cup", 20)
 
-	mock.ExpectExec("UPDATE users SET coins = coins - \\$1 WHERE id=\\$2").
-		WithArgs(20, 1).
-		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE items SET stock = stock - \\$1 WHERE id=\\$2 AND stock IS NOT NULL").
+		WithArgs(1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 
-	mock.ExpectQuery("SELECT quantity FROM inventories WHERE user_id=\\$1 AND item_type=\\$2 FOR UPDATE").
-		WithArgs(1, "cup").
-		WillReturnError(sql.ErrNoRows)
-
-	mock.ExpectExec("INSERT INTO inventories").
-		WithArgs(1, "cup", 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectIncreaseItem(mock, 1, "cup", 1)
+	expectOrder(mock, 1, 5, 20, db.OrderLine{ItemID: 1, Item: "cup", Name: "cup", Quantity: 1, Price: 20, Total: 20})
 
 	mock.ExpectCommit()
 
 	svc := &shopService{
-		dbProv: &coinInventorySQLMock{db: dbConn},
-		log:    &mockLogger{},
-		itemPrices: map[string]int{
-			"cup": 20,
-		},
+		dbProv:  &coinInventorySQLMock{db: dbConn},
+		catalog: db.NewCatalogDB(dbConn),
+		orders:  db.NewOrderDB(dbConn),
+		log:     &mockLogger{},
 	}
 
 	if err := svc.BuyItem(1, "cup"); err != nil {
@@ -228,14 +289,14 @@ func TestShopService_BuyItem_NotEnoughCoins(t *testing.T) {
 	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(10))
+	expectItem(mock, "cup", 20)
 	mock.ExpectRollback()
 
 	svc := &shopService{
-		dbProv: &coinInventorySQLMock{db: dbConn},
-		log:    &mockLogger{},
-		itemPrices: map[string]int{
-			"cup": 20,
-		},
+		dbProv:  &coinInventorySQLMock{db: dbConn},
+		catalog: db.NewCatalogDB(dbConn),
+		orders:  db.NewOrderDB(dbConn),
+		log:     &mockLogger{},
 	}
 
 	err = svc.BuyItem(1, "cup")
@@ -259,13 +320,17 @@ func TestShopService_BuyItem_ItemNotFound(t *testing.T) {
 	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+	mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 AND active FOR UPDATE").
+		WithArgs("cup").
+		WillReturnError(sql.ErrNoRows)
 
 	mock.ExpectRollback()
 
 	svc := &shopService{
-		dbProv:     &coinInventorySQLMock{db: dbConn},
-		log:        &mockLogger{},
-		itemPrices: map[string]int{},
+		dbProv:  &coinInventorySQLMock{db: dbConn},
+		catalog: db.NewCatalogDB(dbConn),
+		orders:  db.NewOrderDB(dbConn),
+		log:     &mockLogger{},
 	}
 
 	err = svc.BuyItem(1, "cup")
@@ -278,6 +343,202 @@ func TestShopService_BuyItem_ItemNotFound(t *testing.T) {
 	}
 }
 
+func TestShopService_BuyItem_Limits(t *testing.T) {
+	cases := map[string]struct {
+		stock, maxPerUser any
+		owned             int
+		want              error
+	}{
+		"out of stock":  {stock: 0, maxPerUser: nil, want: ErrOutOfStock},
+		"limit reached": {stock: 5, maxPerUser: 1, owned: 1, want: ErrPurchaseLimitReached},
+		"within limits": {stock: 1, maxPerUser: 2, owned: 1},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dbConn, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer dbConn.Close()
+
+			now := time.Now()
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
+			mock.ExpectQuery("SELECT (.+) FROM items WHERE slug=\\$1 AND active FOR UPDATE").
+				WithArgs("pink-hoody").
+				WillReturnRows(sqlmock.NewRows(itemRowColumns).
+					AddRow(7, "pink-hoody", "Pink hoody", 500, tc.stock, tc.maxPerUser, true, now, now))
+			if tc.maxPerUser != nil && tc.stock != 0 {
+				mock.ExpectQuery("SELECT quantity FROM inventories WHERE user_id=\\$1 AND item_type=\\$2").
+					WithArgs(1, "pink-hoody").
+					WillReturnRows(sqlmock.NewRows([]string{"quantity"}).AddRow(tc.owned))
+			}
+			if tc.want == nil {
+				mock.ExpectExec("UPDATE items SET stock = stock - \\$1 WHERE id=\\$2").
+					WithArgs(1, 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectIncreaseItem(mock, 1, "pink-hoody", 1)
+				expectOrder(mock, 1, 3, 500, db.OrderLine{ItemID: 7, Item: "pink-hoody", Name: "Pink hoody", Quantity: 1, Price: 500, Total: 500})
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			svc := &shopService{
+				dbProv:  &coinInventorySQLMock{db: dbConn},
+				catalog: db.NewCatalogDB(dbConn),
+				orders:  db.NewOrderDB(dbConn),
+				log:     &mockLogger{},
+			}
+			if err := svc.BuyItem(1, "pink-hoody"); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestShopService_Buy(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer dbConn.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+	// items are locked in slug order whatever the order of the lines
+	expectItem(mock, "cup", 20)
+	expectItem(mock, "socks", 10)
+	for _, l := range []struct {
+		item     string
+		quantity int
+	}{{"cup", 2}, {"socks", 3}} {
+		mock.ExpectExec("UPDATE items SET stock = stock - \\$1").
+			WithArgs(l.quantity, 1).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectIncreaseItem(mock, 1, l.item, l.quantity)
+	}
+	expectOrder(mock, 1, 9, 70,
+		db.OrderLine{ItemID: 1, Item: "cup", Name: "cup", Quantity: 2, Price: 20, Total: 40},
+		db.OrderLine{ItemID: 1, Item: "socks", Name: "socks", Quantity: 3, Price: 10, Total: 30})
+	mock.ExpectCommit()
+
+	svc := &shopService{
+		dbProv:  &coinInventorySQLMock{db: dbConn},
+		catalog: db.NewCatalogDB(dbConn),
+		orders:  db.NewOrderDB(dbConn),
+		log:     &mockLogger{},
+	}
+	receipt, err := svc.Buy(1, []PurchaseLine{{"socks", 1}, {"cup", 2}, {"socks", 2}}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Receipt{
+		OrderID: 9,
+		Lines: []ReceiptLine{
+			{Item: "cup", Name: "cup", Quantity: 2, Price: 20, Total: 40},
+			{Item: "socks", Name: "socks", Quantity: 3, Price: 10, Total: 30},
+		},
+		Total:     70,
+		Balance:   30,
+		CreatedAt: receipt.CreatedAt,
+	}
+	if fmt.Sprint(receipt) != fmt.Sprint(want) {
+		t.Errorf("expected receipt %+v, got %+v", want, receipt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_Buy_InvalidLines(t *testing.T) {
+	svc := &shopService{log: &mockLogger{}}
+
+	tooMany := make([]PurchaseLine, maxPurchaseLines+1)
+	for i := range tooMany {
+		tooMany[i] = PurchaseLine{Item: fmt.Sprintf("item-%d", i), Quantity: 1}
+	}
+	cases := map[string][]PurchaseLine{
+		"empty":          nil,
+		"zero":           {{"cup", 0}},
+		"negative":       {{"cup", 2}, {"socks", -1}},
+		"too many":       {{"cup", maxLineQuantity + 1}},
+		"too many sums":  {{"cup", maxLineQuantity}, {"cup", 1}},
+		"too many lines": tooMany,
+	}
+	for name, lines := range cases {
+		if _, err := svc.Buy(1, lines, ""); !errors.Is(err, ErrInvalidQuantity) {
+			t.Errorf("%s: expected ErrInvalidQuantity, got %v", name, err)
+		}
+	}
+}
+
+var orderRowColumns = []string{"id", "user_id", "recipient_id", "promo_code", "discount", "total", "created_at", "item_id", "item", "name", "quantity", "price", "discount", "total", "refunded"}
+
+func TestShopService_ListOrders(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer dbConn.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM \\((.+) LIMIT \\$2 OFFSET \\$3 \\) o JOIN order_lines").
+		WithArgs(1, defaultPageLimit, 0).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).
+			AddRow(9, 1, nil, nil, 0, 70, now, 1, "cup", "Cup", 2, 20, 0, 40, 0).
+			AddRow(9, 1, nil, nil, 0, 70, now, 2, "socks", "Socks", 3, 10, 0, 30, 1).
+			AddRow(4, 1, nil, nil, 0, 20, now, 1, "cup", "Cup", 1, 20, 0, 20, 0))
+
+	svc := &shopService{orders: db.NewOrderDB(dbConn), log: &mockLogger{}}
+	orders, err := svc.ListOrders(1, Page{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(orders) != 2 || orders[0].ID != 9 || len(orders[0].Lines) != 2 || orders[1].ID != 4 || len(orders[1].Lines) != 1 {
+		t.Fatalf("unexpected orders: %+v", orders)
+	}
+	if l := orders[0].Lines[1]; l.Item != "socks" || l.Name != "Socks" || l.Price != 10 || l.Total != 30 {
+		t.Errorf("unexpected line: %+v", l)
+	}
+
+	for _, page := range []Page{{Limit: -1}, {Limit: maxPageLimit + 1}, {Offset: -1}} {
+		if _, err := svc.ListOrders(1, page); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("%+v: expected ErrInvalidPage, got %v", page, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_GetOrder_OfAnotherUser(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer dbConn.Close()
+
+	mock.ExpectQuery("SELECT (.+) FROM orders o JOIN order_lines ol ON ol.order_id = o.id WHERE o.id=\\$1 AND o.user_id=\\$2").
+		WithArgs(9, 2).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns))
+
+	svc := &shopService{orders: db.NewOrderDB(dbConn), log: &mockLogger{}}
+	if _, err := svc.GetOrder(2, 9); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected ErrOrderNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
 func TestShopService_SendCoins_Success(t *testing.T) {
 	dbConn, mock, err := sqlmock.New()
 	if err != nil {
@@ -289,32 +550,27 @@ func TestShopService_SendCoins_Success(t *testing.T) {
 	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
-	mock.ExpectExec("UPDATE users SET coins = coins - \\$1 WHERE id=\\$2").
-		WithArgs(30, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectQuery("SELECT id FROM users WHERE username=\\$1 FOR UPDATE").
 		WithArgs("otheruser").
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
-	mock.ExpectExec("UPDATE users SET coins = coins \\+ \\$1 WHERE id=\\$2").
-		WithArgs(30, 2).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectEntry(mock, db.EntryTransfer,
+		db.Posting{Account: db.UserAccount(1), Amount: -30},
+		db.Posting{Account: db.UserAccount(2), Amount: 30})
+	// both sides keep the note, sanitized
 	mock.ExpectExec("INSERT INTO coin_transactions").
-		WithArgs(1, "sent", "otheruser", 30).
+		WithArgs(1, "sent", "otheruser", 30, "thanks for the coffee").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("INSERT INTO coin_transactions").
-		WithArgs(2, 1, 30).
+		WithArgs(2, 1, 30, "thanks for the coffee").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
 	svc := &shopService{
 		dbProv: &coinInventorySQLMock{db: dbConn},
 		log:    &mockLogger{},
-		itemPrices: map[string]int{
-			"cup": 20,
-		},
 	}
 
-	err = svc.SendCoins(1, "otheruser", 30)
+	err = svc.SendCoins(1, "otheruser", 30, " thanks\n\tfor the\u202e coffee\x00 ")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -340,7 +596,7 @@ func TestShopService_SendCoins_NotEnoughCoins(t *testing.T) {
 		log:    &mockLogger{},
 	}
 
-	err = svc.SendCoins(1, "otheruser", 30)
+	err = svc.SendCoins(1, "otheruser", 30, "")
 	if !errors.Is(err, ErrNotEnoughCoins) {
 		t.Errorf("expected ErrNotEnoughCoins, got %v", err)
 	}
@@ -350,6 +606,127 @@ func TestShopService_SendCoins_NotEnoughCoins(t *testing.T) {
 	}
 }
 
+func TestShopService_SendCoins_RefusedByDatabase(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New error: %v", err)
+	}
+	defer dbConn.Close()
+
+	// the coins changed by other means since they were checked
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT coins FROM users WHERE id=\\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+	mock.ExpectQuery("SELECT id FROM users WHERE username=\\$1 FOR UPDATE").
+		WithArgs("otheruser").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO journal_postings").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET coins = coins \\+ \\$1 WHERE id=\\$2").
+		WithArgs(-30, 1).
+		WillReturnError(&pq.Error{Code: "23514", Constraint: "users_coins_nonnegative"})
+	mock.ExpectRollback()
+
+	svc := &shopService{
+		dbProv: &coinInventorySQLMock{db: dbConn},
+		log:    &mockLogger{},
+	}
+
+	err = svc.SendCoins(1, "otheruser", 30, "")
+	if !errors.Is(err, ErrNotEnoughCoins) || !errors.Is(err, db.ErrNegativeCoins) {
+		t.Errorf("expected ErrNotEnoughCoins, got %v", err)
+	}
+	if e2 := mock.ExpectationsWereMet(); e2 != nil {
+		t.Errorf("unmet expectations: %v", e2)
+	}
+}
+
+func TestShopService_SendCoins_InvalidAmount(t *testing.T) {
+	svc := &shopService{log: &mockLogger{}}
+	for _, amount := range []int{0, -30} {
+		if err := svc.SendCoins(1, "otheruser", amount, ""); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("amount %d: expected ErrInvalidAmount, got %v", amount, err)
+		}
+	}
+}
+
+var transactionRowColumns = []string{"id", "transaction_type", "counterparty", "amount", "note", "created_at"}
+
+func TestShopService_GetCoinHistory_Pages(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer dbConn.Close()
+
+	created := time.Date(2025, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	// the time zone of the filter is of no concern to the database
+	from := time.Date(2025, 3, 1, 3, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	mock.ExpectQuery("SELECT (.+) FROM coin_transactions WHERE user_id=\\$1 (.+) ORDER BY created_at DESC, id DESC LIMIT \\$10").
+		WithArgs(1, "sent", "bob", sql.NullInt64{}, sql.NullInt64{}, sql.NullTime{Time: from.UTC(), Valid: true}, sql.NullTime{},
+			0, time.Time{}, 3).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(9, "sent", "bob", 10, "thanks", created).
+			AddRow(7, "sent", "bob", 20, nil, created).
+			AddRow(3, "sent", "bob", 30, nil, created.Add(-time.Hour)))
+	// the second page continues after the transaction 7, which has the same
+	// creation time as 9
+	mock.ExpectQuery("SELECT (.+) FROM coin_transactions").
+		WithArgs(1, "sent", "bob", sql.NullInt64{}, sql.NullInt64{}, sql.NullTime{Time: from.UTC(), Valid: true}, sql.NullTime{},
+			7, created, 3).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(3, "sent", "bob", 30, nil, created.Add(-time.Hour)))
+
+	svc := &shopService{dbProv: &coinInventorySQLMock{db: dbConn}, log: &mockLogger{}}
+	filter := HistoryFilter{Direction: db.TransactionSent, Counterparty: "bob", From: &from}
+	page, err := svc.GetCoinHistory(1, filter, 2, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Transactions) != 2 || page.Transactions[0].Note != "thanks" || page.Transactions[1].ID != 7 || page.NextCursor == "" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	page, err = svc.GetCoinHistory(1, filter, 2, page.NextCursor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != 3 || page.NextCursor != "" {
+		t.Errorf("unexpected last page: %+v", page)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestShopService_GetCoinHistory_Invalid(t *testing.T) {
+	svc := &shopService{log: &mockLogger{}}
+	now := time.Now()
+	cases := []struct {
+		name   string
+		filter HistoryFilter
+		limit  int
+		cursor string
+		want   error
+	}{
+		{"direction", HistoryFilter{Direction: "stolen"}, 0, "", ErrInvalidFilter},
+		{"amounts", HistoryFilter{MinAmount: ptrInt(50), MaxAmount: ptrInt(10)}, 0, "", ErrInvalidFilter},
+		{"dates", HistoryFilter{From: &now, To: &now}, 0, "", ErrInvalidFilter},
+		{"limit", HistoryFilter{}, 101, "", ErrInvalidPage},
+		{"cursor", HistoryFilter{}, 0, "not a cursor", ErrInvalidPage},
+		{"cursor without an id", HistoryFilter{}, 0, encodeHistoryCursor(db.HistoryCursor{CreatedAt: now}), ErrInvalidPage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := svc.GetCoinHistory(1, tc.filter, tc.limit, tc.cursor); !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
 func TestShopService_GetUserInfo_Success(t *testing.T) {
 	mockDB := &mockCoinDB{
 		GetUserCoinsFunc: func(userID int) (int, error) {
@@ -360,9 +737,13 @@ func TestShopService_GetUserInfo_Success(t *testing.T) {
 				{Type: "cup", Quantity: 2},
 			}, nil
 		},
-		GetTransactionsFunc: func(userID int, ttype string) ([]db.Transaction, error) {
-			if ttype == "received" {
+		GetCoinHistoryFunc: func(userID int, filter db.HistoryFilter, cursor db.HistoryCursor, limit int) ([]db.Transaction, error) {
+			if limit != infoHistoryLimit || cursor.ID != 0 {
+				t.Errorf("expected the first %d transactions, got limit %d after %+v", infoHistoryLimit, limit, cursor)
+			}
+			if filter.Type == db.TransactionReceived {
 				return []db.Transaction{
+					{Counterparty: "Carol", Amount: 30},
 					{Counterparty: "Alice", Amount: 50},
 				}, nil
 			}
@@ -373,8 +754,14 @@ func TestShopService_GetUserInfo_Success(t *testing.T) {
 	}
 	logger := &mockLogger{}
 
+	gifts := &mockGiftDB{gifts: []db.Gift{
+		{FromUserID: 3, FromUser: "Alice", ToUserID: 10, ToUser: "me", Item: "cup", Quantity: 1, Message: sql.NullString{String: "Enjoy!", Valid: true}},
+		{FromUserID: 10, FromUser: "me", ToUserID: 4, ToUser: "Bob", Item: "pen", Quantity: 2},
+	}}
+
 	svc := &shopService{
 		dbProv: mockDB,
+		gifts:  gifts,
 		log:    logger,
 	}
 
@@ -389,10 +776,10 @@ func TestShopService_GetUserInfo_Success(t *testing.T) {
 	if len(info.Inventory) != 1 || info.Inventory[0].Type != "cup" || info.Inventory[0].Quantity != 2 {
 		t.Errorf("unexpected inventory: %v", info.Inventory)
 	}
-	if len(info.CoinHistory.Received) != 1 {
-		t.Errorf("expected 1 received, got %d", len(info.CoinHistory.Received))
+	if len(info.CoinHistory.Received) != 2 {
+		t.Errorf("expected 2 received, got %d", len(info.CoinHistory.Received))
 	} else {
-		if info.CoinHistory.Received[0].FromUser != "Alice" || info.CoinHistory.Received[0].Amount != 50 {
+		if info.CoinHistory.Received[0].FromUser != "Alice" || info.CoinHistory.Received[0].Amount != 50 || info.CoinHistory.Received[1].FromUser != "Carol" {
 			t.Errorf("received mismatch: %v", info.CoinHistory.Received)
 		}
 	}
@@ -403,4 +790,101 @@ func TestShopService_GetUserInfo_Success(t *testing.T) {
 			t.Errorf("sent mismatch: %v", info.CoinHistory.Sent)
 		}
 	}
+	if len(info.GiftHistory.Received) != 1 || info.GiftHistory.Received[0].FromUser != "Alice" || info.GiftHistory.Received[0].Message != "Enjoy!" {
+		t.Errorf("unexpected received gifts: %+v", info.GiftHistory.Received)
+	}
+	if len(info.GiftHistory.Sent) != 1 || info.GiftHistory.Sent[0].ToUser != "Bob" {
+		t.Errorf("unexpected sent gifts: %+v", info.GiftHistory.Sent)
+	}
+}
+
+// mockGiftDB returns the given gifts; inserting panics.
+type mockGiftDB struct {
+	db.GiftDB
+	gifts []db.Gift
+}
+
+func (m *mockGiftDB) GetGifts(userID int) ([]db.Gift, error) {
+	return m.gifts, nil
+}
+
+// mockCatalogDB serves the read-only part of the catalog; the rest panics.
+type mockCatalogDB struct {
+	db.CatalogDB
+	items []db.Item
+}
+
+func (m *mockCatalogDB) GetActiveItem(tx *sql.Tx, slug string) (db.Item, error) {
+	for _, it := range m.items {
+		if it.Slug == slug && it.Active {
+			return it, nil
+		}
+	}
+	return db.Item{}, sql.ErrNoRows
+}
+
+func (m *mockCatalogDB) ListActiveItems(maxPrice *int) ([]db.Item, error) {
+	var items []db.Item
+	for _, it := range m.items {
+		if it.Active && (maxPrice == nil || it.Price <= *maxPrice) {
+			items = append(items, it)
+		}
+	}
+	return items, nil
+}
+
+func TestShopService_ListItems(t *testing.T) {
+	svc := &shopService{
+		dbProv: &mockCoinDB{GetUserCoinsFunc: func(int) (int, error) { return 50, nil }},
+		catalog: &mockCatalogDB{items: []db.Item{
+			{Slug: "book", Name: "Book", Price: 50, Active: true},
+			{Slug: "cup", Name: "Cup", Price: 20, Active: true},
+			{Slug: "hoody", Name: "Hoody", Price: 300, Stock: sql.NullInt64{Valid: true}, Active: true},
+			{Slug: "pen", Name: "Pen", Price: 10, Active: false},
+		}},
+		log: &mockLogger{},
+	}
+
+	slugs := func(items []CatalogItem) []string {
+		var out []string
+		for _, it := range items {
+			out = append(out, it.Slug)
+		}
+		return out
+	}
+	maxPrice := 50
+	cases := map[string]struct {
+		filter ItemFilter
+		want   []string
+	}{
+		"default":    {ItemFilter{}, []string{"book", "cup", "hoody"}},
+		"price":      {ItemFilter{Sort: SortByPrice}, []string{"cup", "book", "hoody"}},
+		"price desc": {ItemFilter{Sort: SortByPriceDesc}, []string{"hoody", "book", "cup"}},
+		"max price":  {ItemFilter{MaxPrice: &maxPrice, Sort: SortByPrice}, []string{"cup", "book"}},
+	}
+	for name, tc := range cases {
+		items, err := svc.ListItems(1, tc.filter)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if got := slugs(items); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, got)
+		}
+	}
+
+	items, err := svc.ListItems(1, ItemFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, it := range items {
+		// hoody is sold out
+		available := it.Slug != "hoody"
+		if want := it.Price <= 50; it.CanAfford != want || it.Available != available {
+			t.Errorf("%s: expected canAfford=%v and available=%v, got %+v", it.Slug, want, available, it)
+		}
+	}
+
+	if _, err := svc.ListItems(1, ItemFilter{Sort: "random"}); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("expected ErrInvalidSort, got %v", err)
+	}
 }
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"avito-shop/pkg"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// ThrottledError is returned when a login is refused before the password is
+// checked. It matches ErrTooManyAttempts.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e *ThrottledError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+type ThrottleConfig struct {
+	// MaxUserFailures and MaxIPFailures lock the username or the IP out for
+	// Lockout once reached.
+	MaxUserFailures int
+	MaxIPFailures   int
+	// BackoffBase is the wait after the first failure; it doubles with every
+	// further failure until the lockout.
+	BackoffBase time.Duration
+	// Lockout is also how long failures are remembered.
+	Lockout time.Duration
+}
+
+type LoginThrottle interface {
+	// Allow returns a ThrottledError if the username or the IP has to wait.
+	Allow(username, ip string) error
+	Failure(username, ip string)
+	// Success forgets the failures of the username. Failures of the IP are
+	// kept, so one valid account does not help guessing others.
+	Success(username string)
+	Unlock(username string) error
+}
+
+type loginThrottle struct {
+	attempts db.LoginAttemptDB
+	log      pkg.Logger
+	cfg      ThrottleConfig
+	now      func() time.Time
+}
+
+func NewLoginThrottle(attempts db.LoginAttemptDB, logger pkg.Logger, cfg ThrottleConfig) LoginThrottle {
+	return &loginThrottle{
+		attempts: attempts,
+		log:      logger,
+		cfg:      cfg,
+		now:      time.Now,
+	}
+}
+
+type throttleKey struct {
+	key         string
+	maxFailures int
+}
+
+func userKey(username string) string { return "user:" + username }
+
+func (t *loginThrottle) keys(username, ip string) []throttleKey {
+	return []throttleKey{
+		{userKey(username), t.cfg.MaxUserFailures},
+		{"ip:" + ip, t.cfg.MaxIPFailures},
+	}
+}
+
+func (t *loginThrottle) Allow(username, ip string) error {
+	now := t.now()
+	var wait time.Duration
+	for _, k := range t.keys(username, ip) {
+		a, err := t.attempts.GetLoginAttempt(k.key)
+		if err != nil {
+			t.log.Error("failed to get login attempts", zap.String("key", k.key), zap.Error(err))
+			return err
+		}
+		if a.Failures == 0 {
+			continue
+		}
+		if w := a.LastFailure.Add(t.backoff(a.Failures, k.maxFailures)).Sub(now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		t.log.Warn("login throttled", zap.String("username", username), zap.String("ip", ip), zap.Duration("retryAfter", wait))
+		return &ThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// backoff is how long to wait after the last of failures.
+func (t *loginThrottle) backoff(failures, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return t.cfg.Lockout
+	}
+	wait := t.cfg.BackoffBase
+	for i := 1; i < failures && wait < t.cfg.Lockout; i++ {
+		wait *= 2
+	}
+	return min(wait, t.cfg.Lockout)
+}
+
+func (t *loginThrottle) Failure(username, ip string) {
+	now := t.now()
+	expireBefore := now.Add(-t.cfg.Lockout)
+	for _, k := range t.keys(username, ip) {
+		a, err := t.attempts.RecordLoginFailure(k.key, now, expireBefore)
+		if err != nil {
+			t.log.Error("failed to record login failure", zap.String("key", k.key), zap.Error(err))
+			continue
+		}
+		if a.Failures == k.maxFailures {
+			t.log.Warn("login locked out", zap.String("key", k.key), zap.Duration("lockout", t.cfg.Lockout))
+		}
+	}
+}
+
+func (t *loginThrottle) Success(username string) {
+	if err := t.attempts.ResetLoginAttempts(userKey(username)); err != nil {
+		t.log.Error("failed to reset login attempts", zap.String("username", username), zap.Error(err))
+	}
+}
+
+func (t *loginThrottle) Unlock(username string) error {
+	if err := t.attempts.ResetLoginAttempts(userKey(username)); err != nil {
+		t.log.Error("failed to unlock user", zap.String("username", username), zap.Error(err))
+		return err
+	}
+	t.log.Info("User login unlocked", zap.String("username", username))
+	return nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"avito-shop/internal/db"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestThrottle(t *testing.T) (*loginThrottle, *time.Time) {
+	t.Helper()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := NewLoginThrottle(db.NewMemoryLoginAttemptDB(), &mockLogger{}, testThrottleConfig).(*loginThrottle)
+	throttle.now = func() time.Time { return now }
+	return throttle, &now
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("expected ThrottledError, got %v", err)
+	}
+	return throttled.RetryAfter
+}
+
+func TestLoginThrottle_BacksOffExponentially(t *testing.T) {
+	throttle, now := newTestThrottle(t)
+
+	if err := throttle.Allow("alice", "ip1"); err != nil {
+		t.Fatalf("expected first attempt to be allowed, got %v", err)
+	}
+	throttle.Failure("alice", "ip1")
+	if wait := retryAfter(t, throttle.Allow("alice", "ip1")); wait != time.Second {
+		t.Errorf("expected 1s backoff, got %s", wait)
+	}
+
+	*now = now.Add(time.Second)
+	if err := throttle.Allow("alice", "ip1"); err != nil {
+		t.Fatalf("expected attempt after backoff to be allowed, got %v", err)
+	}
+	throttle.Failure("alice", "ip1")
+	if wait := retryAfter(t, throttle.Allow("alice", "ip1")); wait != 2*time.Second {
+		t.Errorf("expected 2s backoff, got %s", wait)
+	}
+	// the username is throttled from any IP
+	if wait := retryAfter(t, throttle.Allow("alice", "ip2")); wait != 2*time.Second {
+		t.Errorf("expected 2s backoff from another IP, got %s", wait)
+	}
+	if err := throttle.Allow("bob", "ip2"); err != nil {
+		t.Errorf("expected other users to be allowed, got %v", err)
+	}
+}
+
+func TestLoginThrottle_LocksOutAndUnlocks(t *testing.T) {
+	throttle, now := newTestThrottle(t)
+
+	for i := 0; i < testThrottleConfig.MaxUserFailures; i++ {
+		throttle.Failure("alice", "ip1")
+	}
+	if wait := retryAfter(t, throttle.Allow("alice", "ip2")); wait != testThrottleConfig.Lockout {
+		t.Errorf("expected lockout of %s, got %s", testThrottleConfig.Lockout, wait)
+	}
+
+	*now = now.Add(testThrottleConfig.Lockout)
+	if err := throttle.Allow("alice", "ip2"); err != nil {
+		t.Fatalf("expected lockout to expire, got %v", err)
+	}
+	// failures older than the lockout are forgotten
+	throttle.Failure("alice", "ip2")
+	if wait := retryAfter(t, throttle.Allow("alice", "ip2")); wait != time.Second {
+		t.Errorf("expected counting to restart, got %s", wait)
+	}
+
+	if err := throttle.Unlock("alice"); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if err := throttle.Allow("alice", "ip3"); err != nil {
+		t.Errorf("expected unlocked user to be allowed, got %v", err)
+	}
+}
+
+func TestLoginThrottle_PerIP(t *testing.T) {
+	throttle, _ := newTestThrottle(t)
+
+	for i := 0; i < testThrottleConfig.MaxIPFailures; i++ {
+		throttle.Failure("user"+string(rune('a'+i)), "ip1")
+	}
+	if wait := retryAfter(t, throttle.Allow("fresh", "ip1")); wait != testThrottleConfig.Lockout {
+		t.Errorf("expected IP lockout of %s, got %s", testThrottleConfig.Lockout, wait)
+	}
+	// a successful login does not clear the failures of the IP
+	throttle.Success("fresh")
+	retryAfter(t, throttle.Allow("fresh", "ip1"))
+	if err := throttle.Allow("fresh", "ip2"); err != nil {
+		t.Errorf("expected other IPs to be allowed, got %v", err)
+	}
+}
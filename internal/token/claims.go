@@ -0,0 +1,89 @@
+// Package token defines the claims carried by access tokens and how they are
+// passed from the authentication middleware to the handlers.
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+const contextKey = "claims"
+
+// Roles a user can have. Every user starts as an employee.
+const (
+	RoleEmployee = "employee"
+	RoleAdmin    = "admin"
+	RoleAuditor  = "auditor"
+)
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleEmployee, RoleAdmin, RoleAuditor:
+		return true
+	}
+	return false
+}
+
+var ErrInvalidClaims = errors.New("invalid token claims")
+
+type Claims struct {
+	UserID   int      `json:"user_id"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Expectations are what a token must satisfy besides a valid signature.
+type Expectations struct {
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew between the issuer and this service.
+	Leeway time.Duration
+}
+
+// Validate checks the registered claims against exp at now. Tokens must carry
+// an ID, an issue time and an expiry, so that they can be revoked.
+func (c *Claims) Validate(exp Expectations, now time.Time) error {
+	if c.UserID <= 0 || c.ID == "" || c.IssuedAt == nil || c.ExpiresAt == nil {
+		return fmt.Errorf("%w: missing required claims", ErrInvalidClaims)
+	}
+	if now.After(c.ExpiresAt.Add(exp.Leeway)) {
+		return fmt.Errorf("%w: token is expired", ErrInvalidClaims)
+	}
+	if c.IssuedAt.After(now.Add(exp.Leeway)) {
+		return fmt.Errorf("%w: token used before issued", ErrInvalidClaims)
+	}
+	if c.NotBefore != nil && c.NotBefore.After(now.Add(exp.Leeway)) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidClaims)
+	}
+	if c.Issuer != exp.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidClaims, c.Issuer)
+	}
+	if !c.VerifyAudience(exp.Audience, true) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidClaims)
+	}
+	return nil
+}
+
+func ToContext(c echo.Context, claims *Claims) {
+	c.Set(contextKey, claims)
+}
+
+// FromContext returns the claims of the authenticated request.
+func FromContext(c echo.Context) (*Claims, bool) {
+	claims, ok := c.Get(contextKey).(*Claims)
+	return claims, ok && claims != nil
+}
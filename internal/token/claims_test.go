@@ -0,0 +1,55 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestClaims_Validate(t *testing.T) {
+	now := time.Now()
+	exp := Expectations{Issuer: "avito-shop", Audience: "avito-shop", Leeway: 30 * time.Second}
+	valid := func() *Claims {
+		return &Claims{
+			UserID: 1,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "id",
+				Issuer:    "avito-shop",
+				Audience:  jwt.ClaimStrings{"avito-shop"},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		modify func(c *Claims)
+		ok     bool
+	}{
+		"valid":                 {func(c *Claims) {}, true},
+		"expired within leeway": {func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second)) }, true},
+		"expired":               {func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }, false},
+		"issued in the future":  {func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute)) }, false},
+		"skewed issue time":     {func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(10 * time.Second)) }, true},
+		"not valid yet":         {func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) }, false},
+		"wrong issuer":          {func(c *Claims) { c.Issuer = "other" }, false},
+		"missing audience":      {func(c *Claims) { c.Audience = nil }, false},
+		"wrong audience":        {func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} }, false},
+		"one of audiences":      {func(c *Claims) { c.Audience = jwt.ClaimStrings{"other", "avito-shop"} }, true},
+		"missing id":            {func(c *Claims) { c.ID = "" }, false},
+		"missing user":          {func(c *Claims) { c.UserID = 0 }, false},
+	}
+	for name, tc := range cases {
+		c := valid()
+		tc.modify(c)
+		err := c.Validate(exp, now)
+		if tc.ok && err != nil {
+			t.Errorf("%s: expected valid claims, got %v", name, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrInvalidClaims) {
+			t.Errorf("%s: expected ErrInvalidClaims, got %v", name, err)
+		}
+	}
+}
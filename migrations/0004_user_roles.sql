@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'employee';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key VARCHAR(100) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS items (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(50) UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    price INTEGER NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO items (slug, name, price) VALUES
+    ('t-shirt', 'T-shirt', 80),
+    ('cup', 'Cup', 20),
+    ('book', 'Book', 50),
+    ('pen', 'Pen', 10),
+    ('powerbank', 'Powerbank', 200),
+    ('hoody', 'Hoody', 300),
+    ('umbrella', 'Umbrella', 200),
+    ('socks', 'Socks', 10),
+    ('wallet', 'Wallet', 50),
+    ('pink-hoody', 'Pink hoody', 500)
+ON CONFLICT (slug) DO NOTHING;
+
+-- +goose Down
+DROP TABLE IF EXISTS items;
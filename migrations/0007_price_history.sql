@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS price_history (
+    id SERIAL PRIMARY KEY,
+    item_id INTEGER NOT NULL REFERENCES items(id),
+    action VARCHAR(16) NOT NULL,
+    old_price INTEGER,
+    new_price INTEGER NOT NULL,
+    changed_by INTEGER REFERENCES users(id),
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS price_history_item_id_idx ON price_history (item_id, changed_at);
+
+-- items added before the history existed start it without an author
+INSERT INTO price_history (item_id, action, new_price, changed_at)
+SELECT id, 'created', price, created_at FROM items;
+
+-- +goose Down
+DROP TABLE IF EXISTS price_history;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- NULL means unlimited
+ALTER TABLE items ADD COLUMN IF NOT EXISTS stock INTEGER;
+ALTER TABLE items ADD COLUMN IF NOT EXISTS max_per_user INTEGER;
+
+-- +goose Down
+ALTER TABLE items DROP COLUMN IF EXISTS max_per_user;
+ALTER TABLE items DROP COLUMN IF EXISTS stock;
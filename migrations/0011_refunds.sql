@@ -0,0 +1,30 @@
+-- +goose Up
+ALTER TABLE order_lines ADD COLUMN IF NOT EXISTS refunded INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id),
+    -- the owner of the order or the admin who refunded it
+    refunded_by INTEGER NOT NULL REFERENCES users(id),
+    total INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS refunds_order_id_idx ON refunds (order_id);
+
+-- lines are refunded at the price of the order line
+CREATE TABLE IF NOT EXISTS refund_lines (
+    refund_id INTEGER NOT NULL REFERENCES refunds(id),
+    item_id INTEGER NOT NULL REFERENCES items(id),
+    item VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    quantity INTEGER NOT NULL,
+    price INTEGER NOT NULL,
+    total INTEGER NOT NULL,
+    PRIMARY KEY (refund_id, item_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS refund_lines;
+DROP TABLE IF EXISTS refunds;
+ALTER TABLE order_lines DROP COLUMN IF EXISTS refunded;
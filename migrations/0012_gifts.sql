@@ -0,0 +1,22 @@
+-- +goose Up
+-- set for orders bought as a gift for someone else
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS recipient_id INTEGER REFERENCES users(id);
+
+CREATE TABLE IF NOT EXISTS gifts (
+    id SERIAL PRIMARY KEY,
+    from_user_id INTEGER NOT NULL REFERENCES users(id),
+    to_user_id INTEGER NOT NULL REFERENCES users(id),
+    item VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    message VARCHAR(200),
+    -- set when the gift was bought rather than taken from the inventory
+    order_id INTEGER REFERENCES orders(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS gifts_from_user_id_idx ON gifts (from_user_id, id);
+CREATE INDEX IF NOT EXISTS gifts_to_user_id_idx ON gifts (to_user_id, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS gifts;
+ALTER TABLE orders DROP COLUMN IF EXISTS recipient_id;
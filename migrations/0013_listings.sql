@@ -0,0 +1,22 @@
+-- +goose Up
+-- items listed on the marketplace are held here, out of the seller's inventory,
+-- until the listing is sold or cancelled
+CREATE TABLE IF NOT EXISTS listings (
+    id SERIAL PRIMARY KEY,
+    seller_id INTEGER NOT NULL REFERENCES users(id),
+    item VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    price INTEGER NOT NULL CHECK (price > 0),
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    buyer_id INTEGER REFERENCES users(id),
+    -- the part of the price kept by the marketplace when sold
+    fee INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    closed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS listings_open_idx ON listings (id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS listings_seller_id_idx ON listings (seller_id, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS listings;
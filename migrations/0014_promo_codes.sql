@@ -0,0 +1,49 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL UNIQUE,
+    -- 'percent' takes value percent off, 'fixed' takes value coins off
+    kind VARCHAR(10) NOT NULL,
+    value INTEGER NOT NULL CHECK (value > 0),
+    -- NULL for no limit
+    max_redemptions INTEGER,
+    max_per_user INTEGER,
+    redemptions INTEGER NOT NULL DEFAULT 0,
+    valid_from TIMESTAMP,
+    valid_until TIMESTAMP,
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- codes without items apply to every item
+CREATE TABLE IF NOT EXISTS promo_code_items (
+    promo_code_id INTEGER NOT NULL REFERENCES promo_codes(id),
+    item_id INTEGER NOT NULL REFERENCES items(id),
+    PRIMARY KEY (promo_code_id, item_id)
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id SERIAL PRIMARY KEY,
+    promo_code_id INTEGER NOT NULL REFERENCES promo_codes(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    order_id INTEGER NOT NULL REFERENCES orders(id),
+    discount INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS promo_redemptions_code_user_idx ON promo_redemptions (promo_code_id, user_id);
+
+-- line totals are what was paid, after the discount of the line
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promo_code VARCHAR(50);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_lines ADD COLUMN IF NOT EXISTS discount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE refund_lines ADD COLUMN IF NOT EXISTS discount INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE refund_lines DROP COLUMN IF EXISTS discount;
+ALTER TABLE order_lines DROP COLUMN IF EXISTS discount;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount;
+ALTER TABLE orders DROP COLUMN IF EXISTS promo_code;
+DROP TABLE IF EXISTS promo_redemptions;
+DROP TABLE IF EXISTS promo_code_items;
+DROP TABLE IF EXISTS promo_codes;
@@ -0,0 +1,89 @@
+-- +goose Up
+-- 'user' accounts hold the coins of user_id; 'issuance' and 'revenue' are the
+-- single system accounts coins are granted from and paid to
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('user', 'issuance', 'revenue')),
+    user_id INTEGER UNIQUE REFERENCES users(id),
+    CHECK ((kind = 'user') = (user_id IS NOT NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accounts_system_idx ON ledger_accounts (kind) WHERE user_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    -- what the entry pays for, if anything
+    order_id INTEGER REFERENCES orders(id),
+    refund_id INTEGER REFERENCES refunds(id),
+    listing_id INTEGER REFERENCES listings(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- amounts are signed: a balance is the sum of the postings to the account, and
+-- the postings of an entry sum up to zero
+CREATE TABLE IF NOT EXISTS journal_postings (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER NOT NULL REFERENCES journal_entries(id),
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts(id),
+    amount INTEGER NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS journal_postings_entry_id_idx ON journal_postings (entry_id);
+CREATE INDEX IF NOT EXISTS journal_postings_account_id_idx ON journal_postings (account_id);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'the ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER journal_entries_append_only BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+CREATE TRIGGER journal_postings_append_only BEFORE UPDATE OR DELETE ON journal_postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION journal_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM journal_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- checked at commit, once all postings of the entry are in
+CREATE CONSTRAINT TRIGGER journal_postings_balanced AFTER INSERT ON journal_postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION journal_entry_balanced();
+
+INSERT INTO ledger_accounts (kind) VALUES ('issuance'), ('revenue');
+INSERT INTO ledger_accounts (kind, user_id) SELECT 'user', id FROM users;
+
+-- the balances of existing users are opened as issued coins
+INSERT INTO journal_entries (kind)
+SELECT 'opening' WHERE EXISTS (SELECT 1 FROM users WHERE coins <> 0);
+INSERT INTO journal_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, u.coins
+FROM journal_entries e, users u
+JOIN ledger_accounts a ON a.user_id = u.id
+WHERE e.kind = 'opening' AND u.coins <> 0;
+INSERT INTO journal_postings (entry_id, account_id, amount)
+SELECT e.id, a.id, -(SELECT SUM(coins) FROM users)
+FROM journal_entries e, ledger_accounts a
+WHERE e.kind = 'opening' AND a.kind = 'issuance'
+  AND (SELECT SUM(coins) FROM users) <> 0;
+
+-- +goose Down
+DROP TRIGGER IF EXISTS journal_postings_balanced ON journal_postings;
+DROP TRIGGER IF EXISTS journal_postings_append_only ON journal_postings;
+DROP TRIGGER IF EXISTS journal_entries_append_only ON journal_entries;
+DROP FUNCTION IF EXISTS journal_entry_balanced();
+DROP FUNCTION IF EXISTS ledger_append_only();
+DROP TABLE IF EXISTS journal_postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS ledger_accounts;
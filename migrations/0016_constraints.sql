@@ -0,0 +1,49 @@
+-- +goose Up
+-- rows of the same item are merged into the oldest one before they are made
+-- unique; rows of no user or with nothing in them are dropped
+DELETE FROM inventories WHERE user_id IS NULL OR quantity IS NULL OR quantity <= 0;
+UPDATE inventories i SET quantity = d.quantity
+FROM (
+    SELECT MIN(id) AS id, SUM(quantity) AS quantity
+    FROM inventories
+    GROUP BY user_id, item_type
+    HAVING COUNT(*) > 1
+) d
+WHERE i.id = d.id;
+DELETE FROM inventories i USING inventories kept
+WHERE kept.user_id = i.user_id AND kept.item_type = i.item_type AND kept.id < i.id;
+
+ALTER TABLE inventories
+    ALTER COLUMN user_id SET NOT NULL,
+    ALTER COLUMN quantity SET NOT NULL,
+    ADD CONSTRAINT inventories_quantity_nonnegative CHECK (quantity >= 0);
+CREATE UNIQUE INDEX IF NOT EXISTS inventories_user_id_item_type_idx ON inventories (user_id, item_type);
+
+-- balances are not rewritten here: negative ones fail the migration and have
+-- to be settled by hand
+ALTER TABLE users
+    ALTER COLUMN coins SET NOT NULL,
+    ADD CONSTRAINT users_coins_nonnegative CHECK (coins >= 0);
+
+ALTER TABLE coin_transactions
+    ALTER COLUMN user_id SET NOT NULL,
+    ADD CONSTRAINT coin_transactions_amount_positive CHECK (amount > 0),
+    ADD CONSTRAINT coin_transactions_type_check CHECK (transaction_type IN ('sent', 'received'));
+CREATE INDEX IF NOT EXISTS coin_transactions_user_id_type_idx ON coin_transactions (user_id, transaction_type);
+
+-- +goose Down
+DROP INDEX IF EXISTS coin_transactions_user_id_type_idx;
+ALTER TABLE coin_transactions
+    DROP CONSTRAINT IF EXISTS coin_transactions_type_check,
+    DROP CONSTRAINT IF EXISTS coin_transactions_amount_positive,
+    ALTER COLUMN user_id DROP NOT NULL;
+
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_coins_nonnegative,
+    ALTER COLUMN coins DROP NOT NULL;
+
+DROP INDEX IF EXISTS inventories_user_id_item_type_idx;
+ALTER TABLE inventories
+    DROP CONSTRAINT IF EXISTS inventories_quantity_nonnegative,
+    ALTER COLUMN quantity DROP NOT NULL,
+    ALTER COLUMN user_id DROP NOT NULL;
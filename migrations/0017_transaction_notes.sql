@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE coin_transactions ADD COLUMN IF NOT EXISTS note VARCHAR(200);
+
+-- the history is ordered by creation time, so it is kept as an instant set by
+-- the database clock alone; existing rows got CURRENT_TIMESTAMP in the time
+-- zone of the database
+UPDATE coin_transactions SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+ALTER TABLE coin_transactions
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP,
+    ALTER COLUMN created_at SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE coin_transactions
+    ALTER COLUMN created_at DROP NOT NULL,
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE coin_transactions DROP COLUMN IF EXISTS note;
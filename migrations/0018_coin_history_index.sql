@@ -0,0 +1,7 @@
+-- +goose Up
+-- serves the coin history newest first, a page at a time from a
+-- (created_at, id) cursor
+CREATE INDEX IF NOT EXISTS coin_transactions_user_id_created_at_id_idx ON coin_transactions (user_id, created_at, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS coin_transactions_user_id_created_at_id_idx;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- expired revocations are deleted on every revocation
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS revoked_tokens_expires_at_idx;
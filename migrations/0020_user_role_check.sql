@@ -0,0 +1,7 @@
+-- +goose Up
+-- unknown roles grant nothing, so they are taken for employees
+UPDATE users SET role = 'employee' WHERE role NOT IN ('employee', 'admin', 'auditor');
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('employee', 'admin', 'auditor'));
+
+-- +goose Down
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;